@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// accessLog receives NCSA combined log lines when TTS_ACCESS_LOG_FORMAT asks
+// for them; nil otherwise. Lines are written whole under mu.
+var accessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// accessLogFormat is TTS_ACCESS_LOG_FORMAT: "slog" (default) logs each
+// request as a structured line, "combined" writes NCSA combined log format
+// lines instead, and "both" does both.
+func accessLogFormat() string {
+	switch f := strings.ToLower(os.Getenv("TTS_ACCESS_LOG_FORMAT")); f {
+	case "combined", "both":
+		return f
+	default:
+		return "slog"
+	}
+}
+
+// openAccessLog sets up the combined access log at TTS_ACCESS_LOG: "stdout"
+// (default), "stderr" or a file path, appended to.
+func openAccessLog() error {
+	if accessLogFormat() == "slog" {
+		return nil
+	}
+	var w io.Writer
+	switch dest := envString("TTS_ACCESS_LOG", "stdout"); dest {
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	accessLog.mu.Lock()
+	accessLog.w = w
+	accessLog.mu.Unlock()
+	return nil
+}
+
+// writeAccessLog writes one combined log line for a completed request:
+// client, identity, user, time received, request line, status, bytes,
+// referer and user agent.
+func writeAccessLog(r *http.Request, start time.Time, status int, bytes int64) {
+	accessLog.mu.Lock()
+	defer accessLog.mu.Unlock()
+	if accessLog.w == nil {
+		return
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	fmt.Fprintf(accessLog.w, "%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		clientIP(r, envBool("TTS_TRUST_PROXY", false)),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		logEscape(r.Method), logEscape(r.RequestURI), logEscape(r.Proto),
+		status, size,
+		logField(r.Referer()), logField(r.UserAgent()))
+}
+
+// logField is s escaped for a quoted log field, or "-" when empty.
+func logField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return logEscape(s)
+}
+
+// logEscape escapes quotes, backslashes and non-printable bytes as Apache
+// does, so a client can't forge or break log lines.
+func logEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == utf8.RuneError && size == 1, r < 0x20, r == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", s[i])
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+)
+
+// flushCount is how much one cache tier gave up in a flush.
+type flushCount struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+type flushResponse struct {
+	Memory flushCount `json:"memory"`
+	Disk   flushCount `json:"disk"`
+}
+
+// handleCacheFlush clears cached audio from memory and disk so a lexicon or
+// voice change takes effect without a restart. ?lang= and ?provider= limit
+// the flush to matching entries. It is only registered when TTS_ADMIN_TOKENS
+// is set, so it always sits behind requireAdmin.
+func handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	lang, provider := r.URL.Query().Get("lang"), r.URL.Query().Get("provider")
+	match := func(e *cachedAudio) bool {
+		return (lang == "" || e.lang == lang) && (provider == "" || e.provider == provider)
+	}
+
+	var resp flushResponse
+	resp.Memory.Entries, resp.Memory.Bytes = ttsCache.flush(match)
+	if ttsCache.disk != nil {
+		resp.Disk.Entries, resp.Disk.Bytes = ttsCache.disk.flush(match)
+	}
+	logFrom(r.Context()).Info("cache flushed", "lang", lang, "provider", provider,
+		"memory_entries", resp.Memory.Entries, "disk_entries", resp.Disk.Entries)
+	writeJSON(w, http.StatusOK, resp)
+}
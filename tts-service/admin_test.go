@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheFlushByProvider(t *testing.T) {
+	c := newAudioCache(16, 1<<20)
+	c.disk = newDiskCache(t.TempDir(), 1<<20)
+	withCache(t, c)
+	c.add(&cachedAudio{key: "a", data: []byte("aaaa"), contentType: "audio/wav", provider: "espeak", lang: "deva"})
+	c.add(&cachedAudio{key: "b", data: []byte("bb"), contentType: "audio/mpeg", provider: "sarvam", lang: "deva"})
+
+	rec := httptest.NewRecorder()
+	handleCacheFlush(rec, httptest.NewRequest(http.MethodPost, "/admin/cache/flush?provider=espeak", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp flushResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Memory != (flushCount{Entries: 1, Bytes: 4}) {
+		t.Errorf("memory = %+v", resp.Memory)
+	}
+	if resp.Disk.Entries != 1 || resp.Disk.Bytes <= 4 {
+		t.Errorf("disk = %+v", resp.Disk)
+	}
+	if _, ok := c.get("a"); ok {
+		t.Error("espeak entry survived the flush")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Error("sarvam entry was flushed")
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	withCache(t, newAudioCache(16, 1<<20))
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("RIFF-" + text))
+		return err
+	})
+
+	body := `{"items":[{"text":"राम","lang":"deva"},{"text":"नमः","lang":"deva"},{"text":"","lang":"deva"}]}`
+	rec := httptest.NewRecorder()
+	handleWarmup(rec, httptest.NewRequest(http.MethodPost, "/admin/warmup", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var job warmupJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		rec := httptest.NewRecorder()
+		handleWarmup(rec, httptest.NewRequest(http.MethodGet, "/admin/warmup/"+job.ID, nil))
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		return job.State == "done"
+	})
+	if job.Total != 3 || job.Done != 3 || job.Failed != 1 {
+		t.Errorf("job = %+v", job)
+	}
+	prepared, _ := prepareTTS(ttsRequest{Text: "राम", Lang: "deva"}, "mac")
+	if _, ok := ttsCache.get(prepared.key); !ok {
+		t.Error("warmed item is not cached")
+	}
+
+	rec = httptest.NewRecorder()
+	handleWarmup(rec, httptest.NewRequest(http.MethodGet, "/admin/warmup/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authTokensFromEnv returns the consumer tokens in TTS_AUTH_TOKENS
+// (comma-separated), or nil when authentication is disabled.
+func authTokensFromEnv() []string {
+	return tokensFromEnv("TTS_AUTH_TOKENS")
+}
+
+// adminTokensFromEnv returns the operator tokens in TTS_ADMIN_TOKENS, the
+// only ones the /admin/ endpoints accept. They are kept apart from the
+// consumer tokens so a metered consumer can't read other keys' usage,
+// flush the shared cache or start unmetered warmups.
+func adminTokensFromEnv() []string {
+	return tokensFromEnv("TTS_ADMIN_TOKENS")
+}
+
+func tokensFromEnv(name string) []string {
+	var tokens []string
+	for _, t := range strings.Split(os.Getenv(name), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// tokenDigests hashes tokens for matchToken.
+func tokenDigests(tokens []string) [][32]byte {
+	sums := make([][32]byte, len(tokens))
+	for i, t := range tokens {
+		sums[i] = sha256.Sum256([]byte(t))
+	}
+	return sums
+}
+
+// matchToken looks up the request's "Authorization: Bearer <token>" in
+// sums, returning its index. present reports whether there was a bearer
+// token at all. Fixed-size digests are compared so neither the match nor
+// the token length leaks through timing.
+func matchToken(r *http.Request, sums [][32]byte) (idx int, present, ok bool) {
+	token, present := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !present {
+		return 0, false, false
+	}
+	got := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	match := 0
+	for i, sum := range sums {
+		eq := subtle.ConstantTimeCompare(got[:], sum[:])
+		match |= eq
+		idx = subtle.ConstantTimeSelect(eq, i, idx)
+	}
+	return idx, true, match == 1
+}
+
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="tts"`)
+	writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid bearer token")
+}
+
+// requireToken rejects requests without an "Authorization: Bearer <token>"
+// header naming one of tokens. Probes, /version and CORS preflights, which
+// browsers send without credentials, are let through, as are /admin/
+// paths, which requireAdmin guards with the operator tokens instead.
+// Authenticated requests carry the token's ID in their context for usage
+// metering.
+func requireToken(tokens []string, next http.Handler) http.Handler {
+	sums := tokenDigests(tokens)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/version" ||
+			strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		idx, _, ok := matchToken(r, sums)
+		if !ok {
+			writeUnauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withAuthKey(r.Context(), tokenID(sums[idx]))))
+	})
+}
+
+// requireAdmin lets through only requests carrying one of the operator
+// tokens. Any other bearer token, including a consumer's, gets 403.
+func requireAdmin(tokens []string, next http.Handler) http.Handler {
+	sums := tokenDigests(tokens)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, present, ok := matchToken(r, sums)
+		switch {
+		case ok:
+			next.ServeHTTP(w, r)
+		case present:
+			writeError(w, http.StatusForbidden, "forbidden", "this token may not use the admin endpoints")
+		default:
+			writeUnauthorized(w)
+		}
+	})
+}
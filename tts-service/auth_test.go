@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := requireToken([]string{"alpha", "beta"}, ok)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		want   int
+	}{
+		{"first token", http.MethodPost, "/api/tts", "Bearer alpha", http.StatusOK},
+		{"second token", http.MethodPost, "/api/tts", "Bearer beta", http.StatusOK},
+		{"wrong token", http.MethodPost, "/api/tts", "Bearer gamma", http.StatusUnauthorized},
+		{"token prefix", http.MethodPost, "/api/tts", "Bearer alph", http.StatusUnauthorized},
+		{"not bearer", http.MethodPost, "/api/tts", "Basic alpha", http.StatusUnauthorized},
+		{"missing", http.MethodGet, "/api/voices", "", http.StatusUnauthorized},
+		{"preflight", http.MethodOptions, "/api/tts", "", http.StatusOK},
+		{"health probe", http.MethodGet, "/healthz", "", http.StatusOK},
+		{"version", http.MethodGet, "/version", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("/api/tts", ok)
+	for _, path := range []string{"/admin/usage", "/admin/cache/flush", "/admin/warmup"} {
+		mux.Handle(path, requireAdmin([]string{"operator"}, ok))
+	}
+	h := requireToken([]string{"alpha"}, mux)
+
+	tests := []struct {
+		path string
+		auth string
+		want int
+	}{
+		{"/admin/usage", "Bearer operator", http.StatusOK},
+		{"/admin/usage", "Bearer alpha", http.StatusForbidden},
+		{"/admin/cache/flush", "Bearer alpha", http.StatusForbidden},
+		{"/admin/warmup", "Bearer alpha", http.StatusForbidden},
+		{"/admin/usage", "", http.StatusUnauthorized},
+		{"/api/tts", "Bearer alpha", http.StatusOK},
+		{"/api/tts", "Bearer operator", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s with %q: status %d, want %d", tt.path, tt.auth, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestUsageQuota(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	u := newUsageTracker(24*time.Hour, 3, 10, path)
+	prev := usage
+	usage = u
+	t.Cleanup(func() { usage = prev })
+
+	synth := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordRunes(r.Context(), 4)
+	})
+	h := requireToken([]string{"alpha", "beta"}, meterUsage(u, synth))
+	call := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/tts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 4 runes a request: alpha reaches the 10-rune quota on its third.
+	for i := 0; i < 3; i++ {
+		if rec := call("alpha"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, rec.Code)
+		}
+	}
+	rec := call("alpha")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota: status %d, Retry-After %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := call("beta"); rec.Code != http.StatusOK {
+		t.Errorf("other key: status %d, want 200", rec.Code)
+	}
+
+	alpha, beta := tokenID(sha256.Sum256([]byte("alpha"))), tokenID(sha256.Sum256([]byte("beta")))
+	rec = httptest.NewRecorder()
+	handleUsage(rec, httptest.NewRequest(http.MethodGet, "/admin/usage", nil))
+	var snap usageSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("status %d: %v", rec.Code, err)
+	}
+	if snap.Keys[alpha] != (keyUsage{Requests: 3, Runes: 12}) || snap.Keys[beta] != (keyUsage{Requests: 1, Runes: 4}) {
+		t.Errorf("usage = %+v", snap.Keys)
+	}
+	if !snap.WindowEnd.Equal(snap.WindowStart.Add(24 * time.Hour)) {
+		t.Errorf("window %v to %v, want one day", snap.WindowStart, snap.WindowEnd)
+	}
+
+	if err := u.save(); err != nil {
+		t.Fatal(err)
+	}
+	restored := newUsageTracker(24*time.Hour, 3, 10, path)
+	if err := restored.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.snapshot().Keys[alpha]; got.Requests != 3 {
+		t.Errorf("restored alpha = %+v", got)
+	}
+
+	// A new window starts from zero.
+	restored.start = restored.start.Add(-48 * time.Hour)
+	if _, ok := restored.admit(alpha); !ok {
+		t.Error("quota not reset in a new window")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// azureVoiceNames maps our language codes to Azure neural voices.
+var azureVoiceNames = map[string]string{
+	"deva": "hi-IN-MadhurNeural",
+	"iast": "en-IN-PrabhatNeural",
+	"knda": "kn-IN-SapnaNeural",
+	"tel":  "te-IN-MohanNeural",
+	"tam":  "ta-IN-ValluvarNeural",
+	"guj":  "gu-IN-NiranjanNeural",
+	"pan":  "pa-IN-OjasNeural",
+	"mr":   "mr-IN-ManoharNeural",
+	"ben":  "bn-IN-BashkarNeural",
+	"mal":  "ml-IN-MidhunNeural",
+}
+
+// azureOutputFormats maps our formats to X-Microsoft-OutputFormat values.
+// Ogg Vorbis isn't offered, so ogg is transcoded from MP3.
+var azureOutputFormats = map[string]string{
+	"mp3":  "audio-24khz-48kbitrate-mono-mp3",
+	"wav":  "riff-24khz-16bit-mono-pcm",
+	"opus": "ogg-24khz-16bit-mono-opus",
+}
+
+// azureVoice returns the voice configured for lang, AZURE_TTS_VOICE or the
+// built-in voice for lang.
+func azureVoice(lang string) string {
+	if v := configuredVoice(lang, "azure").Voice; v != "" {
+		return v
+	}
+	if v := os.Getenv("AZURE_TTS_VOICE"); v != "" {
+		return v
+	}
+	if v, ok := azureVoiceNames[lang]; ok {
+		return v
+	}
+	return azureVoiceNames["deva"]
+}
+
+// synthesizeWithAzure uses the Azure Cognitive Services speech REST API.
+// It expects AZURE_TTS_KEY and AZURE_TTS_REGION and writes MP3 unless another
+// format is requested.
+func synthesizeWithAzure(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+	key, region := os.Getenv("AZURE_TTS_KEY"), os.Getenv("AZURE_TTS_REGION")
+	if key == "" || region == "" {
+		return fmt.Errorf("AZURE_TTS_KEY and AZURE_TTS_REGION must be set")
+	}
+
+	format := resolveFormat(req.Format, "mp3")
+	output, native := azureOutputFormats[format]
+	if !native {
+		output = azureOutputFormats["mp3"]
+	}
+	voice := firstNonEmpty(req.voice, azureVoice(req.Lang))
+	langCode := firstNonEmpty(configuredVoice(req.Lang, "azure").LanguageCode, sarvamLangCode(req.Lang))
+	ssml := azureSSML(text, req.SSML, langCode, voice, resolveProsody("azure", req))
+	reportVoice(ctx, voice, langCode, 0)
+
+	endpoint := azureBaseURL(region) + "/cognitiveservices/v1"
+	post := func(auth func(*http.Request)) (*http.Response, error) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(ssml))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/ssml+xml")
+		r.Header.Set("X-Microsoft-OutputFormat", output)
+		r.Header.Set("User-Agent", "avabodhak-tts")
+		auth(r)
+		return http.DefaultClient.Do(r)
+	}
+
+	if err := waitProvider(ctx, "azure", text); err != nil {
+		return err
+	}
+	resp, err := post(func(r *http.Request) { r.Header.Set("Ocp-Apim-Subscription-Key", key) })
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Some resources only accept bearer tokens issued for the key.
+		resp.Body.Close()
+		token, err := azureTokens.get(ctx, region, key)
+		if err != nil {
+			return err
+		}
+		resp, err = post(func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) })
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		logFrom(ctx).Warn("tts http error", "status", resp.StatusCode, "body", strings.TrimSpace(string(msg)))
+		err := fmt.Errorf("azure tts status %d", resp.StatusCode)
+		// Azure answers an unknown voice with a bare 400, so look it up.
+		if resp.StatusCode == http.StatusBadRequest && !azureHasVoice(ctx, voice) {
+			return &voiceUnavailableError{voice: voice, err: err}
+		}
+		return err
+	}
+
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	if !native {
+		return transcode(ctx, w, resp.Body, format)
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return err
+	}
+	logFrom(ctx).Info("synthesized", "runes", len([]rune(text)), "lang", req.Lang, "voice", voice, "bytes", n)
+	return nil
+}
+
+// azureSSML builds the request document: text (plain or the content of an
+// SSML document) read by voice, wrapped in <prosody> when needed.
+func azureSSML(text string, isSSMLText bool, lang, voice string, pros prosody) string {
+	var body string
+	if isSSMLText {
+		body = innerSpeak(text)
+	} else {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(text))
+		body = b.String()
+	}
+
+	var attrs []string
+	if pros.Rate != 1 {
+		attrs = append(attrs, fmt.Sprintf(`rate="%+d%%"`, int(math.Round((pros.Rate-1)*100))))
+	}
+	if pros.Pitch != 0 {
+		attrs = append(attrs, fmt.Sprintf(`pitch="%+dst"`, int(math.Round(pros.Pitch))))
+	}
+	if pros.Volume != 0 {
+		// Azure takes relative volume as a percentage change in amplitude.
+		attrs = append(attrs, fmt.Sprintf(`volume="%+d%%"`, int(math.Round((gainToAmplitude(pros.Volume)-1)*100))))
+	}
+	if len(attrs) > 0 {
+		body = "<prosody " + strings.Join(attrs, " ") + ">" + body + "</prosody>"
+	}
+	return `<speak version="1.0" xmlns="http://www.w3.org/2001/10/synthesis" xml:lang="` + lang + `">` +
+		`<voice name="` + voice + `">` + body + `</voice></speak>`
+}
+
+// azureTokenCache holds the bearer token issued for the subscription key.
+// Tokens are valid for ten minutes; they are renewed after nine.
+type azureTokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+var azureTokens azureTokenCache
+
+func (c *azureTokenCache) get(ctx context.Context, region, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	url := "https://" + region + ".api.cognitive.microsoft.com/sts/v1.0/issueToken"
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("Ocp-Apim-Subscription-Key", key)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("azure token status %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expires = string(bytes.TrimSpace(b)), time.Now().Add(9*time.Minute)
+	return c.token, nil
+}
+
+// azureBaseURL is the speech endpoint for region, or AZURE_TTS_BASE_URL
+// (e.g. a private endpoint or a test server).
+func azureBaseURL(region string) string {
+	return envString("AZURE_TTS_BASE_URL", "https://"+region+".tts.speech.microsoft.com")
+}
+
+// azureHasVoice reports whether voice is in the region's voice list,
+// assuming it is when the list can't be fetched.
+func azureHasVoice(ctx context.Context, voice string) bool {
+	voices, err := azureVoiceList(ctx)
+	if err != nil {
+		return true
+	}
+	return slices.ContainsFunc(voices, func(v voiceInfo) bool { return v.Name == voice })
+}
+
+// azureVoiceList lists the voices of the configured region.
+func azureVoiceList(ctx context.Context) ([]voiceInfo, error) {
+	key, region := os.Getenv("AZURE_TTS_KEY"), os.Getenv("AZURE_TTS_REGION")
+	if key == "" || region == "" {
+		return nil, fmt.Errorf("AZURE_TTS_KEY and AZURE_TTS_REGION must be set")
+	}
+	url := azureBaseURL(region) + "/cognitiveservices/voices/list"
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Ocp-Apim-Subscription-Key", key)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("azure voices status %d", resp.StatusCode)
+	}
+
+	var list []struct {
+		ShortName string `json:"ShortName"`
+		Gender    string `json:"Gender"`
+		Locale    string `json:"Locale"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	voices := make([]voiceInfo, 0, len(list))
+	for _, v := range list {
+		voices = append(voices, voiceInfo{Name: v.ShortName, Languages: []string{v.Locale}, Gender: strings.ToLower(v.Gender)})
+	}
+	return voices, nil
+}
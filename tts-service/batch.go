@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+type batchRequest struct {
+	Items []ttsRequest `json:"items"`
+}
+
+// batchResult is the outcome of one batch item: audio or an error.
+type batchResult struct {
+	ContentType string    `json:"contentType,omitempty"`
+	DurationMs  int64     `json:"durationMs,omitempty"`
+	Audio       string    `json:"audio,omitempty"`
+	Provider    string    `json:"provider,omitempty"`
+	Error       *apiError `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// handleTTSBatch synthesizes several texts in one round trip for preloading.
+// Items are validated and synthesized independently, so one bad item doesn't
+// fail the batch; results are returned in item order. TTS_BATCH_MAX (default
+// 50) caps the number of items.
+func handleTTSBatch(w http.ResponseWriter, r *http.Request) {
+	if reqID := r.Header.Get("X-Request-Id"); reqID != "" {
+		w.Header().Set("X-Request-Id", reqID)
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	maxItems := envInt("TTS_BATCH_MAX", 50)
+	limit := envInt64("TTS_MAX_BODY_BYTES", defaultMaxBodyBytes) * int64(max(1, maxItems))
+	var batch batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(&batch); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body exceeds %d bytes", limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_json", "invalid JSON")
+		return
+	}
+	if len(batch.Items) == 0 {
+		writeError(w, http.StatusBadRequest, "items_required", "items are required")
+		return
+	}
+	if len(batch.Items) > maxItems {
+		writeError(w, http.StatusBadRequest, "batch_too_large", fmt.Sprintf("at most %d items per batch", maxItems))
+		return
+	}
+
+	// Local providers are bounded by synthSlots inside renderWith; this
+	// bounds how many items are in progress at once for network providers.
+	results := make([]batchResult, len(batch.Items))
+	sem := make(chan struct{}, cap(synthSlots))
+	var wg sync.WaitGroup
+	provider := activeProvider()
+	for i, item := range batch.Items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item ttsRequest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = synthesizeBatchItem(r.Context(), item, provider)
+		}(i, item)
+	}
+	wg.Wait()
+
+	extendWriteDeadline(w, 0)
+	writeJSON(w, http.StatusOK, batchResponse{Results: results})
+}
+
+func synthesizeBatchItem(ctx context.Context, item ttsRequest, provider string) batchResult {
+	job, perr := prepareTTS(item, provider)
+	if perr != nil {
+		return batchResult{Error: &perr.apiError}
+	}
+	recordRunes(ctx, len([]rune(job.req.Text)))
+	entry, err := synthesizeJob(ctx, job)
+	if serr := synthesisError(ctx, job.provider, err); serr != nil {
+		return batchResult{Error: &serr.apiError}
+	}
+	res := batchResult{
+		ContentType: entry.contentType,
+		Audio:       base64.StdEncoding.EncodeToString(entry.data),
+		Provider:    entry.provider,
+	}
+	if d, ok := audioDuration(entry.data, entry.contentType); ok {
+		res.DurationMs = d.Milliseconds()
+	}
+	return res
+}
+
+// synthesisError maps an error from synthesizeJob to the status and API
+// error reported for it, logging failures. It returns nil for a nil error.
+func synthesisError(ctx context.Context, provider string, err error) *requestError {
+	var open *circuitOpenError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errBusy):
+		return &requestError{http.StatusServiceUnavailable, apiError{Code: "busy", Message: "tts busy, retry later"}}
+	case errors.As(err, &open):
+		return &requestError{http.StatusServiceUnavailable, apiError{Code: "provider_unavailable", Message: "tts provider unavailable, retry later"}}
+	case errors.Is(err, errSynthesisTimeout):
+		logFrom(ctx).Error("tts timeout", "provider", provider, "err", err)
+		return &requestError{http.StatusGatewayTimeout, apiError{Code: "synthesis_timeout", Message: "tts timed out"}}
+	}
+	logFrom(ctx).Error("tts error", "provider", provider, "err", err)
+	return &requestError{http.StatusInternalServerError, synthesisFailed(err)}
+}
+
+// synthesizeJob returns the audio for job from the cache or by rendering it
+// into memory, walking the fallback chain. Like handleTTS it shares the
+// synthesis with identical in-flight requests.
+func synthesizeJob(ctx context.Context, job *ttsJob) (*cachedAudio, error) {
+	if entry, ok := ttsCache.get(job.key); ok {
+		return entry, nil
+	}
+
+	flightCtx, release := synthFlights.join(job.key)
+	stopWatching := context.AfterFunc(ctx, release)
+	defer func() {
+		stopWatching()
+		release()
+	}()
+
+	logger := logFrom(ctx)
+	v, err, _ := synthGroup.Do(job.key, func() (any, error) {
+		timeout := synthesisTimeout(job.provider, len(job.chunks))
+		sctx, cancel := context.WithTimeout(flightCtx, timeout)
+		defer cancel()
+		sctx = withLogger(sctx, logger)
+		sctx, info := withSynthesisInfo(sctx)
+		logger := logger.With("provider", job.provider)
+		logger.Info("synthesis deadline", "timeout", timeout)
+		entry, err := renderBuffered(sctx, logger, job, info, fallbackChain(job.provider))
+		return entry, timeoutError(sctx, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*cachedAudio), nil
+}
+
+// renderBuffered renders job into memory with the first provider in chain
+// (its fallback chain, or what is left of it) that succeeds.
+func renderBuffered(ctx context.Context, logger *slog.Logger, job *ttsJob, info *synthesisInfo, chain []string) (*cachedAudio, error) {
+	var err error
+	for i, p := range chain {
+		if i > 0 {
+			logger.Warn("provider failed, falling back", "failed", chain[i-1], "err", err, "fallback", p)
+		}
+		buf := newResponseBuffer()
+		if err = renderWith(ctx, p, buf, job.text, job.chunks, job.req); err != nil {
+			continue
+		}
+		var entry *cachedAudio
+		if entry, err = finishClip(ctx, logger, job, p, buf.buf.Bytes(), buf.header.Get("Content-Type"), info, false); err == nil {
+			return entry, nil
+		}
+	}
+	return nil, err
+}
+
+// finishClip turns the audio p rendered for job into its cache entry: it
+// checks the duration limit, post-processes the clip and caches audio from
+// the primary provider. Fallback audio isn't cached, so the primary is
+// retried next time. When the clip was already streamed to the client the
+// entry returned is the audio as sent, and only the cached copy is
+// post-processed.
+func finishClip(ctx context.Context, logger *slog.Logger, job *ttsJob, p string, data []byte, contentType string, info *synthesisInfo, streamed bool) (*cachedAudio, error) {
+	if err := checkDuration(job.req, data, contentType); err != nil {
+		return nil, err
+	}
+	entry := &cachedAudio{key: job.key, data: data, contentType: contentType, provider: p, lang: job.req.Lang, bitrate: job.req.Bitrate, info: completeInfo(*info, data, contentType)}
+	logger.Info("synthesized clip", entry.info.logAttrs()...)
+	cached := entry
+	if postProcessing() {
+		norm, nerr := postProcess(ctx, entry, job.req.SampleRateHertz)
+		switch {
+		case nerr != nil:
+			// Serve the audio as rendered, but don't cache it under the
+			// post-processed key.
+			logger.Warn("post-processing failed", "err", nerr)
+			cached = nil
+		case streamed:
+			cached = norm
+		default:
+			entry, cached = norm, norm
+		}
+	}
+	if p == job.provider && cached != nil {
+		ttsCache.add(cached)
+	}
+	return entry, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// Breaker states, as reported by the tts_circuit_breaker_state metric.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = [...]string{"closed", "open", "half-open"}
+
+// breakers guard the network providers, whose outages otherwise cost every
+// request a full timeout. Local providers fail fast on their own.
+var breakers = map[string]*breaker{
+	"sarvam":     newBreaker("sarvam"),
+	"polly":      newBreaker("polly"),
+	"azure":      newBreaker("azure"),
+	"openai":     newBreaker("openai"),
+	"elevenlabs": newBreaker("elevenlabs"),
+}
+
+// circuitOpenError is returned without calling a provider whose breaker is
+// open.
+type circuitOpenError struct {
+	provider   string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s circuit open, retry in %s", e.provider, e.retryAfter.Round(time.Second))
+}
+
+// retryAfterSeconds is the Retry-After value, rounded up.
+func (e *circuitOpenError) retryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.retryAfter.Seconds())))
+}
+
+// breaker trips after TTS_BREAKER_THRESHOLD (default 5) consecutive
+// failures and then rejects calls for TTS_BREAKER_COOLDOWN (default 30s).
+// After the cooldown one call is let through as a probe: success closes the
+// breaker, failure reopens it. A threshold of 0 disables the breaker.
+type breaker struct {
+	provider string
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(provider string) *breaker {
+	ttsBreakerState.WithLabelValues(provider).Set(breakerClosed)
+	return &breaker{provider: provider}
+}
+
+// allow reports whether a call may go ahead, returning a *circuitOpenError
+// when it may not.
+func (b *breaker) allow() error {
+	if b == nil || envInt("TTS_BREAKER_THRESHOLD", 5) <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		wait := envDuration("TTS_BREAKER_COOLDOWN", 30*time.Second) - time.Since(b.openedAt)
+		if wait > 0 {
+			return &circuitOpenError{provider: b.provider, retryAfter: wait}
+		}
+		b.setState(breakerHalfOpen)
+		return nil
+	case breakerHalfOpen:
+		// A probe is already in flight.
+		return &circuitOpenError{provider: b.provider, retryAfter: time.Second}
+	}
+	return nil
+}
+
+// record notes the outcome of a call that allow let through. Calls cut
+// short because every client went away say nothing about the provider.
+func (b *breaker) record(ctx context.Context, err error) {
+	if b == nil || envInt("TTS_BREAKER_THRESHOLD", 5) <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err == nil:
+		b.failures = 0
+		b.setState(breakerClosed)
+	case errors.Is(ctx.Err(), context.Canceled):
+		if b.state == breakerHalfOpen {
+			// Let the next call probe instead.
+			b.setState(breakerOpen)
+			b.openedAt = time.Time{}
+		}
+	case b.state == breakerHalfOpen:
+		b.setState(breakerOpen)
+		b.openedAt = time.Now()
+	default:
+		b.failures++
+		if b.failures >= envInt("TTS_BREAKER_THRESHOLD", 5) {
+			b.failures = 0
+			b.setState(breakerOpen)
+			b.openedAt = time.Now()
+		}
+	}
+}
+
+// stateName reports the breaker state for /readyz.
+func (b *breaker) stateName() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return breakerStateNames[b.state]
+}
+
+func (b *breaker) setState(state int) {
+	if b.state != state {
+		slog.Warn("circuit breaker state change", "provider", b.provider, "from", breakerStateNames[b.state], "to", breakerStateNames[state])
+	}
+	b.state = state
+	ttsBreakerState.WithLabelValues(b.provider).Set(float64(state))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerTripsAndRecovers(t *testing.T) {
+	t.Setenv("TTS_BREAKER_THRESHOLD", "2")
+	t.Setenv("TTS_BREAKER_COOLDOWN", "1h")
+	ctx := context.Background()
+	failed := errors.New("503 from provider")
+	b := newBreaker("test")
+
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("call %d rejected before the threshold: %v", i, err)
+		}
+		b.record(ctx, failed)
+	}
+	var open *circuitOpenError
+	if err := b.allow(); !errors.As(err, &open) {
+		t.Fatalf("allow after %d failures = %v, want circuit open", 2, err)
+	}
+
+	// Once the cooldown has passed a single probe is let through.
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe rejected after cooldown: %v", err)
+	}
+	if err := b.allow(); err == nil {
+		t.Fatal("second call allowed while the probe is in flight")
+	}
+	b.record(ctx, nil)
+	if got := b.stateName(); got != "closed" {
+		t.Errorf("state after successful probe = %s, want closed", got)
+	}
+
+	// Calls abandoned by their clients don't count as failures.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for i := 0; i < 3; i++ {
+		b.record(cancelled, context.Canceled)
+	}
+	if err := b.allow(); err != nil {
+		t.Errorf("cancelled calls tripped the breaker: %v", err)
+	}
+}
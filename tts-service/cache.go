@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ttsCache holds rendered audio shared by all requests. The memory tier is
+// disabled when TTS_CACHE_MAX_ENTRIES or TTS_CACHE_MAX_BYTES is zero; setting
+// TTS_CACHE_DIR adds a disk tier bounded by TTS_CACHE_DIR_MAX_BYTES.
+var ttsCache = func() *audioCache {
+	c := newAudioCache(
+		envInt("TTS_CACHE_MAX_ENTRIES", 256),
+		envInt64("TTS_CACHE_MAX_BYTES", 64<<20),
+	)
+	c.disk = newDiskCache(os.Getenv("TTS_CACHE_DIR"), envInt64("TTS_CACHE_DIR_MAX_BYTES", 1<<30))
+	return c
+}()
+
+// cachedAudio is a single rendered clip, the provider that produced it and
+// the language it was requested in.
+type cachedAudio struct {
+	key         string
+	data        []byte
+	contentType string
+	provider    string
+	lang        string
+	loudness    string // LUFS measured before normalization, if applied
+	bitrate     int    // MP3 kbps the clip was encoded at; 0 for the encoder default
+	info        synthesisInfo
+}
+
+// audioCache is an LRU cache of rendered audio bounded by both entry count
+// and total byte size, optionally backed by a disk cache.
+type audioCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	ll         *list.List
+	items      map[string]*list.Element
+	disk       *diskCache
+}
+
+func newAudioCache(maxEntries int, maxBytes int64) *audioCache {
+	return &audioCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *audioCache) enabled() bool {
+	return c.maxEntries > 0 && c.maxBytes > 0
+}
+
+// get returns the entry for key and marks it as most recently used. Memory
+// misses fall through to the disk cache, and disk hits are promoted.
+func (c *audioCache) get(key string) (*cachedAudio, bool) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cachedAudio), true
+	}
+	c.mu.Unlock()
+	if c.disk == nil {
+		return nil, false
+	}
+	entry, ok := c.disk.get(key)
+	if ok {
+		c.store(entry)
+	}
+	return entry, ok
+}
+
+// add stores entry in memory and on disk.
+func (c *audioCache) add(entry *cachedAudio) {
+	c.store(entry)
+	if c.disk != nil {
+		c.disk.put(entry)
+	}
+}
+
+// store adds entry to memory, evicting least recently used entries until
+// both limits are satisfied. Clips larger than the byte limit are not kept.
+func (c *audioCache) store(entry *cachedAudio) {
+	if !c.enabled() || int64(len(entry.data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[entry.key]; ok {
+		c.removeElement(el)
+	}
+	c.items[entry.key] = c.ll.PushFront(entry)
+	c.size += int64(len(entry.data))
+	for c.ll.Len() > c.maxEntries || c.size > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// flush removes the in-memory entries for which match returns true,
+// reporting how many were removed and their total size.
+func (c *audioCache) flush(match func(*cachedAudio) bool) (entries int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.items {
+		if entry := el.Value.(*cachedAudio); match(entry) {
+			entries++
+			bytes += int64(len(entry.data))
+			c.removeElement(el)
+		}
+	}
+	return entries, bytes
+}
+
+func (c *audioCache) removeElement(el *list.Element) {
+	entry := el.Value.(*cachedAudio)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+// cacheKey hashes every input that affects the rendered audio.
+func cacheKey(text string, req ttsRequest, provider string) string {
+	voice := os.Getenv("TTS_VOICE")
+	pros := resolveProsody(provider, req)
+	parts := []string{
+		keyText(text), req.Lang, req.Granularity, provider, voice, req.Format, strconv.FormatBool(req.SSML), strconv.Itoa(req.SampleRateHertz), strconv.Itoa(req.Channels), req.pause.String(),
+		strconv.Itoa(req.LeadSilenceMs), strconv.Itoa(req.TrailSilenceMs), strconv.Itoa(len(req.Texts)),
+		formatProsodyValue(pros.Rate), formatProsodyValue(pros.Pitch), formatProsodyValue(pros.Volume),
+		lexiconVersion(), voiceConfigVersion(), trimKey(), loudnessKey(),
+	}
+	if req.Bitrate != 0 {
+		parts = append(parts, "bitrate="+strconv.Itoa(req.Bitrate))
+	}
+	if provider == "polly" {
+		engine := pollyEngine()
+		parts = append(parts, pollyVoiceID(req.Lang, engine), string(engine), strconv.Itoa(pollyPCMSampleRate()))
+	}
+	if provider == "azure" {
+		parts = append(parts, azureVoice(req.Lang))
+	}
+	if provider == "espeak" {
+		voice, _ := espeakVoiceFor(req.Lang)
+		parts = append(parts, withVariant(voice, espeakVariant(req)), strconv.Itoa(espeakWordGap()))
+	}
+	if provider == "piper" {
+		parts = append(parts, piperModel(req.Lang))
+	}
+	if provider == "openai" {
+		parts = append(parts, openAIVoice(req.Lang), openAIModel())
+	}
+	if provider == "elevenlabs" {
+		parts = append(parts, elevenLabsVoice(req.Lang), elevenLabsModel(), os.Getenv("ELEVENLABS_STABILITY"), os.Getenv("ELEVENLABS_SIMILARITY"))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyText is text as far as the cache key is concerned: NFC, with spaces
+// and tabs collapsed and each line trimmed, so requests that differ only
+// in spacing share an entry. Line breaks are kept, as lines and segments
+// are synthesized separately. Providers still get the text as sent.
+func keyText(text string) string {
+	lines := strings.Split(strings.TrimSpace(norm.NFC.String(text)), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
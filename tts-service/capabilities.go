@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+type capabilitiesResponse struct {
+	Provider      string          `json:"provider"`
+	Providers     []string        `json:"providers"` // selectable with the provider field
+	NativeFormat  string          `json:"nativeFormat"`
+	Formats       []string        `json:"formats"`
+	Languages     []string        `json:"languages"`
+	Granularities []string        `json:"granularities"`
+	MaxTextRunes  int             `json:"maxTextRunes,omitempty"`
+	Features      map[string]bool `json:"features"`
+}
+
+// handleTTSOptions answers OPTIONS /api/tts with what this deployment
+// supports, so frontends can feature-detect instead of assuming. cors has
+// already set the CORS headers; the body is extra to the preflight.
+func handleTTSOptions(w http.ResponseWriter, r *http.Request) {
+	provider := activeProvider()
+	override := envBool("TTS_ALLOW_PROVIDER_OVERRIDE", false)
+	resp := capabilitiesResponse{
+		Provider:      provider,
+		Providers:     []string{provider},
+		NativeFormat:  nativeFormats[provider],
+		Formats:       availableFormats(provider),
+		Languages:     append([]string{"auto"}, langCodes()...),
+		Granularities: []string{"verse", "line", "word", "syllable"},
+		MaxTextRunes:  envInt("TTS_MAX_TEXT", 2500),
+		Features: map[string]bool{
+			"ssml":             true,
+			"timepoints":       true,
+			"batch":            true,
+			"texts":            true,
+			"phonemes":         true,
+			"dryRun":           true,
+			"ssmlUpload":       true,
+			"bitrateVariants":  ffmpegAvailable(),
+			"transcoding":      ffmpegAvailable(),
+			"multipart":        true,
+			"transliterate":    true,
+			"providerOverride": override,
+			"strictLang":       strictMode(),
+		},
+	}
+	if override {
+		resp.Providers = resp.Providers[:0]
+		for name := range providers {
+			if len(providerMissing(name)) == 0 {
+				resp.Providers = append(resp.Providers, name)
+			}
+		}
+		sort.Strings(resp.Providers)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
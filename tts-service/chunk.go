@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxChunkRunes is the longest text handed to a synthesizer in one call
+// (TTS_MAX_TEXT_RUNES, default 800). Longer input is split by splitText and
+// the clips are joined.
+var maxChunkRunes = max(1, envInt("TTS_MAX_TEXT_RUNES", 800))
+
+// errUnsplittable is returned when a single word exceeds maxChunkRunes.
+var errUnsplittable = errors.New("text contains an unsplittable segment")
+
+// isChunkBoundary reports whether r ends a sentence or pada.
+func isChunkBoundary(r rune) bool {
+	switch r {
+	case '।', '॥', '\n', '.', '!', '?':
+		return true
+	}
+	return false
+}
+
+// splitText breaks text into pieces of at most max runes, preferring danda,
+// newline and sentence boundaries and falling back to whitespace inside an
+// over-long sentence.
+func splitText(text string, max int) ([]string, error) {
+	if len([]rune(text)) <= max {
+		return []string{text}, nil
+	}
+
+	var chunks []string
+	var cur []rune
+	flush := func() {
+		if s := strings.TrimSpace(string(cur)); s != "" {
+			chunks = append(chunks, s)
+		}
+		cur = cur[:0]
+	}
+
+	for _, sentence := range splitAfterBoundaries(text) {
+		runes := []rune(sentence)
+		if len(runes) <= max {
+			if len(cur)+len(runes) > max {
+				flush()
+			}
+			cur = append(cur, runes...)
+			continue
+		}
+
+		flush()
+		for _, word := range strings.Fields(sentence) {
+			wr := []rune(word)
+			if len(wr) > max {
+				return nil, errUnsplittable
+			}
+			if len(cur)+1+len(wr) > max {
+				flush()
+			}
+			if len(cur) > 0 {
+				cur = append(cur, ' ')
+			}
+			cur = append(cur, wr...)
+		}
+		flush()
+	}
+	flush()
+	return chunks, nil
+}
+
+// splitPadas splits a verse after each danda, then splits any pada longer
+// than max as splitText does.
+func splitPadas(text string, max int) ([]string, error) {
+	var chunks []string
+	start := 0
+	for i, r := range text {
+		if r != '।' && r != '॥' {
+			continue
+		}
+		end := i + len(string(r))
+		if pada := strings.TrimSpace(text[start:end]); pada != "" && !isDandas(pada) {
+			parts, err := splitText(pada, max)
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, parts...)
+		} else if len(chunks) > 0 {
+			// A run of dandas ("। ।", "॥") stays with the preceding pada.
+			chunks[len(chunks)-1] += pada
+		}
+		start = end
+	}
+	if rest := strings.TrimSpace(text[start:]); rest != "" {
+		parts, err := splitText(rest, max)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, parts...)
+	}
+	return chunks, nil
+}
+
+func isDandas(s string) bool {
+	return strings.Trim(s, "।॥ ") == ""
+}
+
+// splitAfterBoundaries cuts text after every boundary rune, keeping the
+// boundary with the preceding sentence.
+func splitAfterBoundaries(text string) []string {
+	var parts []string
+	start := 0
+	for i, r := range text {
+		if isChunkBoundary(r) {
+			end := i + len(string(r))
+			parts = append(parts, text[start:end])
+			start = end
+		}
+	}
+	if start < len(text) {
+		parts = append(parts, text[start:])
+	}
+	return parts
+}
+
+// synthesizeChunks renders each chunk separately and joins the clips, with
+// req.pause of silence between them. WAV and MP3 clips are joined directly;
+// other formats, and MP3 with pauses, are rendered as WAV and transcoded once
+// after joining.
+func synthesizeChunks(ctx context.Context, provider string, chunks []string, req ttsRequest) ([]byte, string, error) {
+	format := resolveFormat(req.Format, nativeFormats[provider])
+	chunkReq := req
+	chunkReq.Format = "wav"
+	if format == "mp3" && req.pause == 0 {
+		// Pauses are spliced in as PCM silence, so they need WAV clips.
+		chunkReq.Format = "mp3"
+	}
+
+	clips := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		buf := newResponseBuffer()
+		if err := synthesize(ctx, provider, buf, chunk, chunkReq); err != nil {
+			if req.segments != nil {
+				return nil, "", &segmentError{index: req.segments[i], err: err}
+			}
+			return nil, "", fmt.Errorf("chunk %d/%d: %w", i+1, len(chunks), err)
+		}
+		clips[i] = buf.buf.Bytes()
+	}
+
+	var data []byte
+	if chunkReq.Format == "mp3" {
+		data = concatMP3(clips)
+	} else {
+		joined, err := concatWAV(clips, req.pause)
+		if err != nil {
+			return nil, "", err
+		}
+		data = joined
+	}
+
+	if format != chunkReq.Format {
+		var out bytes.Buffer
+		if err := transcode(ctx, &out, bytes.NewReader(data), format); err != nil {
+			return nil, "", err
+		}
+		data = out.Bytes()
+	}
+	return data, audioContentTypes[format], nil
+}
+
+// concatMP3 joins MP3 clips frame-wise, dropping the ID3v2 tag of every clip
+// after the first so players don't stop at an embedded tag.
+func concatMP3(clips [][]byte) []byte {
+	var out bytes.Buffer
+	for i, clip := range clips {
+		if i > 0 {
+			clip = stripID3v2(clip)
+		}
+		out.Write(clip)
+	}
+	return out.Bytes()
+}
+
+// stripID3v2 removes a leading ID3v2 tag, if present.
+func stripID3v2(b []byte) []byte {
+	if len(b) < 10 || string(b[:3]) != "ID3" {
+		return b
+	}
+	// Tag size is a 28-bit syncsafe integer excluding the 10-byte header.
+	size := int(b[6]&0x7f)<<21 | int(b[7]&0x7f)<<14 | int(b[8]&0x7f)<<7 | int(b[9]&0x7f)
+	n := 10 + size
+	if b[5]&0x10 != 0 {
+		n += 10 // footer present
+	}
+	if n > len(b) {
+		return b
+	}
+	return b[n:]
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// errBusy is returned when no synthesis slot is available.
+var errBusy = errors.New("synthesis capacity exhausted")
+
+// localProviders spawn a process per synthesis and share synthSlots. Network
+// providers are not gated since they cost no local CPU.
+var localProviders = map[string]bool{"espeak": true, "mac": true, "piper": true}
+
+// synthSlots bounds concurrent local syntheses (TTS_MAX_CONCURRENCY, default
+// one per CPU).
+var synthSlots = make(chan struct{}, max(1, envInt("TTS_MAX_CONCURRENCY", runtime.NumCPU())))
+
+// rejectWhenBusy selects TTS_CONCURRENCY_MODE=reject: fail immediately with
+// errBusy instead of queueing until the synthesis context ends.
+var rejectWhenBusy = os.Getenv("TTS_CONCURRENCY_MODE") == "reject"
+
+// acquireSlot takes a synthesis slot for local providers and returns the
+// function that gives it back.
+func acquireSlot(ctx context.Context, provider string) (func(), error) {
+	if !localProviders[provider] {
+		return func() {}, nil
+	}
+	release := func() { <-synthSlots }
+	select {
+	case synthSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if rejectWhenBusy {
+		return nil, errBusy
+	}
+	select {
+	case synthSlots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", errBusy, ctx.Err())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	corsAllowMethods  = "GET, POST, OPTIONS"
+	corsAllowHeaders  = "Content-Type, Authorization, X-Requested-With, X-Request-Id"
+	corsExposeHeaders = "X-Request-Id, X-TTS-Provider, X-TTS-Cache, X-TTS-Lang, X-TTS-Lang-Detected, X-TTS-Granularity, X-TTS-Voice, X-TTS-Language-Code, X-TTS-Sample-Rate, X-TTS-Transliterated, X-Audio-Duration-Ms, ETag, Retry-After, Content-Disposition"
+)
+
+// corsPolicy decides which browser origins may call the service.
+type corsPolicy struct {
+	origins     []string // nil allows any origin
+	credentials bool
+	maxAge      time.Duration
+}
+
+// corsFromEnv reads TTS_CORS_ORIGINS (comma-separated; unset allows any
+// origin), TTS_CORS_CREDENTIALS and TTS_CORS_MAX_AGE (preflight cache time).
+func corsFromEnv() corsPolicy {
+	var p corsPolicy
+	for _, o := range strings.Split(os.Getenv("TTS_CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			p.origins = append(p.origins, o)
+		}
+	}
+	p.credentials = envBool("TTS_CORS_CREDENTIALS", false)
+	p.maxAge = envDuration("TTS_CORS_MAX_AGE", 0)
+	return p
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or ""
+// when the origin isn't allowed.
+func (p corsPolicy) allowOrigin(origin string) string {
+	if p.origins == nil {
+		return "*"
+	}
+	if origin != "" && slices.Contains(p.origins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// cors sets CORS headers on every response and answers preflight requests,
+// with 204 except on /api/tts, which describes its capabilities. Credentials
+// are only allowed for listed origins, never with "*".
+func cors(p corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		allowed := p.allowOrigin(r.Header.Get("Origin"))
+		if p.origins != nil {
+			h.Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			h.Set("Access-Control-Allow-Origin", allowed)
+			h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			if p.credentials && allowed != "*" {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method == http.MethodOptions {
+			if allowed != "" {
+				h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+				h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				if p.maxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+				}
+			}
+			if r.URL.Path == "/api/tts" {
+				handleTTSOptions(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import "unicode"
+
+// scriptLangs maps the scripts we read to our language codes. Marathi shares
+// Devanagari, so it can't be told apart from Hindi or Sanskrit and is
+// detected as deva.
+var scriptLangs = []struct {
+	script *unicode.RangeTable
+	lang   string
+}{
+	{unicode.Devanagari, "deva"},
+	{unicode.Kannada, "knda"},
+	{unicode.Telugu, "tel"},
+	{unicode.Tamil, "tam"},
+	{unicode.Gujarati, "guj"},
+	{unicode.Gurmukhi, "pan"},
+	{unicode.Bengali, "ben"},
+	{unicode.Malayalam, "mal"},
+	{unicode.Latin, "iast"},
+}
+
+// detectLang picks the language for lang "auto" from the script most of
+// text's letters are written in. Digits, punctuation and the dandas, which
+// every Indic script shares, don't count. Text with no letters in a known
+// script is read as Hindi.
+func detectLang(text string) string {
+	counts := make([]int, len(scriptLangs))
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) {
+			continue
+		}
+		for i, s := range scriptLangs {
+			if unicode.Is(s.script, r) {
+				counts[i]++
+				break
+			}
+		}
+	}
+	best := -1
+	for i, n := range counts {
+		if n > 0 && (best < 0 || n > counts[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "deva"
+	}
+	return scriptLangs[best].lang
+}
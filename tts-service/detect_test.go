@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectLang(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"धर्मक्षेत्रे कुरुक्षेत्रे ।", "deva"},
+		{"ಶ್ರೀ ಗಣೇಶಾಯ ನಮಃ", "knda"},
+		{"శ్రీ రామ", "tel"},
+		{"ஸ்ரீ ராம", "tam"},
+		{"ശ്രീ രാമ", "mal"},
+		{"ਸ੍ਰੀ ਰਾਮ", "pan"},
+		{"શ્રી રામ", "guj"},
+		{"শ্রী রাম", "ben"},
+		{"dharmakṣetre kurukṣetre", "iast"},
+		// The majority script wins over a stray word in another.
+		{"राम राम राम Rama", "deva"},
+		{"१२३ ॥", "deva"},
+		{"", "deva"},
+	}
+	for _, tt := range tests {
+		if got := detectLang(tt.text); got != tt.want {
+			t.Errorf("detectLang(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAutoLang(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+	var gotLang string
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		gotLang = req.Lang
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("RIFF-audio"))
+		return err
+	})
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"ಶ್ರೀ ಗಣೇಶಾಯ ನಮಃ","lang":"auto"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-TTS-Lang-Detected"); got != "knda" {
+		t.Errorf("X-TTS-Lang-Detected = %q, want knda", got)
+	}
+	if gotLang != "knda" {
+		t.Errorf("synthesizer lang = %q, want knda", gotLang)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskCache keeps rendered audio on disk so it survives restarts. Each clip
+// is stored as <key>.audio with a <key>.json sidecar describing it. Hits
+// touch the files' modification time, and a periodic sweep removes the least
+// recently used clips once the directory exceeds maxBytes, so the limit can
+// be overshot briefly between sweeps.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex // serializes sweeps
+}
+
+// diskMeta is the sidecar stored next to each clip.
+type diskMeta struct {
+	ContentType string `json:"contentType"`
+	Provider    string `json:"provider"`
+	Lang        string `json:"lang,omitempty"`
+	Loudness    string `json:"loudness,omitempty"`
+	DurationMs  int64  `json:"durationMs,omitempty"`
+
+	Voice        string `json:"voice,omitempty"`
+	LanguageCode string `json:"languageCode,omitempty"`
+	SampleRate   int    `json:"sampleRate,omitempty"`
+}
+
+// newDiskCache returns a cache rooted at dir, or nil when dir is empty or
+// can't be created.
+func newDiskCache(dir string, maxBytes int64) *diskCache {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.Error("disk cache disabled", "dir", dir, "err", err)
+		return nil
+	}
+	return &diskCache{dir: dir, maxBytes: maxBytes}
+}
+
+func (d *diskCache) paths(key string) (audio, meta string) {
+	base := filepath.Join(d.dir, key)
+	return base + ".audio", base + ".json"
+}
+
+// get loads the clip stored under key and marks it as recently used.
+func (d *diskCache) get(key string) (*cachedAudio, bool) {
+	audioPath, metaPath := d.paths(key)
+	raw, err := os.ReadFile(metaPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("disk cache read failed", "key", key, "err", err)
+		}
+		return nil, false
+	}
+	var meta diskMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		slog.Warn("disk cache metadata corrupt", "key", key, "err", err)
+		return nil, false
+	}
+	data, err := os.ReadFile(audioPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("disk cache read failed", "key", key, "err", err)
+		}
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(audioPath, now, now)
+	os.Chtimes(metaPath, now, now)
+	return &cachedAudio{key: key, data: data, contentType: meta.ContentType, provider: meta.Provider, lang: meta.Lang, loudness: meta.Loudness,
+		info: synthesisInfo{Provider: meta.Provider, Voice: meta.Voice, LanguageCode: meta.LanguageCode, SampleRate: meta.SampleRate}}, true
+}
+
+// put stores entry. The audio is written before its sidecar, and each file
+// is renamed into place, so get never sees a partial clip.
+func (d *diskCache) put(entry *cachedAudio) {
+	meta := diskMeta{ContentType: entry.contentType, Provider: entry.provider, Lang: entry.lang, Loudness: entry.loudness,
+		Voice: entry.info.Voice, LanguageCode: entry.info.LanguageCode, SampleRate: entry.info.SampleRate}
+	if dur, ok := audioDuration(entry.data, entry.contentType); ok {
+		meta.DurationMs = dur.Milliseconds()
+	}
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return
+	}
+	audioPath, metaPath := d.paths(entry.key)
+	if err := d.writeFile(audioPath, entry.data); err != nil {
+		slog.Warn("disk cache write failed", "key", entry.key, "err", err)
+		return
+	}
+	if err := d.writeFile(metaPath, raw); err != nil {
+		slog.Warn("disk cache write failed", "key", entry.key, "err", err)
+		os.Remove(audioPath)
+	}
+}
+
+func (d *diskCache) writeFile(path string, data []byte) error {
+	f, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// sweep evicts the least recently used clips until the directory fits in
+// maxBytes, and removes temp files abandoned by interrupted writes.
+func (d *diskCache) sweep() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		slog.Warn("disk cache sweep failed", "dir", d.dir, "err", err)
+		return
+	}
+	type clip struct {
+		key     string
+		size    int64
+		touched time.Time
+	}
+	clips := map[string]*clip{}
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		name := e.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			if time.Since(info.ModTime()) > time.Hour {
+				os.Remove(filepath.Join(d.dir, name))
+			}
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimSuffix(name, ".audio"), ".json")
+		c := clips[key]
+		if c == nil {
+			c = &clip{key: key}
+			clips[key] = c
+		}
+		c.size += info.Size()
+		if info.ModTime().After(c.touched) {
+			c.touched = info.ModTime()
+		}
+		total += info.Size()
+	}
+	if total <= d.maxBytes {
+		return
+	}
+
+	lru := make([]*clip, 0, len(clips))
+	for _, c := range clips {
+		lru = append(lru, c)
+	}
+	sort.Slice(lru, func(i, j int) bool { return lru[i].touched.Before(lru[j].touched) })
+	evicted := 0
+	for _, c := range lru {
+		if total <= d.maxBytes {
+			break
+		}
+		audioPath, metaPath := d.paths(c.key)
+		os.Remove(metaPath)
+		os.Remove(audioPath)
+		total -= c.size
+		evicted++
+	}
+	slog.Info("disk cache swept", "evicted", evicted, "bytes", total)
+}
+
+// flush removes the clips whose sidecar matches, reporting how many were
+// removed and the bytes reclaimed. match sees the clip without its audio.
+func (d *diskCache) flush(match func(*cachedAudio) bool) (entries int, bytes int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	metas, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		return 0, 0
+	}
+	for _, metaPath := range metas {
+		raw, err := os.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		var meta diskMeta
+		if json.Unmarshal(raw, &meta) != nil {
+			continue
+		}
+		key := strings.TrimSuffix(filepath.Base(metaPath), ".json")
+		if !match(&cachedAudio{key: key, contentType: meta.ContentType, provider: meta.Provider, lang: meta.Lang}) {
+			continue
+		}
+		audioPath, _ := d.paths(key)
+		for _, p := range []string{metaPath, audioPath} {
+			if info, err := os.Stat(p); err == nil && os.Remove(p) == nil {
+				bytes += info.Size()
+			}
+		}
+		entries++
+	}
+	return entries, bytes
+}
+
+// cleanLoop sweeps the cache every interval until ctx is done.
+func (d *diskCache) cleanLoop(ctx context.Context, interval time.Duration) {
+	d.sweep()
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			d.sweep()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	c := newAudioCache(16, 1<<20)
+	c.disk = newDiskCache(dir, 1<<20)
+	c.add(&cachedAudio{key: "k", data: []byte("audio"), contentType: "audio/wav", provider: "espeak"})
+
+	// A fresh cache over the same directory stands in for a restart.
+	restarted := newAudioCache(16, 1<<20)
+	restarted.disk = newDiskCache(dir, 1<<20)
+	entry, ok := restarted.get("k")
+	if !ok {
+		t.Fatal("entry not found on disk")
+	}
+	if !bytes.Equal(entry.data, []byte("audio")) || entry.contentType != "audio/wav" || entry.provider != "espeak" {
+		t.Errorf("got %q %q %q", entry.data, entry.contentType, entry.provider)
+	}
+	if _, ok := restarted.items["k"]; !ok {
+		t.Error("disk hit not promoted to memory")
+	}
+}
+
+func TestDiskCacheSweepEvictsLeastRecentlyUsed(t *testing.T) {
+	d := newDiskCache(t.TempDir(), 1<<20)
+	old := time.Now().Add(-time.Hour)
+	for _, key := range []string{"old", "new"} {
+		d.put(&cachedAudio{key: key, data: make([]byte, 600<<10), contentType: "audio/mpeg"})
+		if key == "old" {
+			audioPath, metaPath := d.paths(key)
+			os.Chtimes(audioPath, old, old)
+			os.Chtimes(metaPath, old, old)
+		}
+	}
+
+	d.sweep()
+	if _, ok := d.get("old"); ok {
+		t.Error("least recently used clip was kept")
+	}
+	if _, ok := d.get("new"); !ok {
+		t.Error("recently used clip was evicted")
+	}
+}
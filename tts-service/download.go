@@ -0,0 +1,109 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// downloadNameWords is how many words of the text name a download when the
+// request gives no name.
+const downloadNameWords = 4
+
+// downloadName is the file name, without extension, for req's audio: its
+// Name, or the first few words of its text, reduced to letters, digits and
+// dashes (Devanagari and other scripts are kept). It falls back to "audio".
+func downloadName(req ttsRequest) string {
+	name := req.Name
+	if name == "" {
+		text := req.Text
+		if req.SSML {
+			text = ssmlToText(text, nil)
+		}
+		words := strings.Fields(text)
+		name = strings.Join(words[:min(len(words), downloadNameWords)], " ")
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	name = b.String()
+	if runes := []rune(name); len(runes) > 64 {
+		name = strings.TrimRight(string(runes[:64]), "-")
+	}
+	if name == "" {
+		return "audio"
+	}
+	return name
+}
+
+// audioExtension returns the file extension for an audio Content-Type, or ""
+// for anything else. Types we don't produce ourselves, such as a proxy's
+// "audio/x-wav", are matched on the media type.
+func audioExtension(contentType string) string {
+	if format := formatForContentType(contentType); format != "" {
+		return format
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "audio/wav", "audio/x-wav", "audio/wave":
+		return "wav"
+	case "audio/mpeg":
+		return "mp3"
+	case "audio/ogg":
+		return "ogg"
+	case "audio/webm":
+		return "webm"
+	}
+	return ""
+}
+
+// downloadWriter marks audio responses as attachments named name, with the
+// extension of the Content-Type actually sent, so a fallback provider's
+// format still gets the right one. JSON and error responses are untouched.
+type downloadWriter struct {
+	http.ResponseWriter
+	name        string
+	wroteHeader bool
+}
+
+func (d *downloadWriter) WriteHeader(status int) {
+	if !d.wroteHeader {
+		d.wroteHeader = true
+		if ext := audioExtension(d.Header().Get("Content-Type")); ext != "" {
+			d.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": d.name + "." + ext}))
+		}
+	}
+	d.ResponseWriter.WriteHeader(status)
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if !d.wroteHeader {
+		d.WriteHeader(http.StatusOK)
+	}
+	return d.ResponseWriter.Write(p)
+}
+
+// Flush passes through so streamed audio isn't held back by the wrapper.
+func (d *downloadWriter) Flush() {
+	if f, ok := d.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (d *downloadWriter) Unwrap() http.ResponseWriter { return d.ResponseWriter }
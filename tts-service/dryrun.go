@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// synthesisPlan is what a dryRun request returns: everything preprocessing
+// resolved, without synthesizing. Nothing is sent to the provider, so dry
+// runs cost no provider quota or CPU (they still count as a request in
+// per-token usage).
+type synthesisPlan struct {
+	Provider            string   `json:"provider"`
+	Voice               string   `json:"voice,omitempty"`
+	Lang                string   `json:"lang"`
+	LangDetected        string   `json:"langDetected,omitempty"`
+	LanguageCode        string   `json:"languageCode,omitempty"` // BCP 47 code sent to cloud providers
+	Granularity         string   `json:"granularity,omitempty"`
+	Format              string   `json:"format"`
+	SSML                bool     `json:"ssml"`
+	Text                string   `json:"text"`   // normalized, transliterated text
+	Chunks              []string `json:"chunks"` // each as sent to the provider, lexicon applied
+	PauseMs             int64    `json:"pauseMs,omitempty"`
+	EstimatedDurationMs int64    `json:"estimatedDurationMs"`
+	Key                 string   `json:"key"` // cache key, also the GET ETag
+}
+
+// planFor describes how job would be synthesized.
+func planFor(job *ttsJob) synthesisPlan {
+	req := job.req
+	plan := synthesisPlan{
+		Provider:            job.provider,
+		Voice:               plannedVoice(job.provider, req),
+		Lang:                req.Lang,
+		LangDetected:        job.detected,
+		Granularity:         req.Granularity,
+		Format:              resolveFormat(req.Format, nativeFormats[job.provider]),
+		SSML:                req.SSML,
+		Text:                job.text,
+		Chunks:              make([]string, len(job.chunks)),
+		PauseMs:             req.pause.Milliseconds(),
+		EstimatedDurationMs: req.estimate.Milliseconds(),
+		Key:                 job.key,
+	}
+	if l, ok := lookupLang(req.Lang); ok {
+		plan.LanguageCode = l.bcp47
+	}
+	for i, chunk := range job.chunks {
+		plan.Chunks[i] = applyLexicon(chunk, req.Lang, job.provider, req.SSML)
+	}
+	return plan
+}
+
+// plannedVoice is the voice provider would use for req, as each provider
+// resolves it. Fallback candidates are only tried when synthesis fails, so
+// this is the first choice.
+func plannedVoice(provider string, req ttsRequest) string {
+	switch provider {
+	case "espeak":
+		voice, _ := espeakVoiceFor(req.Lang)
+		return withVariant(voice, espeakVariant(req))
+	case "mac":
+		return macVoice(req.Lang)
+	case "sarvam":
+		return sarvamSpeaker(req.Lang)
+	case "polly":
+		return pollyVoiceID(req.Lang, pollyEngine())
+	case "azure":
+		return azureVoice(req.Lang)
+	case "piper":
+		if model := piperModel(req.Lang); model != "" {
+			return filepath.Base(model)
+		}
+	case "openai":
+		return openAIVoice(req.Lang)
+	case "elevenlabs":
+		return elevenLabsVoice(req.Lang)
+	case "fake":
+		return "fake"
+	}
+	return ""
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setDurationHeader sets X-Audio-Duration-Ms when the clip's length can be
+// read from its container.
+func setDurationHeader(h http.Header, entry *cachedAudio) {
+	if d, ok := audioDuration(entry.data, entry.contentType); ok {
+		h.Set("X-Audio-Duration-Ms", strconv.FormatInt(d.Milliseconds(), 10))
+	}
+}
+
+// audioDuration returns the playback length of a clip: from the fmt and data
+// chunks for WAV, or by walking frame headers for MP3.
+func audioDuration(data []byte, contentType string) (time.Duration, bool) {
+	switch contentType {
+	case audioContentTypes["wav"]:
+		wav, err := parseWAV(data)
+		if err != nil {
+			return 0, false
+		}
+		return wav.duration()
+	case audioContentTypes["mp3"]:
+		return mp3Duration(data)
+	}
+	return 0, false
+}
+
+// duration computes the length of the PCM data from the fmt byte rate.
+func (w *wavAudio) duration() (time.Duration, bool) {
+	if len(w.format) < 16 {
+		return 0, false
+	}
+	byteRate := int64(binary.LittleEndian.Uint32(w.format[8:12]))
+	if byteRate == 0 {
+		return 0, false
+	}
+	return time.Duration(int64(len(w.data)) * int64(time.Second) / byteRate), true
+}
+
+// MP3 frame header tables, indexed by the header's bitrate and sample-rate
+// fields. "v1" is MPEG-1; "v2" covers MPEG-2 and MPEG-2.5.
+var (
+	mp3BitratesV1 = [4][16]int{
+		3: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448}, // Layer I
+		2: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},    // Layer II
+		1: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},     // Layer III
+	}
+	mp3BitratesV2 = [4][16]int{
+		3: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256}, // Layer I
+		2: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},      // Layer II
+		1: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},      // Layer III
+	}
+	mp3SampleRates = map[int][3]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// mp3Frame describes one MPEG audio frame header.
+type mp3Frame struct {
+	size       int // bytes, including the header
+	samples    int
+	sampleRate int
+}
+
+// parseMP3Frame decodes the 4-byte frame header at the start of b.
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := int(b[1]>>3) & 3
+	layer := int(b[1]>>1) & 3
+	bitrateIdx := int(b[2] >> 4)
+	rateIdx := int(b[2]>>2) & 3
+	padding := int(b[2]>>1) & 1
+	rates, ok := mp3SampleRates[version]
+	if !ok || layer == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return mp3Frame{}, false
+	}
+
+	bitrates := mp3BitratesV2
+	if version == 3 {
+		bitrates = mp3BitratesV1
+	}
+	f := mp3Frame{sampleRate: rates[rateIdx]}
+	bitrate := bitrates[layer][bitrateIdx] * 1000
+	switch {
+	case layer == 3: // Layer I uses 4-byte slots
+		f.samples = 384
+		f.size = (12*bitrate/f.sampleRate + padding) * 4
+	case layer == 1 && version != 3: // Layer III, MPEG-2/2.5
+		f.samples = 576
+		f.size = 72*bitrate/f.sampleRate + padding
+	default:
+		f.samples = 1152
+		f.size = 144*bitrate/f.sampleRate + padding
+	}
+	return f, f.size > 4
+}
+
+// mp3Duration sums the samples of every frame in an MP3 stream, skipping a
+// leading ID3v2 tag and resynchronizing over junk between frames.
+func mp3Duration(data []byte) (time.Duration, bool) {
+	data = stripID3v2(data)
+	var total time.Duration
+	frames := 0
+	for off := 0; off+4 <= len(data); {
+		f, ok := parseMP3Frame(data[off:])
+		if !ok {
+			off++
+			continue
+		}
+		total += time.Duration(int64(f.samples) * int64(time.Second) / int64(f.sampleRate))
+		frames++
+		off += f.size
+	}
+	return total, frames > 0
+}
+
+// estimatedRuneDuration is roughly how long a provider takes to read one
+// rune of Indic text at rate 1.
+const estimatedRuneDuration = 80 * time.Millisecond
+
+// maxDuration is TTS_MAX_DURATION_MS, the longest clip a request may ask
+// for (default 10 minutes; 0 disables the limit).
+func maxDuration() time.Duration {
+	return time.Duration(envInt("TTS_MAX_DURATION_MS", 600000)) * time.Millisecond
+}
+
+// estimateDuration projects how long job's clip will play: its spoken runes
+// at the effective rate, plus SSML breaks, pauses spliced between chunks and
+// silence padding.
+func estimateDuration(job *ttsJob) time.Duration {
+	req := job.req
+	var breaks time.Duration
+	spoken := job.text
+	if req.SSML {
+		spoken = ssmlToText(job.text, func(d time.Duration) string {
+			breaks += d
+			return " "
+		})
+	}
+	rate := resolveProsody(job.provider, req).Rate
+	if rate <= 0 {
+		rate = 1
+	}
+	d := time.Duration(float64(len([]rune(spoken))) * float64(estimatedRuneDuration) / rate)
+	d += breaks + time.Duration(max(0, len(job.chunks)-1))*req.pause
+	return d + time.Duration(req.LeadSilenceMs+req.TrailSilenceMs)*time.Millisecond
+}
+
+// limitDuration rejects job if its projected duration exceeds maxDuration,
+// and records the estimate so checkDuration can catch runaway renders.
+func limitDuration(job *ttsJob) (*ttsJob, *requestError) {
+	job.req.estimate = estimateDuration(job)
+	if limit := maxDuration(); limit > 0 && job.req.estimate > limit {
+		return nil, &requestError{http.StatusBadRequest, apiError{
+			Code:          "duration_too_long",
+			Message:       fmt.Sprintf("estimated duration %s exceeds the limit", job.req.estimate.Round(time.Second)),
+			MaxDurationMs: limit.Milliseconds(),
+		}}
+	}
+	return job, nil
+}
+
+// errDurationExceeded is returned for a render far longer than its text
+// could take to read, such as a provider stuck repeating itself.
+var errDurationExceeded = errors.New("rendered audio far exceeds its estimated duration")
+
+// checkDuration fails a rendered clip that plays for more than the limit, or
+// several times longer than estimated. Clips whose duration can't be read
+// pass.
+func checkDuration(req ttsRequest, data []byte, contentType string) error {
+	d, ok := audioDuration(data, contentType)
+	if !ok || req.estimate == 0 {
+		return nil
+	}
+	allowed := 3*req.estimate + 5*time.Second
+	if limit := maxDuration(); limit > 0 {
+		allowed = min(allowed, limit+5*time.Second)
+	}
+	if d > allowed {
+		return fmt.Errorf("%w: %s rendered, %s estimated", errDurationExceeded, d.Round(time.Millisecond), req.estimate.Round(time.Millisecond))
+	}
+	return nil
+}
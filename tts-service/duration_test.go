@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudioDuration(t *testing.T) {
+	wav, err := os.ReadFile("testdata/tone-500ms.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// espeak-ng --stdout can't seek back, so its RIFF and data sizes are placeholders.
+	streamed := append([]byte(nil), wav...)
+	binary.LittleEndian.PutUint32(streamed[4:8], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(streamed[40:44], 0xFFFFFFFF)
+
+	mp3, err := os.ReadFile("testdata/silence-10frames.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        time.Duration
+	}{
+		{"wav", wav, "audio/wav", 500 * time.Millisecond},
+		{"streamed wav", streamed, "audio/wav", 500 * time.Millisecond},
+		// 10 frames of 1152 samples at 44.1 kHz.
+		{"mp3", mp3, "audio/mpeg", 261 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := audioDuration(tt.data, tt.contentType)
+			if !ok {
+				t.Fatal("duration not determined")
+			}
+			if got.Milliseconds() != tt.want.Milliseconds() {
+				t.Fatalf("duration = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := audioDuration([]byte("not audio"), "audio/wav"); ok {
+		t.Fatal("expected no duration for garbage input")
+	}
+}
+
+func TestDurationLimits(t *testing.T) {
+	t.Setenv("TTS_MAX_DURATION_MS", "60000")
+	text := strings.Repeat("नमः ", 100) // 400 runes: 32s at rate 1, 128s at 0.25
+	if _, perr := prepareTTS(ttsRequest{Text: text, Lang: "deva"}, "espeak"); perr != nil {
+		t.Fatalf("rate 1: %v", perr.Message)
+	}
+	_, perr := prepareTTS(ttsRequest{Text: text, Lang: "deva", Rate: 0.25}, "espeak")
+	if perr == nil || perr.Code != "duration_too_long" || perr.MaxDurationMs != 60000 {
+		t.Fatalf("rate 0.25: got %+v, want duration_too_long", perr)
+	}
+
+	// A provider that answers a word with a minute of audio is cut off.
+	t.Setenv("TTS_PROVIDER", "mac")
+	withCache(t, newAudioCache(16, 1<<24))
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(pcmToWAV(make([]byte, 60*2*8000), 8000, 1))
+		return err
+	})
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"नमः","lang":"deva"}`))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("runaway render: status %d, want 500", rec.Code)
+	}
+	if n, _ := ttsCache.flush(func(*cachedAudio) bool { return true }); n != 0 {
+		t.Error("runaway render was cached")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// elevenLabsPCMSampleRate is the rate ElevenLabs renders PCM at for WAV
+// output.
+const elevenLabsPCMSampleRate = 24000
+
+// elevenLabsBaseURL is ELEVENLABS_BASE_URL or the public API.
+func elevenLabsBaseURL() string {
+	return envString("ELEVENLABS_BASE_URL", "https://api.elevenlabs.io/v1")
+}
+
+// elevenLabsVoice returns the voice ID configured for lang,
+// ELEVENLABS_VOICE_ID or the premade voice Rachel.
+func elevenLabsVoice(lang string) string {
+	return firstNonEmpty(configuredVoice(lang, "elevenlabs").Voice, os.Getenv("ELEVENLABS_VOICE_ID"), "21m00Tcm4TlvDq8ikWAM")
+}
+
+// elevenLabsModel is ELEVENLABS_MODEL_ID. Which Indian languages are read,
+// and how well, depends on the model: eleven_multilingual_v2 (default)
+// covers Hindi and Tamil, eleven_flash_v2_5 adds more; others fall back to
+// whatever the model makes of the script.
+func elevenLabsModel() string {
+	return envString("ELEVENLABS_MODEL_ID", "eleven_multilingual_v2")
+}
+
+// elevenLabsVoiceSettings are the voice settings from ELEVENLABS_STABILITY
+// and ELEVENLABS_SIMILARITY (0..1; unset keeps the voice's own) and the
+// request's rate as speed.
+func elevenLabsVoiceSettings(req ttsRequest) map[string]any {
+	settings := map[string]any{}
+	for name, env := range map[string]string{"stability": "ELEVENLABS_STABILITY", "similarity_boost": "ELEVENLABS_SIMILARITY"} {
+		if v := os.Getenv(env); v != "" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				settings[name] = clamp(f, 0, 1)
+			}
+		}
+	}
+	if pros := resolveProsody("elevenlabs", req); pros.Rate != 1 {
+		settings["speed"] = pros.Rate
+	}
+	return settings
+}
+
+// synthesizeWithElevenLabs uses the ElevenLabs text-to-speech streaming
+// endpoint with ELEVENLABS_API_KEY. MP3 is streamed through as it arrives;
+// WAV is built from PCM output, and other formats are transcoded from MP3.
+func synthesizeWithElevenLabs(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+	apiKey := os.Getenv("ELEVENLABS_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("ELEVENLABS_API_KEY not set")
+	}
+	if req.SSML {
+		// ElevenLabs reads no SSML; synthesize the spoken text only.
+		text = ssmlToText(text, func(time.Duration) string { return " " })
+	}
+
+	format := resolveFormat(req.Format, "mp3")
+	output, rate := "mp3_44100_128", 0
+	if format == "wav" {
+		output, rate = "pcm_"+strconv.Itoa(elevenLabsPCMSampleRate), elevenLabsPCMSampleRate
+	}
+	voice := firstNonEmpty(req.voice, elevenLabsVoice(req.Lang))
+	body := map[string]any{"text": text, "model_id": elevenLabsModel()}
+	if settings := elevenLabsVoiceSettings(req); len(settings) > 0 {
+		body["voice_settings"] = settings
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	reportVoice(ctx, voice, "", rate)
+
+	endpoint := elevenLabsBaseURL() + "/text-to-speech/" + url.PathEscape(voice) + "/stream?output_format=" + output
+	if err := waitProvider(ctx, "elevenlabs", text); err != nil {
+		return err
+	}
+	resp, err := doWithRetry(ctx, "elevenlabs", envInt("ELEVENLABS_MAX_RETRIES", 3), func() (*http.Request, error) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("xi-api-key", apiKey)
+		return r, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		logFrom(ctx).Warn("tts http error", "status", resp.StatusCode, "retry_after", resp.Header.Get("Retry-After"), "body", strings.TrimSpace(string(msg)))
+		return voiceError("elevenlabs", resp.StatusCode, msg, voice, fmt.Errorf("elevenlabs tts status %d", resp.StatusCode))
+	}
+
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	switch format {
+	case "wav":
+		pcm, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(pcmToWAV(pcm, elevenLabsPCMSampleRate, 1))
+		return err
+	case "mp3":
+	default:
+		return transcode(ctx, w, resp.Body, format)
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return err
+	}
+	logFrom(ctx).Info("synthesized", "runes", len([]rune(text)), "lang", req.Lang, "voice", voice, "bytes", n)
+	return nil
+}
+
+// elevenLabsVoiceList lists the voices available to the account. Each reads
+// every language its model does, so all our languages are reported.
+func elevenLabsVoiceList(ctx context.Context) ([]voiceInfo, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, elevenLabsBaseURL()+"/voices", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("xi-api-key", os.Getenv("ELEVENLABS_API_KEY"))
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("elevenlabs voices status %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Voices []struct {
+			VoiceID string            `json:"voice_id"`
+			Labels  map[string]string `json:"labels"`
+		} `json:"voices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	langs := sarvamVoices()[0].Languages
+	voices := make([]voiceInfo, 0, len(list.Voices))
+	for _, v := range list.Voices {
+		voices = append(voices, voiceInfo{Name: v.VoiceID, Languages: langs, Gender: strings.ToLower(v.Labels["gender"])})
+	}
+	return voices, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envString reads a string from the environment, returning def when the
+// variable is unset or empty.
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// firstNonEmpty returns the first of vals that is not empty.
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// envInt reads an integer from the environment, returning def when the
+// variable is unset or malformed.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid environment value, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envInt64 is envInt for values that may exceed the int range, such as byte sizes.
+func envInt64(name string, def int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		slog.Warn("invalid environment value, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envBool reads a boolean ("1", "true", ...) from the environment, returning
+// def when the variable is unset or malformed.
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid environment value, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return b
+}
+
+// envDuration reads a Go duration ("15s", "2m") from the environment,
+// returning def when the variable is unset or malformed.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("invalid environment value, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return d
+}
+
+// envFloat reads a float from the environment, returning def when the
+// variable is unset or malformed.
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("invalid environment value, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return f
+}
@@ -0,0 +1,33 @@
+package main
+
+import "net/http"
+
+// apiError is the body of every error response, wrapped as {"error": ...}.
+// Code is a stable identifier the frontend can switch on.
+type apiError struct {
+	Code          string `json:"code"`
+	Message       string `json:"message"`
+	MaxRunes      int    `json:"maxRunes,omitempty"`
+	MaxDurationMs int64  `json:"maxDurationMs,omitempty"`
+	Segment       *int   `json:"segment,omitempty"` // failing element of texts
+	Field         string `json:"field,omitempty"`   // request field at fault
+	RequestID     string `json:"requestId,omitempty"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIError(w, status, apiError{Code: code, Message: message})
+}
+
+// writeAPIError writes e as a JSON error response, filling in the request ID
+// from the response headers when present.
+func writeAPIError(w http.ResponseWriter, status int, e apiError) {
+	if e.RequestID == "" {
+		e.RequestID = w.Header().Get("X-Request-Id")
+	}
+	writeJSON(w, status, errorResponse{Error: e})
+}
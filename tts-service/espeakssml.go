@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// espeakMarkup is SSML translated to what espeak-ng reads reliably: text
+// with <break>s for -m, plus rate and pitch for its -s and -p flags.
+type espeakMarkup struct {
+	text    string
+	rate    float64  // multiplier from a <prosody> around the whole text; 0 if none
+	pitch   float64  // semitones from the same <prosody>
+	dropped []string // unsupported tags whose markup was stripped
+}
+
+// espeakSSML translates SSML for espeak-ng, whose own markup support is
+// partial, so the SSML sent to cloud providers also works on espeak:
+//
+//   - <break> is kept as an inline pause.
+//   - <prosody rate pitch> around all the text becomes espeak flags; a
+//     <prosody> around only part of it can't be, and is dropped.
+//   - <say-as> characters, spell-out and verbatim read letters (aksharas in
+//     Devanagari) one at a time; digits and telephone read digit by digit;
+//     cardinal and number are spelled out in lang.
+//   - <sub> reads its alias.
+//
+// Other tags are stripped and their text read plainly; their names are
+// returned in dropped so callers can log them.
+func espeakSSML(ssml, lang string) espeakMarkup {
+	var m espeakMarkup
+	var b strings.Builder
+	drop := func(name string) {
+		if !slices.Contains(m.dropped, name) {
+			m.dropped = append(m.dropped, name)
+		}
+	}
+	write := func(s string) {
+		xml.EscapeText(&b, []byte(s))
+	}
+
+	var (
+		spoken     int // non-space runes written so far
+		depth      int
+		prosody    = -1 // depth of the <prosody> that may become flags
+		prosodyEnd = -1 // spoken when it closed
+		rate       float64
+		pitch      float64
+		sayAs      string // interpret-as of the open <say-as>
+		inSayAs    bool
+		sayText    strings.Builder
+		skip       int // depth of a <sub> whose text its alias replaces
+	)
+	count := func(s string) {
+		for _, r := range s {
+			if !unicode.IsSpace(r) {
+				spoken++
+			}
+		}
+	}
+
+	dec := xml.NewDecoder(strings.NewReader(ssml))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			switch {
+			case skip > 0:
+			case inSayAs:
+				sayText.Write(t)
+			default:
+				write(string(t))
+				count(string(t))
+			}
+		case xml.StartElement:
+			depth++
+			if skip > 0 {
+				continue
+			}
+			switch t.Name.Local {
+			case "speak", "p", "s":
+			case "break":
+				fmt.Fprintf(&b, `<break time="%dms"/>`, breakDuration(t).Milliseconds())
+			case "prosody":
+				if prosody < 0 && prosodyEnd < 0 && spoken == 0 {
+					prosody = depth
+					rate, pitch = prosodyAttrs(t)
+				} else {
+					drop("prosody")
+				}
+			case "say-as":
+				inSayAs, sayAs = true, attr(t, "interpret-as")
+				sayText.Reset()
+			case "sub":
+				if alias := attr(t, "alias"); alias != "" {
+					write(alias)
+					count(alias)
+					skip = depth
+				}
+			default:
+				drop(t.Name.Local)
+			}
+		case xml.EndElement:
+			switch {
+			case skip == depth:
+				skip = 0
+			case skip > 0:
+			case t.Name.Local == "say-as" && inSayAs:
+				text, ok := readSayAs(sayAs, sayText.String(), lang)
+				if !ok {
+					drop("say-as " + sayAs)
+				}
+				write(text)
+				count(text)
+				inSayAs = false
+			case t.Name.Local == "p" || t.Name.Local == "s":
+				b.WriteString(" ")
+			case t.Name.Local == "prosody" && depth == prosody:
+				prosodyEnd, prosody = spoken, -1
+			}
+			depth--
+		}
+	}
+
+	if prosodyEnd >= 0 {
+		if spoken == prosodyEnd {
+			m.rate, m.pitch = rate, pitch
+		} else {
+			drop("prosody")
+		}
+	}
+	m.text = "<speak>" + strings.TrimSpace(b.String()) + "</speak>"
+	return m
+}
+
+// attr returns the value of el's attribute name.
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// readSayAs rewrites text so espeak reads it as interpretAs asks, reporting
+// false for interpretations it can't render (the text is kept as is).
+func readSayAs(interpretAs, text, lang string) (string, bool) {
+	switch interpretAs {
+	case "characters", "spell-out", "verbatim":
+		var parts []string
+		for _, word := range strings.Fields(text) {
+			if strings.ContainsFunc(word, isDevanagari) {
+				parts = append(parts, aksharas(word)...)
+				continue
+			}
+			for _, r := range word {
+				parts = append(parts, string(r))
+			}
+		}
+		return strings.Join(parts, " "), true
+	case "digits", "telephone":
+		var parts []string
+		for _, r := range text {
+			if !unicode.IsSpace(r) {
+				parts = append(parts, string(r))
+			}
+		}
+		return strings.Join(parts, " "), true
+	case "cardinal", "number":
+		return expandNumbers(text, lang), true
+	}
+	return text, false
+}
+
+// prosodyAttrs reads a <prosody>'s rate as a multiplier and pitch in
+// semitones, as SSML 1.1 writes them: keywords, "80%", "1.5", "+10%" or
+// "-2st". Unset or unreadable values are 0.
+func prosodyAttrs(el xml.StartElement) (rate, pitch float64) {
+	switch v := attr(el, "rate"); v {
+	case "x-slow":
+		rate = 0.5
+	case "slow":
+		rate = 0.75
+	case "medium", "default":
+		rate = 1
+	case "fast":
+		rate = 1.25
+	case "x-fast":
+		rate = 1.75
+	default:
+		if pct, ok := strings.CutSuffix(v, "%"); ok {
+			if f, err := strconv.ParseFloat(pct, 64); err == nil {
+				if strings.HasPrefix(pct, "+") || strings.HasPrefix(pct, "-") {
+					f += 100 // relative change
+				}
+				rate = f / 100
+			}
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+			rate = f
+		}
+	}
+	if rate < 0 {
+		rate = 0
+	}
+
+	switch v := attr(el, "pitch"); v {
+	case "x-low":
+		pitch = -6
+	case "low":
+		pitch = -3
+	case "high":
+		pitch = 3
+	case "x-high":
+		pitch = 6
+	default:
+		if st, ok := strings.CutSuffix(v, "st"); ok {
+			pitch, _ = strconv.ParseFloat(st, 64)
+		} else if pct, ok := strings.CutSuffix(v, "%"); ok {
+			if f, err := strconv.ParseFloat(pct, 64); err == nil && f > -100 {
+				pitch = 12 * math.Log2(1+f/100)
+			}
+		}
+	}
+	return rate, pitch
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEspeakSSML(t *testing.T) {
+	tests := []struct {
+		name, ssml  string
+		text        string
+		rate, pitch float64
+		dropped     []string
+	}{
+		{
+			name: "break kept",
+			ssml: `<speak>राम<break time="300ms"/>कृष्ण<break strength="strong"/></speak>`,
+			text: `<speak>राम<break time="300ms"/>कृष्ण<break time="750ms"/></speak>`,
+		},
+		{
+			name: "prosody around everything becomes flags",
+			ssml: `<speak> <prosody rate="slow" pitch="+2st">नमः शिवाय</prosody> </speak>`,
+			text: `<speak>नमः शिवाय</speak>`,
+			rate: 0.75, pitch: 2,
+		},
+		{
+			name: "prosody percentages",
+			ssml: `<speak><prosody rate="80%">ॐ</prosody></speak>`,
+			text: `<speak>ॐ</speak>`,
+			rate: 0.8,
+		},
+		{
+			name:    "partial prosody dropped",
+			ssml:    `<speak>हरि <prosody rate="fast">ॐ</prosody></speak>`,
+			text:    `<speak>हरि ॐ</speak>`,
+			dropped: []string{"prosody"},
+		},
+		{
+			name: "say-as characters spells aksharas",
+			ssml: `<speak><say-as interpret-as="characters">नमस्ते</say-as> OM</speak>`,
+			text: `<speak>न म स्ते OM</speak>`,
+		},
+		{
+			name: "say-as digits",
+			ssml: `<speak><say-as interpret-as="telephone">108 9</say-as></speak>`,
+			text: `<speak>1 0 8 9</speak>`,
+		},
+		{
+			name:    "unsupported say-as keeps text",
+			ssml:    `<speak><say-as interpret-as="date">2024-01-01</say-as></speak>`,
+			text:    `<speak>2024-01-01</speak>`,
+			dropped: []string{"say-as date"},
+		},
+		{
+			name: "sub reads alias",
+			ssml: `<speak><sub alias="ओम्">ॐ</sub> नमः</speak>`,
+			text: `<speak>ओम् नमः</speak>`,
+		},
+		{
+			name:    "unknown tags stripped",
+			ssml:    `<speak><emphasis>श्री</emphasis> <phoneme ph="x">राम</phoneme> &amp; <emphasis>सीता</emphasis></speak>`,
+			text:    `<speak>श्री राम &amp; सीता</speak>`,
+			dropped: []string{"emphasis", "phoneme"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := espeakSSML(tt.ssml, "deva")
+			if m.text != tt.text {
+				t.Errorf("text = %q, want %q", m.text, tt.text)
+			}
+			if math.Abs(m.rate-tt.rate) > 1e-9 || math.Abs(m.pitch-tt.pitch) > 1e-9 {
+				t.Errorf("rate, pitch = %v, %v; want %v, %v", m.rate, m.pitch, tt.rate, tt.pitch)
+			}
+			if !slices.Equal(m.dropped, tt.dropped) {
+				t.Errorf("dropped = %q, want %q", m.dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestEspeakSSMLArgs(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+
+	// A stand-in for espeak-ng that records its arguments, one per line.
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "espeak-ng")
+	body := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsFile + "\nprintf RIFF\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := espeakBin
+	espeakBin = script
+	t.Cleanup(func() { espeakBin = orig })
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"<speak><prosody rate=\"x-fast\" pitch=\"high\">राम<break time=\"200ms\"/><emphasis>राम</emphasis></prosody></speak>","lang":"deva"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	got, _ := os.ReadFile(argsFile)
+	want := []string{"-v", "hi", "-m", "-s", "306", "-p", "58", "--stdout", `<speak>राम<break time="200ms"/>राम</speak>`}
+	if args := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n"); !slices.Equal(args, want) {
+		t.Errorf("espeak args = %q, want %q", args, want)
+	}
+}
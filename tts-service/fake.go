@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The fake provider (TTS_PROVIDER=fake) renders a sine tone instead of
+// speech, with no binaries, network or credentials. It is for tests and CI
+// that exercise the HTTP layer, caching, rate limiting and error handling;
+// never configure it in production.
+
+// fakeSampleRate is the rate the fake provider writes WAV at.
+const fakeSampleRate = 16000
+
+// fakeDuration is the length of a fake clip at rate 1 (TTS_FAKE_DURATION_MS,
+// default 200ms).
+func fakeDuration() time.Duration {
+	return time.Duration(max(1, envInt("TTS_FAKE_DURATION_MS", 200))) * time.Millisecond
+}
+
+// fakeWAV renders the fake clip for text: the same text and prosody always
+// give the same bytes, and different texts different tones, so cached and
+// fresh audio can be told apart.
+func fakeWAV(text string, pros prosody) []byte {
+	h := fnv.New32a()
+	h.Write([]byte(text))
+	freq := (200 + float64(h.Sum32()%400)) * math.Pow(2, pros.Pitch/12)
+	amp := 0.3 * gainToAmplitude(pros.Volume)
+
+	n := int(fakeDuration().Seconds() / pros.Rate * fakeSampleRate)
+	pcm := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		v := amp * math.Sin(2*math.Pi*freq*float64(i)/fakeSampleRate)
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(clamp(v, -1, 1)*math.MaxInt16)))
+	}
+	return pcmToWAV(pcm, fakeSampleRate, 1)
+}
+
+// synthesizeWithFake writes the fake clip for text. Rate shortens it, pitch
+// raises the tone in semitones and volume scales it in dB.
+func synthesizeWithFake(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+	if req.SSML {
+		text = ssmlToText(text, nil)
+	}
+	pros := resolveProsody("fake", req)
+	reportVoice(ctx, "fake", "", fakeSampleRate)
+	wav := fakeWAV(text, pros)
+
+	format := resolveFormat(req.Format, "wav")
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	if format != "wav" {
+		return transcode(ctx, w, bytes.NewReader(wav), format)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(wav)))
+	_, err := w.Write(wav)
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFakeProvider(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "fake")
+	t.Setenv("TTS_FAKE_DURATION_MS", "300")
+	withCache(t, newAudioCache(16, 1<<20))
+
+	render := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", body, rec.Code, rec.Body)
+		}
+		if _, err := parseWAV(rec.Body.Bytes()); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		return rec
+	}
+
+	first := render(`{"text":"राम","lang":"deva","granularity":"word"}`)
+	if d := first.Header().Get("X-Audio-Duration-Ms"); d != "300" {
+		t.Errorf("duration %s ms, want 300", d)
+	}
+	if again := render(`{"text":"राम","lang":"deva","granularity":"word"}`); !bytes.Equal(again.Body.Bytes(), first.Body.Bytes()) {
+		t.Error("same text rendered different audio")
+	}
+	if other := render(`{"text":"सीता","lang":"deva","granularity":"word"}`); bytes.Equal(other.Body.Bytes(), first.Body.Bytes()) {
+		t.Error("different texts rendered the same audio")
+	}
+	if fast := render(`{"text":"राम","lang":"deva","granularity":"word","rate":2}`); fast.Header().Get("X-Audio-Duration-Ms") != "150" {
+		t.Errorf("rate 2: duration %s ms, want 150", fast.Header().Get("X-Audio-Duration-Ms"))
+	}
+}
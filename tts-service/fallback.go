@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// fallbackChain returns the providers to try for a request: primary first,
+// then each provider listed in TTS_FALLBACK (e.g. "sarvam,espeak") that is
+// known and available on this host.
+func fallbackChain(primary string) []string {
+	chain := []string{primary}
+	for _, p := range strings.Split(os.Getenv("TTS_FALLBACK"), ",") {
+		p = strings.TrimSpace(p)
+		if p == "" || slices.Contains(chain, p) {
+			continue
+		}
+		if _, ok := providers[p]; !ok {
+			slog.Warn("ignoring unknown fallback provider", "provider", p)
+			continue
+		}
+		if len(providerMissing(p)) > 0 {
+			continue
+		}
+		chain = append(chain, p)
+	}
+	return chain
+}
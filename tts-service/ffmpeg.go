@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// ffmpegBin is the ffmpeg executable (TTS_FFMPEG_BIN), used for format
+// conversion, resampling, padding non-WAV clips and loudness normalization.
+var ffmpegBin = envString("TTS_FFMPEG_BIN", "ffmpeg")
+
+// ffmpegMissing is set by probeFFmpeg when ffmpeg can't be found. It starts
+// false, so nothing is refused before the probe has run.
+var ffmpegMissing atomic.Bool
+
+// probeFFmpeg records whether ffmpegBin can be run and warns about what is
+// disabled without it.
+func probeFFmpeg() {
+	_, err := exec.LookPath(ffmpegBin)
+	ffmpegMissing.Store(err != nil)
+	if err != nil {
+		slog.Warn("ffmpeg not found; format conversion, resampling and loudness normalization are disabled; set TTS_FFMPEG_BIN to its path",
+			"bin", ffmpegBin, "err", err)
+	}
+}
+
+// ffmpegAvailable reports whether ffmpeg was found at startup.
+func ffmpegAvailable() bool {
+	return !ffmpegMissing.Load()
+}
+
+// directFormats are the formats each provider renders itself; anything
+// else is transcoded with ffmpeg.
+var directFormats = map[string][]string{
+	"espeak":     {"wav"},
+	"mac":        {"wav"},
+	"sarvam":     {"mp3", "wav"},
+	"polly":      {"mp3", "ogg", "wav"},
+	"azure":      {"mp3", "wav", "opus"},
+	"piper":      {"wav"},
+	"openai":     {"mp3", "wav", "opus"},
+	"elevenlabs": {"mp3", "wav"},
+	"fake":       {"wav"},
+}
+
+// availableFormats lists the output formats provider can serve: all of
+// them with ffmpeg, only its direct formats without.
+func availableFormats(provider string) []string {
+	if ffmpegAvailable() {
+		return supportedFormats
+	}
+	var formats []string
+	for _, f := range supportedFormats {
+		if slices.Contains(directFormats[provider], f) {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// requireFFmpeg rejects a job that would need ffmpeg when it isn't
+// installed, with 501 naming the field that asked for it, instead of
+// failing mid-synthesis.
+func requireFFmpeg(job *ttsJob) *requestError {
+	if ffmpegAvailable() {
+		return nil
+	}
+	req := job.req
+	format := resolveFormat(req.Format, nativeFormats[job.provider])
+	field := ""
+	switch {
+	case req.Bitrate != 0:
+		field = "bitrate"
+	case !slices.Contains(directFormats[job.provider], format):
+		field = "format"
+	case req.SampleRateHertz != 0:
+		field = "sampleRateHertz"
+	case req.Channels != 0:
+		field = "channels"
+	case req.padded() && format != "wav":
+		field = "leadSilenceMs"
+		if req.LeadSilenceMs == 0 {
+			field = "trailSilenceMs"
+		}
+	case len(job.chunks) > 1 && format != "wav" && (format != "mp3" || req.pause > 0):
+		// Chunks are joined as WAV and then encoded.
+		field = "format"
+	case job.provider == "mac" && resolveProsody("mac", req).Volume != 0:
+		// say has no volume control; the gain is applied by ffmpeg.
+		field = "volume"
+	default:
+		return nil
+	}
+	return &requestError{http.StatusNotImplemented, apiError{
+		Code:    "ffmpeg_unavailable",
+		Message: fmt.Sprintf("this request needs ffmpeg, which is not installed (formats available: %s)", strings.Join(availableFormats(job.provider), ", ")),
+		Field:   field,
+	}}
+}
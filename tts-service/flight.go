@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// synthFlights tracks which requests are waiting on each shared synthesis.
+var synthFlights = &flightTracker{flights: make(map[string]*flight)}
+
+// flightTracker reference-counts the requests interested in a synthesis so
+// it can be cancelled, killing any provider subprocess, once all of them
+// have disconnected.
+type flightTracker struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	refs   int
+}
+
+// join registers interest in the synthesis for key and returns the context
+// it should run under. release must be called when the request stops
+// waiting; it is safe to call more than once. The last release cancels the
+// context.
+func (t *flightTracker) join(key string) (context.Context, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.flights[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(synthesisBase)
+		f = &flight{ctx: ctx, cancel: cancel}
+		t.flights[key] = f
+	}
+	f.refs++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			f.refs--
+			if f.refs == 0 {
+				f.cancel()
+				if t.flights[key] == f {
+					delete(t.flights, key)
+				}
+			}
+		})
+	}
+	return f.ctx, release
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// supportedFormats lists the output formats accepted in ttsRequest.Format, in
+// the order they are reported back to clients.
+var supportedFormats = []string{"wav", "mp3", "ogg", "opus", "webm"}
+
+// audioContentTypes maps each supported output format to its Content-Type.
+var audioContentTypes = map[string]string{
+	"wav":  "audio/wav",
+	"mp3":  "audio/mpeg",
+	"ogg":  "audio/ogg",
+	"opus": "audio/ogg; codecs=opus",
+	"webm": "audio/webm; codecs=opus",
+}
+
+// nativeFormats is the format each provider produces without transcoding.
+var nativeFormats = map[string]string{
+	"espeak":     "wav",
+	"mac":        "wav",
+	"sarvam":     "mp3",
+	"polly":      "mp3",
+	"azure":      "mp3",
+	"piper":      "wav",
+	"openai":     "mp3",
+	"elevenlabs": "mp3",
+	"fake":       "wav",
+}
+
+// sampleRates lists the output sample rates accepted in
+// ttsRequest.SampleRateHertz. Opus only encodes a subset of them.
+var sampleRates = []int{8000, 12000, 16000, 22050, 24000, 44100, 48000}
+
+var opusSampleRates = []int{8000, 12000, 16000, 24000, 48000}
+
+// validSampleRate reports whether rate is 0 (provider default) or a rate
+// format can be encoded at.
+func validSampleRate(format string, rate int) bool {
+	if rate == 0 {
+		return true
+	}
+	if format == "opus" || format == "webm" {
+		return slices.Contains(opusSampleRates, rate)
+	}
+	return slices.Contains(sampleRates, rate)
+}
+
+// validFormat reports whether format is empty (provider default) or one of
+// supportedFormats.
+func validFormat(format string) bool {
+	if format == "" {
+		return true
+	}
+	_, ok := audioContentTypes[format]
+	return ok
+}
+
+// resolveFormat returns the requested format, or the provider's native format
+// when none was requested.
+func resolveFormat(requested, native string) string {
+	if requested == "" {
+		return native
+	}
+	return requested
+}
+
+// unsupportedFormatMessage builds the 400 message for an unknown format.
+func unsupportedFormatMessage(format string) string {
+	return fmt.Sprintf("unsupported format %q (supported: %s)", format, strings.Join(supportedFormats, ", "))
+}
+
+// acceptsWebM reports whether the client asked for Opus in WebM, which
+// browsers can start playing while a streaming provider is still speaking.
+func acceptsWebM(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "audio/webm")
+}
+
+// ffmpegArgs returns the arguments to transcode stdin to the given format on
+// stdout, applying the audio filter graph, resampling and remixing to the
+// given channel count when given.
+func ffmpegArgs(format, filter string, sampleRate, channels int) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0"}
+	if filter != "" {
+		args = append(args, "-af", filter)
+	}
+	if sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(sampleRate))
+	}
+	if channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(channels))
+	}
+	switch format {
+	case "wav":
+		args = append(args, "-f", "wav")
+	case "mp3":
+		args = append(args, "-codec:a", "libmp3lame", "-f", "mp3")
+	case "ogg":
+		args = append(args, "-codec:a", "libvorbis", "-f", "ogg")
+	case "opus":
+		args = append(args, "-codec:a", "libopus", "-f", "ogg")
+	case "webm":
+		// Short clusters written as soon as they are complete, so browsers
+		// can start playing a streamed clip after the first few packets.
+		args = append(args, "-codec:a", "libopus", "-f", "webm", "-live", "1", "-cluster_time_limit", "100", "-flush_packets", "1")
+	}
+	return append(args, "pipe:1")
+}
+
+// transcode pipes src through ffmpeg, writing audio in the given format to dst.
+func transcode(ctx context.Context, dst io.Writer, src io.Reader, format string) error {
+	return filterAudio(ctx, dst, src, format, "")
+}
+
+// filterAudio is transcode with an ffmpeg audio filter graph applied.
+func filterAudio(ctx context.Context, dst io.Writer, src io.Reader, format, filter string) error {
+	return runFFmpeg(ctx, dst, src, ffmpegArgs(format, filter, 0, 0), format)
+}
+
+// resample is transcode at the given output sample rate and channel count;
+// zero keeps the input's.
+func resample(ctx context.Context, dst io.Writer, src io.Reader, format string, sampleRate, channels int) error {
+	return runFFmpeg(ctx, dst, src, ffmpegArgs(format, "", sampleRate, channels), format)
+}
+
+func runFFmpeg(ctx context.Context, dst io.Writer, src io.Reader, args []string, format string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBin, args...)
+	cmd.Stdin = src
+	cmd.Stdout = dst
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg to %s: %w: %s", format, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// readinessResponse is the body of /healthz and /readyz.
+type readinessResponse struct {
+	Status   string   `json:"status"`
+	Provider string   `json:"provider,omitempty"`
+	Missing  []string `json:"missing,omitempty"`
+	Breaker  string   `json:"breaker,omitempty"` // circuit breaker state, for network providers
+
+	// InFlight is how many requests are being served, this probe included;
+	// MaxInFlight is TTS_MAX_INFLIGHT when set.
+	InFlight    int64 `json:"inFlight,omitempty"`
+	MaxInFlight int64 `json:"maxInFlight,omitempty"`
+
+	// Degraded lists optional extras that are configured but unavailable,
+	// such as mbrola voices; the provider still works without them.
+	Degraded []string `json:"degraded,omitempty"`
+}
+
+// handleHealthz reports that the HTTP server is up.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, readinessResponse{Status: "ok"})
+}
+
+// handleReadyz reports whether the active provider can synthesize, returning
+// 503 with the missing dependencies when it cannot. An open circuit breaker
+// also makes the service unready unless a fallback provider can take over.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	provider := activeProvider()
+	if missing := providerMissing(provider); len(missing) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, readinessResponse{Status: "not ready", Provider: provider, Missing: missing})
+		return
+	}
+	resp := readinessResponse{Status: "ready", Provider: provider, InFlight: inFlightRequests.Load(), MaxInFlight: maxInFlight()}
+	if provider == "espeak" && mbrolaEnabled() {
+		if mb := mbrolaVoice("deva"); !mbrolaAvailable(mb) {
+			resp.Degraded = append(resp.Degraded, "mbrola voice "+mb)
+		}
+	}
+	if !ffmpegAvailable() {
+		resp.Degraded = append(resp.Degraded, "ffmpeg (only "+strings.Join(availableFormats(provider), ", ")+" output, no resampling or loudness normalization)")
+	}
+	if b := breakers[provider]; b != nil {
+		resp.Breaker = b.stateName()
+		if resp.Breaker == "open" && len(fallbackChain(provider)) == 1 {
+			resp.Status = "not ready"
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// providerMissing lists what the provider needs but cannot find on this host.
+func providerMissing(provider string) []string {
+	var missing []string
+	switch provider {
+	case "sarvam":
+		if os.Getenv("SARVAM_API_KEY") == "" {
+			missing = append(missing, "SARVAM_API_KEY")
+		}
+	case "polly":
+		if _, err := pollyClient(); err != nil {
+			missing = append(missing, "AWS configuration ("+err.Error()+")")
+		}
+	case "azure":
+		for _, name := range []string{"AZURE_TTS_KEY", "AZURE_TTS_REGION"} {
+			if os.Getenv(name) == "" {
+				missing = append(missing, name)
+			}
+		}
+	case "openai":
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			missing = append(missing, "OPENAI_API_KEY")
+		}
+	case "elevenlabs":
+		if os.Getenv("ELEVENLABS_API_KEY") == "" {
+			missing = append(missing, "ELEVENLABS_API_KEY")
+		}
+	case "piper":
+		missing = append(missing, missingBinaries(piperBin)...)
+		if piperModel("deva") == "" {
+			missing = append(missing, "PIPER_MODEL")
+		}
+	case "mac":
+		missing = append(missing, missingBinaries(sayBin)...)
+	case "fake":
+		// Needs nothing.
+	default:
+		missing = append(missing, missingBinaries(espeakBin)...)
+	}
+	return missing
+}
+
+func missingBinaries(names ...string) []string {
+	var missing []string
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("json write error", "err", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// language is one of the language codes clients send in lang, with the
+// codes providers know it by.
+type language struct {
+	code   string
+	espeak string // espeak-ng voice
+	bcp47  string // Sarvam, Polly, Azure and voice filtering
+}
+
+// languages are the supported lang codes. iast is Latin transliteration,
+// read by espeak as Hindi and by cloud providers as Indian English.
+var languages = []language{
+	{"deva", "hi", "hi-IN"}, // Devanagari → Hindi voice (closest available)
+	{"iast", "hi", "en-IN"},
+	{"knda", "kn", "kn-IN"},
+	{"tel", "te", "te-IN"},
+	{"tam", "ta", "ta-IN"},
+	{"guj", "gu", "gu-IN"},
+	{"pan", "pa", "pa-IN"},
+	{"mr", "mr", "mr-IN"},
+	{"ben", "bn", "bn-IN"},
+	{"mal", "ml", "ml-IN"},
+}
+
+// lookupLang returns the language for code.
+func lookupLang(code string) (language, bool) {
+	for _, l := range languages {
+		if l.code == code {
+			return l, true
+		}
+	}
+	return language{}, false
+}
+
+// langCodes lists the supported lang codes.
+func langCodes() []string {
+	codes := make([]string, len(languages))
+	for i, l := range languages {
+		codes[i] = l.code
+	}
+	return codes
+}
+
+// strictMode reports TTS_STRICT_LANG, under which input the service would
+// otherwise read on a best-effort basis is rejected instead: unknown lang
+// codes and text that is only punctuation.
+func strictMode() bool {
+	return envBool("TTS_STRICT_LANG", false)
+}
+
+// validateLang rejects an unknown lang in strict mode, so integrators see a
+// mistyped code instead of hearing Hindi. Empty and "auto" are always
+// accepted; by default unknown codes fall back as before.
+func validateLang(lang string) *requestError {
+	if lang == "" || lang == "auto" || !strictMode() {
+		return nil
+	}
+	if _, ok := lookupLang(lang); ok {
+		return nil
+	}
+	return fieldError("lang", "unsupported_lang",
+		fmt.Sprintf("unsupported lang %q (supported: auto, %s)", lang, strings.Join(langCodes(), ", ")))
+}
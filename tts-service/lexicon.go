@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// lexiconEntry is the replacement for one spelling. In the JSON file it is
+// either a string (the replacement text) or an object with "text" and an
+// optional "espeak" phoneme string, which espeak reads in place of the word
+// via its [[...]] phoneme input.
+type lexiconEntry struct {
+	Text   string `json:"text"`
+	Espeak string `json:"espeak"`
+}
+
+func (e *lexiconEntry) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &e.Text)
+	}
+	type plain lexiconEntry
+	return json.Unmarshal(b, (*plain)(e))
+}
+
+// lexicon holds pronunciation fixes from TTS_LEXICON, a JSON file of
+// sections keyed by our language codes, plus "*" for all languages:
+//
+//	{"deva": {"ज्ञान": {"text": "ग्यान", "espeak": "gja:n"}}, "*": {"ॐ": "ओम्"}}
+//
+// Replacements run per provider call, after NFC normalization and
+// transliteration, so keys are written in the script being synthesized.
+type lexicon struct {
+	version   string // content hash, part of the cache key
+	replacers map[string]map[bool]*strings.Replacer
+}
+
+var currentLexicon atomic.Pointer[lexicon]
+
+// loadLexicon reads path and installs it as the current lexicon. An empty
+// path clears the lexicon.
+func loadLexicon(path string) error {
+	if path == "" {
+		currentLexicon.Store(nil)
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sections map[string]map[string]lexiconEntry
+	if err := json.Unmarshal(b, &sections); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	sum := sha256.Sum256(b)
+	lex := &lexicon{version: hex.EncodeToString(sum[:8]), replacers: map[string]map[bool]*strings.Replacer{}}
+	for lang := range sections {
+		if lang == "*" {
+			continue
+		}
+		lex.replacers[lang] = map[bool]*strings.Replacer{
+			false: newLexiconReplacer(sections["*"], sections[lang], false),
+			true:  newLexiconReplacer(sections["*"], sections[lang], true),
+		}
+	}
+	lex.replacers["*"] = map[bool]*strings.Replacer{
+		false: newLexiconReplacer(sections["*"], nil, false),
+		true:  newLexiconReplacer(sections["*"], nil, true),
+	}
+	currentLexicon.Store(lex)
+	slog.Info("lexicon loaded", "path", path, "languages", len(sections), "version", lex.version)
+	return nil
+}
+
+// newLexiconReplacer merges the common and language sections (the language
+// wins) into a replacer that prefers longer spellings.
+func newLexiconReplacer(common, lang map[string]lexiconEntry, espeak bool) *strings.Replacer {
+	merged := map[string]lexiconEntry{}
+	for k, v := range common {
+		merged[k] = v
+	}
+	for k, v := range lang {
+		merged[k] = v
+	}
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	// strings.Replacer tries candidates at a position in argument order.
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+
+	var pairs []string
+	for _, k := range keys {
+		e := merged[k]
+		switch {
+		case espeak && e.Espeak != "":
+			pairs = append(pairs, k, "[["+e.Espeak+"]]")
+		case e.Text != "":
+			pairs = append(pairs, k, e.Text)
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// lexiconVersion identifies the loaded lexicon for cache keys.
+func lexiconVersion() string {
+	if lex := currentLexicon.Load(); lex != nil {
+		return lex.version
+	}
+	return ""
+}
+
+// applyLexicon rewrites text for provider. Only character data is touched
+// in SSML.
+func applyLexicon(text, lang, provider string, ssml bool) string {
+	lex := currentLexicon.Load()
+	if lex == nil {
+		return text
+	}
+	section, ok := lex.replacers[lang]
+	if !ok {
+		section = lex.replacers["*"]
+	}
+	r := section[provider == "espeak"]
+	if !ssml {
+		return r.Replace(text)
+	}
+	return replaceOutsideTags(text, r)
+}
+
+// replaceOutsideTags applies r to the text between markup tags.
+func replaceOutsideTags(s string, r *strings.Replacer) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			b.WriteString(r.Replace(s))
+			break
+		}
+		b.WriteString(r.Replace(s[:i]))
+		j := strings.IndexByte(s[i:], '>')
+		if j < 0 {
+			b.WriteString(s[i:])
+			break
+		}
+		b.WriteString(s[i : i+j+1])
+		s = s[i+j+1:]
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// newLogger builds the service logger: JSON by default or text with
+// TTS_LOG_FORMAT=text, at TTS_LOG_LEVEL (debug, info, warn or error; default
+// info).
+func newLogger(out io.Writer) *slog.Logger {
+	var level slog.Level
+	levelName := os.Getenv("TTS_LOG_LEVEL")
+	invalidLevel := levelName != "" && level.UnmarshalText([]byte(levelName)) != nil
+	if invalidLevel {
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var logger *slog.Logger
+	if strings.EqualFold(os.Getenv("TTS_LOG_FORMAT"), "text") {
+		logger = slog.New(slog.NewTextHandler(out, opts))
+	} else {
+		logger = slog.New(slog.NewJSONHandler(out, opts))
+	}
+	if invalidLevel {
+		logger.Warn("invalid TTS_LOG_LEVEL, using info", "value", levelName)
+	}
+	return logger
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying logger.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// logFrom returns the logger carried by ctx, or the default logger.
+func logFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// logRequests gives each request a logger tagged with its X-Request-Id and
+// logs one line per request once it completes, as TTS_ACCESS_LOG_FORMAT
+// says. Requests without an ID get a generated UUID, set on the request for
+// handlers and echoed in the response, so every request can be traced
+// through the logs.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqID := r.Header.Get("X-Request-Id")
+		if reqID == "" {
+			reqID = newRequestID()
+			r = r.Clone(r.Context())
+			r.Header.Set("X-Request-Id", reqID)
+		}
+		w.Header().Set("X-Request-Id", reqID)
+		logger := slog.Default().With("request_id", reqID)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(withLogger(r.Context(), logger)))
+		format := accessLogFormat()
+		if format != "slog" {
+			writeAccessLog(r, start, sw.code(), sw.bytes)
+		}
+		if format == "combined" {
+			return
+		}
+		logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.code(),
+			"bytes", sw.bytes,
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
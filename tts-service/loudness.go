@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// normalizeEnabled reports whether TTS_NORMALIZE asks for loudness
+// normalization of buffered and cached audio. It is off without ffmpeg.
+func normalizeEnabled() bool {
+	return envBool("TTS_NORMALIZE", false) && ffmpegAvailable()
+}
+
+// loudnessTarget is the integrated loudness, in LUFS, that normalization
+// aims for (TTS_LOUDNESS_TARGET, default -16).
+func loudnessTarget() float64 {
+	return envFloat("TTS_LOUDNESS_TARGET", -16)
+}
+
+// loudnessKey is the part of the cache key describing normalization, so
+// changing the setting doesn't serve audio rendered under the old one.
+func loudnessKey() string {
+	if !normalizeEnabled() {
+		return ""
+	}
+	return "loudnorm=" + strconv.FormatFloat(loudnessTarget(), 'f', -1, 64)
+}
+
+// normalizeEntry returns a copy of entry run through ffmpeg's loudnorm
+// filter, recording the loudness measured before normalization. loudnorm
+// resamples internally, so the output is put back at sampleRate, or the
+// clip's own rate when sampleRate is 0.
+func normalizeEntry(ctx context.Context, entry *cachedAudio, sampleRate int) (*cachedAudio, error) {
+	format := formatForContentType(entry.contentType)
+	if format == "" {
+		return nil, fmt.Errorf("can't normalize %s", entry.contentType)
+	}
+	if sampleRate == 0 {
+		sampleRate = audioSampleRate(entry.data, entry.contentType)
+	}
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11:print_format=json", loudnessTarget())
+	args := withBitrate(ffmpegArgs(format, filter, sampleRate, 0), entry.bitrate)
+	// loudnorm prints its measurements at info level.
+	for i, a := range args {
+		if a == "-loglevel" {
+			args[i+1] = "info"
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBin, args...)
+	cmd.Stdin = bytes.NewReader(entry.data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg loudnorm: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	measured, err := parseLoudnormStats(stderr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	norm := *entry
+	norm.data = stdout.Bytes()
+	norm.loudness = measured
+	return &norm, nil
+}
+
+// parseLoudnormStats extracts the measured integrated loudness from the JSON
+// block loudnorm appends to ffmpeg's log.
+func parseLoudnormStats(log []byte) (string, error) {
+	start, end := bytes.LastIndexByte(log, '{'), bytes.LastIndexByte(log, '}')
+	if start < 0 || end < start {
+		return "", errors.New("loudnorm printed no measurements")
+	}
+	var stats struct {
+		InputI string `json:"input_i"`
+	}
+	if err := json.Unmarshal(log[start:end+1], &stats); err != nil {
+		return "", fmt.Errorf("loudnorm measurements: %w", err)
+	}
+	if _, err := strconv.ParseFloat(stats.InputI, 64); err != nil {
+		return "", fmt.Errorf("loudnorm input_i %q: %w", stats.InputI, err)
+	}
+	return stats.InputI, nil
+}
+
+// formatForContentType maps a Content-Type back to one of supportedFormats.
+func formatForContentType(contentType string) string {
+	for format, ct := range audioContentTypes {
+		if ct == contentType {
+			return format
+		}
+	}
+	return ""
+}
+
+// audioSampleRate reads the sample rate of a WAV or MP3 clip, falling back
+// to 48 kHz, which every output format can encode.
+func audioSampleRate(data []byte, contentType string) int {
+	if rate, ok := clipSampleRate(data, contentType); ok {
+		return rate
+	}
+	return 48000
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestParseLoudnormStats(t *testing.T) {
+	log := []byte(`[Parsed_loudnorm_0 @ 0x55d0c8] 
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "3.80",
+	"input_thresh" : "-37.98",
+	"output_i" : "-16.02",
+	"output_tp" : "-1.50",
+	"output_lra" : "3.10",
+	"output_thresh" : "-26.40",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.02"
+}
+`)
+	got, err := parseLoudnormStats(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "-27.61" {
+		t.Errorf("loudness = %q, want -27.61", got)
+	}
+	if _, err := parseLoudnormStats([]byte("size=N/A time=00:00:01.00")); err == nil {
+		t.Error("expected an error for a log without measurements")
+	}
+}
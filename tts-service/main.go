@@ -3,283 +3,845 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/singleflight"
 )
 
+// espeakBin is the espeak-ng executable (TTS_ESPEAK_BIN), for systems where
+// it is installed as "espeak" or off PATH.
+var espeakBin = envString("TTS_ESPEAK_BIN", "espeak-ng")
+
+// sayBin is the macOS say executable.
+var sayBin = "say"
+
+// espeakSampleRate is the rate espeak-ng writes WAV at.
+const espeakSampleRate = 22050
+
+// streamsAudio reports whether a render with provider streams to the client.
+// Chunked renders are always joined in memory first, as are clips whose
+// silence is trimmed, since the trailing silence is only known at the end,
+// clips padded with silence and clips encoded at a set bitrate.
+// TTS_ESPEAK_BUFFER=true buffers espeak too, so its responses carry
+// Content-Length, a duration and range support at the cost of first-byte
+// latency.
+func streamsAudio(provider string, chunks []string, req ttsRequest) bool {
+	if provider == "espeak" && envBool("TTS_ESPEAK_BUFFER", false) {
+		return false
+	}
+	return isStreaming(provider) && len(chunks) <= 1 && !trimEnabled() && !req.padded() && req.Bitrate == 0
+}
+
+// synthGroup collapses concurrent syntheses of the same cache key.
+var synthGroup singleflight.Group
+
+// synthesisBase is the parent of every synthesis context. It is cancelled
+// only when the shutdown grace period runs out.
+var synthesisBase, cancelSynthesis = context.WithCancel(context.Background())
+
 type ttsRequest struct {
 	Text        string `json:"text"`
 	Granularity string `json:"granularity"`
-	Lang        string `json:"lang"`
+	Lang        string `json:"lang"`     // one of our language codes, or "auto" to detect it from the script
+	Format      string `json:"format"`   // wav, mp3, ogg, opus or webm; empty keeps the provider's native format
+	Provider    string `json:"provider"` // espeak, mac, sarvam, polly, azure, piper, openai or elevenlabs; requires TTS_ALLOW_PROVIDER_OVERRIDE
+	SSML        bool   `json:"ssml"`     // text is an SSML document; auto-detected from a <speak> root
+
+	// Transliterate converts IAST text to the named script ("deva") and reads
+	// it with that script's voice.
+	Transliterate string `json:"transliterate"`
+
+	// ResponseFormat "json" returns a JSON envelope with base64 audio and
+	// metadata; "audio" forces raw audio. Empty follows the Accept header.
+	ResponseFormat string `json:"responseFormat"`
+
+	SampleRateHertz int `json:"sampleRateHertz"` // output sample rate; 0 keeps the provider's rate
+	Channels        int `json:"channels"`        // 1 (mono) or 2 (stereo); 0 keeps the provider's layout
+	Bitrate         int `json:"bitrate"`         // MP3 kbps, one of TTS_MP3_BITRATES; implies format mp3
+
+	// Gender ("male" or "female") or VoiceVariant (an espeak variant such
+	// as "f3" or "whisper") vary espeak's timbre; other providers pick
+	// voices by configuration instead.
+	Gender       string `json:"gender"`
+	VoiceVariant string `json:"voiceVariant"`
+
+	// Silence added before and after the clip so autoplayed clips don't
+	// start or end abruptly; clamped to 0..TTS_MAX_SILENCE_PAD_MS.
+	LeadSilenceMs  int `json:"leadSilenceMs"`
+	TrailSilenceMs int `json:"trailSilenceMs"`
+
+	// Optional prosody, clamped to each provider's limits.
+	Rate   float64 `json:"rate"`   // 0.25–4.0 multiplier of the granularity's rate (TTS_GRANULARITY_RATES); 0 = that rate
+	Pitch  float64 `json:"pitch"`  // -20..+20 semitones
+	Volume float64 `json:"volume"` // gain in dB
+
+	// DryRun returns the resolved synthesis plan as JSON instead of audio.
+	DryRun bool `json:"dryRun"`
+
+	// Download sends the audio as an attachment named Name (default the
+	// first words of the text), so browsers save it instead of playing it.
+	Download bool   `json:"download"`
+	Name     string `json:"name"`
+
+	// Texts are read as one clip, segmentPauseMs (default
+	// TTS_SEGMENT_PAUSE_MS) apart. Mutually exclusive with Text.
+	Texts          []string `json:"texts"`
+	SegmentPauseMs int      `json:"segmentPauseMs"`
+
+	pause    time.Duration // silence spliced between chunks, for verse and segment pauses
+	segments []int         // for Texts, the segment each chunk belongs to
+	estimate time.Duration // projected playback length, see limitDuration
+	voice    string        // voice-config candidate standing in for an unavailable voice
 }
 
 func main() {
+	slog.SetDefault(newLogger(os.Stderr))
+	resolveProviderOrder()
+	if activeProvider() == "espeak" || os.Getenv("TTS_ESPEAK_BIN") != "" {
+		if _, err := exec.LookPath(espeakBin); err != nil {
+			slog.Warn("espeak binary not found; set TTS_ESPEAK_BIN to its path", "bin", espeakBin, "err", err)
+		}
+	}
+	if g := os.Getenv("TTS_DEFAULT_GRANULARITY"); g != "" && defaultGranularity() == "" {
+		slog.Warn("ignoring invalid TTS_DEFAULT_GRANULARITY", "value", g)
+	}
+	resolveGranularityRates()
+	if _, err := parseMP3Bitrates(os.Getenv("TTS_MP3_BITRATES")); err != nil {
+		slog.Warn("ignoring invalid TTS_MP3_BITRATES, using the defaults", "err", err)
+	}
+	probeFFmpeg()
+	if err := initScratchDir(); err != nil {
+		slog.Error("temp dir not created, using TTS_TMP_DIR directly", "err", err)
+	}
+	if err := openAccessLog(); err != nil {
+		slog.Error("access log not opened", "err", err)
+	}
+	if err := loadLexicon(os.Getenv("TTS_LEXICON")); err != nil {
+		slog.Error("lexicon not loaded", "err", err)
+	}
+	if err := loadVoiceConfig(os.Getenv("TTS_VOICE_CONFIG")); err != nil {
+		slog.Error("voice config not loaded, using built-in voices", "err", err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/tts", handleTTS)
+	mux.HandleFunc("/api/tts/batch", handleTTSBatch)
+	mux.HandleFunc("/api/tts/ssml", handleTTSSSML)
+	mux.HandleFunc("/api/tts/variants", handleTTSVariants)
+	mux.HandleFunc("/api/voices", handleVoices)
+	mux.HandleFunc("/api/phonemes", handlePhonemes)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/version", handleVersion)
+	if !envBool("TTS_METRICS_DISABLED", false) {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+	// Admin endpoints exist only when there is an operator token to guard
+	// them.
+	if adminTokens := adminTokensFromEnv(); len(adminTokens) > 0 {
+		admin := func(h http.HandlerFunc) http.Handler { return requireAdmin(adminTokens, h) }
+		mux.Handle("/admin/cache/flush", admin(handleCacheFlush))
+		mux.Handle("/admin/warmup", admin(handleWarmup))
+		mux.Handle("/admin/warmup/", admin(handleWarmup))
+		mux.Handle("/admin/usage", admin(handleUsage))
+	}
+	tokens := authTokensFromEnv()
+	if len(tokens) > 0 {
+		usage = usageTrackerFromEnv()
+	}
 
-	// Simple CORS middleware for all routes
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Requested-With")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		// Fallback to not-found
-		http.NotFound(w, r)
+		writeError(w, http.StatusNotFound, "not_found", "not found")
 	})
 
-	port := os.Getenv("TTS_PORT")
-	if port == "" {
-		port = "8081"
+	var handler http.Handler = mux
+	if len(tokens) > 0 {
+		handler = requireToken(tokens, meterUsage(usage, handler))
+	}
+	if limiter := ipLimiterFromEnv(); limiter != nil {
+		handler = rateLimit(limiter, handler)
 	}
+	// CORS runs outermost so rejections carry the headers browsers need to
+	// read them.
+	handler = cors(corsFromEnv(), handler)
+	handler = compress(handler)
 
 	server := &http.Server{
-		Addr:              ":" + port,
-		Handler:           mux,
-		ReadHeaderTimeout: 5 * time.Second,
+		Addr:              listenAddr(),
+		Handler:           trackRequests(logRequests(shedLoad(maxInFlight(), recoverPanics(handler)))),
+		ReadHeaderTimeout: envDuration("TTS_READ_HEADER_TIMEOUT", 5*time.Second),
+		// Bodies are small; a client that trickles one in is dropped.
+		ReadTimeout:  envDuration("TTS_READ_TIMEOUT", 10*time.Second),
+		WriteTimeout: writeTimeout(),
+		IdleTimeout:  envDuration("TTS_IDLE_TIMEOUT", 120*time.Second),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP reloads the lexicon and voice config so curators can iterate
+	// without a restart.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := loadLexicon(os.Getenv("TTS_LEXICON")); err != nil {
+				slog.Error("lexicon reload failed, keeping the previous one", "err", err)
+			}
+			if err := loadVoiceConfig(os.Getenv("TTS_VOICE_CONFIG")); err != nil {
+				slog.Error("voice config reload failed, keeping the previous one", "err", err)
+			}
+		}
+	}()
+
+	if d := ttsCache.disk; d != nil {
+		go d.cleanLoop(ctx, envDuration("TTS_CACHE_CLEAN_INTERVAL", 10*time.Minute))
+	}
+	if usage != nil {
+		go usage.saveLoop(ctx, envDuration("TTS_USAGE_SAVE_INTERVAL", time.Minute))
+	}
+
+	errCh := make(chan error, 1)
+	redirectServer := listen(server, errCh)
+
+	select {
+	case err := <-errCh:
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("server error", "err", err)
+			os.Exit(1)
+		}
+		return
+	case <-ctx.Done():
 	}
+	stop()
 
-	log.Printf("tts-service listening on :%s", port)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("server error: %v", err)
+	grace := envDuration("TTS_SHUTDOWN_TIMEOUT", 15*time.Second)
+	slog.Info("shutting down", "in_flight", inFlightRequests.Load(), "grace", grace.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("shutdown grace period expired", "in_flight", inFlightRequests.Load(), "err", err)
+		// Stop running syntheses so providers remove their temp files before exit.
+		cancelSynthesis()
+		if !waitTimeout(&activeRequests, 5*time.Second) {
+			slog.Warn("gave up waiting for requests", "in_flight", inFlightRequests.Load())
+		}
 	}
+	if usage != nil {
+		if err := usage.save(); err != nil {
+			slog.Error("usage counts not saved", "path", usage.path, "err", err)
+		}
+	}
+	removeScratchDir()
+	slog.Info("tts-service stopped")
 }
 
 func handleTTS(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers for this endpoint
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
+	serveTTS(w, r, decodeTTSRequest)
+}
+
+// ttsDecoder reads a ttsRequest from r, returning the status, error code and
+// message to report when it can't.
+type ttsDecoder func(w http.ResponseWriter, r *http.Request) (ttsRequest, int, string, string)
+
+// serveTTS answers a synthesis request read by decode.
+func serveTTS(w http.ResponseWriter, r *http.Request, decode ttsDecoder) {
+	if reqID := r.Header.Get("X-Request-Id"); reqID != "" {
+		w.Header().Set("X-Request-Id", reqID)
 	}
-	if r.Method != http.MethodPost {
-		w.Header().Set("Allow", http.MethodPost)
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
 		return
 	}
 
-	var req ttsRequest
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+	provider := activeProvider()
+	reqLogger := logFrom(r.Context())
+	logger := reqLogger
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+	defer func() {
+		ttsRequests.WithLabelValues(provider, strconv.Itoa(sw.code())).Inc()
+	}()
+
+	// GET takes the same fields as query parameters so <audio src> players
+	// and CDNs can reference and cache clips directly.
+	req, status, code, msg := decode(w, r)
+	if code != "" {
+		writeError(w, status, code, msg)
 		return
 	}
 
-	text := req.Text
-	if len([]rune(text)) == 0 {
-		http.Error(w, "text is required", http.StatusBadRequest)
+	if req.Format == "" && req.Bitrate == 0 && acceptsWebM(r) {
+		req.Format = "webm"
+	}
+	job, perr := prepareTTS(req, provider)
+	if perr != nil {
+		writeAPIError(w, perr.status, perr.apiError)
 		return
 	}
-
-	if len([]rune(text)) > 2500 {
-		http.Error(w, "text too long", http.StatusBadRequest)
+	if req.DryRun {
+		writeJSON(w, http.StatusOK, planFor(job))
 		return
 	}
-
-	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
-	defer cancel()
+	if req.Download {
+		w = &downloadWriter{ResponseWriter: w, name: downloadName(job.req)}
+	}
+	req, provider = job.req, job.provider
+	text, chunks, key := job.text, job.chunks, job.key
+	recordRunes(r.Context(), len([]rune(req.Text)))
+	logger = logger.With("provider", provider)
+	if req.Transliterate != "" {
+		w.Header().Set("X-TTS-Transliterated", transliteratedHeader(req.Text))
+	}
 
 	// Common informational headers
-	if reqID := r.Header.Get("X-Request-Id"); reqID != "" {
-		w.Header().Set("X-Request-Id", reqID)
+	if job.detected != "" {
+		w.Header().Set("X-TTS-Lang-Detected", job.detected)
 	}
 	w.Header().Set("X-TTS-Lang", req.Lang)
 	w.Header().Set("X-TTS-Granularity", req.Granularity)
 
-	provider := os.Getenv("TTS_PROVIDER")
-	if provider == "sarvam" {
-		w.Header().Set("X-TTS-Provider", "sarvam")
-		if err := synthesizeWithSarvam(ctx, w, text, req); err != nil {
-			log.Printf("sarvam tts error: %v", err)
-			http.Error(w, "tts error", http.StatusInternalServerError)
+	setProviderHeaders(w.Header(), provider, req)
+
+	// Clients can ask for a JSON envelope with base64 audio and metadata
+	// instead of raw audio, via Accept or responseFormat. Word granularity
+	// adds word timepoints for karaoke-style highlighting.
+	w.Header().Add("Vary", "Accept")
+	asJSON := req.ResponseFormat == "json" || (req.ResponseFormat == "" && acceptsJSON(r))
+	respond := func(entry *cachedAudio) {
+		setSynthesisHeaders(w.Header(), entry.info)
+		if asJSON {
+			spoken := ""
+			if req.Granularity == "word" {
+				spoken = req.Text
+				if isSSML(spoken) {
+					spoken = ssmlToText(spoken, nil)
+				}
+			}
+			writeAudioJSON(w, entry, spoken)
+			return
 		}
-		return
+		writeAudio(w, r, entry)
 	}
 
-	// Default provider: espeak-ng
-	// On macOS, default to 'mac' provider if not specified
-	if provider == "" && isMacOS() {
-		provider = "mac"
+	if (req.Granularity == "word" || req.Granularity == "syllable") && !asJSON && acceptsMultipart(r) {
+		writeWordParts(w, r, job)
+		return
 	}
 
-	if provider == "mac" {
-		w.Header().Set("X-TTS-Provider", "mac")
-		if err := synthesizeWithMac(ctx, w, text, req); err != nil {
-			log.Printf("mac tts error: %v", err)
-			http.Error(w, "tts error", http.StatusInternalServerError)
+	cacheable := r.Method == http.MethodGet
+	if cacheable {
+		// The JSON envelope is a different representation of the same clip.
+		etagKey := key
+		if asJSON {
+			etagKey += "-json"
+		}
+		setCacheHeaders(w.Header(), etagKey)
+		// The ETag is derived from the inputs, so a matching client copy is
+		// current even when the audio has been evicted from ttsCache.
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etagFor(etagKey)) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if entry, ok := ttsCache.get(key); ok {
+		w.Header().Set("X-TTS-Cache", "hit")
+		respond(entry)
+		return
+	}
+	w.Header().Set("X-TTS-Cache", "miss")
+	extendWriteDeadline(w, synthesisTimeout(provider, len(chunks)))
+
+	// Identical concurrent requests share one synthesis. The first caller
+	// streams audio as it is produced; the others wait and are served the
+	// captured bytes. The synthesis runs under a shared flight context rather
+	// than the first caller's, so one disconnect does not cut off the rest,
+	// but it is cancelled (killing any subprocess) once every caller is gone.
+	flightCtx, release := synthFlights.join(key)
+	stopWatching := context.AfterFunc(r.Context(), release)
+	defer func() {
+		stopWatching()
+		release()
+	}()
+
+	streamed := false
+	v, err, _ := synthGroup.Do(key, func() (any, error) {
+		timeout := synthesisTimeout(provider, len(chunks))
+		sctx, cancel := context.WithTimeout(flightCtx, timeout)
+		defer cancel()
+		// The synthesis logs under the request that started it.
+		sctx = withLogger(sctx, reqLogger)
+		sctx, info := withSynthesisInfo(sctx)
+		if len(chunks) > 1 {
+			logger.Info("splitting text", "runes", len([]rune(text)), "chunks", len(chunks))
+		}
+		logger.Info("synthesis deadline", "timeout", timeout)
+
+		// A streaming primary provider writes straight through to this
+		// caller, so once it has sent audio its error is final. Otherwise
+		// the clip, or the fallback after a primary that failed before
+		// sending anything, is rendered into memory like a batch item.
+		chain := fallbackChain(provider)
+		if streamsAudio(provider, chunks, req) && !asJSON {
+			// The duration is only known at the end, so it goes in a trailer.
+			w.Header().Set("Trailer", "X-Audio-Duration-Ms")
+			cw := &captureWriter{ResponseWriter: w, info: info}
+			err := renderWith(sctx, provider, cw, text, chunks, req)
+			streamed = cw.buf.Len() > 0
+			if err == nil {
+				var entry *cachedAudio
+				if entry, err = finishClip(sctx, logger, job, provider, cw.buf.Bytes(), w.Header().Get("Content-Type"), info, streamed); err == nil {
+					return entry, nil
+				}
+			}
+			if streamed || len(chain) == 1 {
+				return nil, timeoutError(sctx, err)
+			}
+			logger.Warn("provider failed, falling back", "failed", provider, "err", err, "fallback", chain[1])
+			w.Header().Del("Trailer")
+			chain = chain[1:]
+		}
+		entry, err := renderBuffered(sctx, logger, job, info, chain)
+		if err != nil {
+			return nil, timeoutError(sctx, err)
 		}
+		return entry, nil
+	})
+	if err != nil && cacheable {
+		clearCacheHeaders(w.Header())
+	}
+	if errors.Is(err, errBusy) {
+		logger.Warn("tts busy", "err", err)
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "busy", "tts busy, retry later")
+		return
+	}
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		logger.Warn("provider unavailable", "err", err)
+		w.Header().Set("Retry-After", strconv.Itoa(open.retryAfterSeconds()))
+		writeError(w, http.StatusServiceUnavailable, "provider_unavailable", "tts provider unavailable, retry later")
+		return
+	}
+	if err != nil {
+		logger.Error("tts error", "err", err)
+		if streamed {
+			return // audio already sent; too late for an error body
+		}
+		if errors.Is(err, errSynthesisTimeout) {
+			writeError(w, http.StatusGatewayTimeout, "synthesis_timeout", "tts timed out")
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, synthesisFailed(err))
 		return
 	}
+	entry := v.(*cachedAudio)
+	if cacheable && entry.provider != provider {
+		// Let the next request retry the primary provider.
+		clearCacheHeaders(w.Header())
+	}
+	provider = entry.provider
+	if streamed {
+		setDurationHeader(w.Header(), entry)
+		return
+	}
+	setProviderHeaders(w.Header(), provider, req)
+	respond(entry)
+}
+
+// renderWith synthesizes text with one provider while holding a synthesis
+// slot, splitting it into chunks when there is more than one. Providers with
+// an open circuit breaker fail immediately, so the fallback chain moves on.
+func renderWith(ctx context.Context, provider string, w http.ResponseWriter, text string, chunks []string, req ttsRequest) (err error) {
+	b := breakers[provider]
+	if err := b.allow(); err != nil {
+		return err
+	}
+	defer func() { b.record(ctx, err) }()
+
+	releaseSlot, err := acquireSlot(ctx, provider)
+	if err != nil {
+		return err
+	}
+	defer releaseSlot()
+
+	renderReq := req
+	if req.Bitrate != 0 {
+		// Rendered as WAV and encoded once at the requested bitrate.
+		renderReq.Format = "wav"
+	}
+	var data []byte
+	var contentType string
+	switch {
+	case len(chunks) > 1:
+		data, contentType, err = synthesizeChunks(ctx, provider, chunks, renderReq)
+	case req.padded() || req.Bitrate != 0:
+		buf := newResponseBuffer()
+		err = synthesize(ctx, provider, buf, text, renderReq)
+		data, contentType = buf.buf.Bytes(), buf.header.Get("Content-Type")
+	default:
+		return synthesize(ctx, provider, w, text, req)
+	}
+	if err != nil {
+		return err
+	}
+	if req.padded() {
+		if data, err = padSilence(ctx, data, contentType, req.LeadSilenceMs, req.TrailSilenceMs); err != nil {
+			return err
+		}
+	}
+	if req.Bitrate != 0 {
+		var out bytes.Buffer
+		if err := encodeMP3(ctx, &out, bytes.NewReader(data), req.Bitrate); err != nil {
+			return err
+		}
+		data, contentType = out.Bytes(), audioContentTypes["mp3"]
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err = w.Write(data)
+	return err
+}
+
+// setProviderHeaders reports the provider and its effective prosody.
+func setProviderHeaders(h http.Header, provider string, req ttsRequest) {
+	h.Set("X-TTS-Provider", provider)
+	pros := resolveProsody(provider, req)
+	h.Set("X-TTS-Rate", formatProsodyValue(pros.Rate))
+	h.Set("X-TTS-Pitch", formatProsodyValue(pros.Pitch))
+	h.Set("X-TTS-Volume", formatProsodyValue(pros.Volume))
+}
+
+// writeAudio writes a fully rendered clip with its length and duration.
+func writeAudio(w http.ResponseWriter, r *http.Request, entry *cachedAudio) {
+	w.Header().Set("Content-Type", entry.contentType)
+	setDurationHeader(w.Header(), entry)
+	if entry.loudness != "" {
+		w.Header().Set("X-Audio-Loudness", entry.loudness)
+	}
+	// ServeContent answers Range requests with 206 so players can seek
+	// within buffered and cached clips.
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(entry.data))
+}
 
-	w.Header().Set("X-TTS-Provider", "espeak")
-	if err := synthesizeWithEspeak(ctx, w, text, req); err != nil {
-		log.Printf("espeak-ng tts error: %v", err)
-		http.Error(w, "tts error", http.StatusInternalServerError)
+// synthesize renders text with provider, writing the audio response to w.
+func synthesize(ctx context.Context, provider string, w http.ResponseWriter, text string, req ttsRequest) error {
+	p, ok := providers[provider]
+	if !ok {
+		return fmt.Errorf("unknown provider %q", provider)
+	}
+
+	ctx = withLogger(ctx, logFrom(ctx).With("provider", provider))
+	startSynthesis(ctx, provider, req.SampleRateHertz)
+	text = applyLexicon(text, req.Lang, provider, req.SSML)
+	ttsSynthesisInFlight.Inc()
+	defer ttsSynthesisInFlight.Dec()
+	start := time.Now()
+	var err error
+	for {
+		if req.SampleRateHertz != 0 || req.Channels != 0 {
+			err = synthesizeResampled(ctx, p, nativeFormats[provider], w, text, req)
+		} else {
+			err = writeSynthesis(ctx, p, w, text, req)
+		}
+		// A voice the provider doesn't know fails before any audio is
+		// written, so this request can try the next configured candidate.
+		var unavailable *voiceUnavailableError
+		if !errors.As(err, &unavailable) {
+			if err == nil {
+				voiceSucceeded(req.Lang, provider, firstNonEmpty(req.voice, configuredVoice(req.Lang, provider).Voice))
+			}
+			break
+		}
+		voiceFailed(req.Lang, provider, unavailable.voice)
+		next, ok := nextConfiguredVoice(req.Lang, provider, unavailable.voice)
+		if !ok {
+			break
+		}
+		logFrom(ctx).Warn("voice unavailable, trying the next configured voice", "voice", unavailable.voice, "next", next, "err", err)
+		req.voice = next
 	}
+	ttsSynthesisDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ttsSynthesisErrors.WithLabelValues(provider).Inc()
+	}
+	return err
+}
+
+// synthesizeResampled runs p with its output piped through ffmpeg to
+// convert it to req.SampleRateHertz and req.Channels, keeping streaming
+// providers streaming.
+func synthesizeResampled(ctx context.Context, p Provider, native string, w http.ResponseWriter, text string, req ttsRequest) error {
+	format := resolveFormat(req.Format, native)
+	pr, pw := io.Pipe()
+	pipeW := &pipeResponseWriter{header: make(http.Header), w: pw}
+	synthErr := make(chan error, 1)
+	go func() {
+		err := writeSynthesis(ctx, p, pipeW, text, req)
+		pw.CloseWithError(err)
+		synthErr <- err
+	}()
+
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	err := resample(ctx, w, pr, format, req.SampleRateHertz, req.Channels)
+	pr.CloseWithError(err) // unblock the synthesizer if ffmpeg stopped reading
+	if serr := <-synthErr; serr != nil {
+		return serr
+	}
+	return err
 }
 
 func isMacOS() bool {
 	// Check if 'say' command exists
-	_, err := exec.LookPath("say")
+	_, err := exec.LookPath(sayBin)
 	return err == nil
 }
 
 // synthesizeWithEspeak streams audio using local espeak-ng. It writes the response directly.
 func synthesizeWithEspeak(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
-	voice := os.Getenv("TTS_VOICE")
-	if voice == "" {
-		// Derive a reasonable espeak-ng voice from the primary UI language.
-		// IAST/English falls back to Hindi by default.
-		switch req.Lang {
-		case "deva":
-			voice = "hi" // Devanagari → Hindi voice (closest available)
-		case "iast":
-			voice = "hi" // Latin transliteration treated as Sanskrit/Hindi
-		case "knda":
-			voice = "kn" // Kannada → kn
-		case "tel":
-			voice = "te" // Telugu → te
-		case "tam":
-			voice = "ta" // Tamil → ta
-		case "guj":
-			voice = "gu" // Gujarati → gu
-		case "pan":
-			voice = "pa" // Punjabi → pa
-		case "mr":
-			voice = "mr" // Marathi → mr
-		case "ben":
-			voice = "bn" // Bengali → bn
-		case "mal":
-			voice = "ml" // Malayalam → ml
-		default:
-			// Unknown or missing lang – fall back to Hindi as a generic Indic voice
-			voice = "hi"
-		}
+	voice, mbrolaMissing := espeakVoiceFor(req.Lang)
+	if mbrolaMissing {
+		logFrom(ctx).Warn("mbrola voice not installed, using the standard voice", "mbrola", mbrolaVoice(req.Lang), "voice", voice)
 	}
-	args := []string{"--stdout", text}
+	voice = withVariant(voice, espeakVariant(req))
+	args := []string{}
 	if voice != "" {
-		args = []string{"-v", voice, "--stdout", text}
+		args = append(args, "-v", voice)
+	}
+	if req.SSML {
+		// espeak reads only part of SSML; translate what it can't into
+		// flags and plain text.
+		markup := espeakSSML(text, req.Lang)
+		text = markup.text
+		if markup.rate > 0 {
+			if req.Rate == 0 {
+				req.Rate = 1
+			}
+			req.Rate *= markup.rate
+		}
+		req.Pitch += markup.pitch
+		if len(markup.dropped) > 0 {
+			logFrom(ctx).Info("ssml tags espeak can't render were stripped", "tags", markup.dropped)
+		}
+		args = append(args, "-m") // interpret SSML markup
+	}
+	pros := resolveProsody("espeak", req)
+	if speed := clamp(espeakBaseSpeed*pros.Rate, 80, 450); speed != espeakBaseSpeed {
+		args = append(args, "-s", strconv.Itoa(int(math.Round(speed))))
+	}
+	if req.Granularity == "word" {
+		if gap := espeakWordGap(); gap > 0 {
+			args = append(args, "-g", strconv.Itoa(gap))
+		}
 	}
-	log.Printf("tts[espeak]: len=%d, voice=%q", len([]rune(text)), voice)
+	if pros.Pitch != 0 {
+		args = append(args, "-p", strconv.Itoa(int(math.Round(clamp(50+pros.Pitch*2.5, 0, 99)))))
+	}
+	if pros.Volume != 0 {
+		args = append(args, "-a", strconv.Itoa(int(math.Round(clamp(100*gainToAmplitude(pros.Volume), 0, 200)))))
+	}
+	args = append(args, "--stdout", text)
+	logFrom(ctx).Info("synthesizing", "runes", len([]rune(text)), "voice", voice)
+	reportVoice(ctx, voice, "", espeakSampleRate)
 
-	cmd := exec.CommandContext(ctx, "espeak-ng", args...)
-	stdout, err := cmd.StdoutPipe()
+	// Forks can fail under load; TTS_ESPEAK_SPAWN_RETRIES retries those.
+	cmd, stdout, err := startWithRetry(ctx, espeakBin, envInt("TTS_ESPEAK_SPAWN_RETRIES", 3), func() *exec.Cmd {
+		cmd := exec.CommandContext(ctx, espeakBin, args...)
+		// Once ctx is cancelled the process is killed; don't let Wait hang on
+		// pipes held open by anything it spawned.
+		cmd.WaitDelay = time.Second
+		return cmd
+	})
 	if err != nil {
-		log.Printf("espeak stdout pipe error: %v", err)
+		logFrom(ctx).Error("espeak start error", "err", err)
 		return err
 	}
+	format := resolveFormat(req.Format, "wav")
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	var streamErr error
+	if format != "wav" {
+		// espeak-ng only emits WAV; stream it through ffmpeg for other formats.
+		if streamErr = transcode(ctx, w, stdout, format); streamErr != nil {
+			logFrom(ctx).Error("espeak transcode error", "err", streamErr)
+		}
+	} else if n, err := io.Copy(w, stdout); err != nil {
+		logFrom(ctx).Warn("espeak streaming error", "bytes", n, "err", err)
+		streamErr = err
+	}
 
-	if err := cmd.Start(); err != nil {
-		log.Printf("espeak command start error: %v", err)
+	if err := cmd.Wait(); err != nil {
+		logFrom(ctx).Error("espeak-ng exited with error", "err", err)
 		return err
 	}
-	w.Header().Set("Content-Type", "audio/wav")
-	if n, err := io.Copy(w, stdout); err != nil {
-		log.Printf("espeak streaming error after %s bytes: %v", strconv.FormatInt(n, 10), err)
+	// Surface mid-stream failures so they are counted and the clip isn't cached.
+	return streamErr
+}
+
+// espeakBaseSpeed is espeak-ng's default speed in words per minute, used
+// at rate 1.
+const espeakBaseSpeed = 175
+
+// espeakWordGap is the extra pause between words, in units of 10ms, used
+// for word granularity (TTS_ESPEAK_WORD_GAP, default 2).
+func espeakWordGap() int {
+	return envInt("TTS_ESPEAK_WORD_GAP", 2)
+}
+
+// espeakVoice derives a reasonable espeak-ng voice from the primary UI language.
+// IAST/English falls back to Hindi by default.
+func espeakVoice(lang string) string {
+	if l, ok := lookupLang(lang); ok {
+		return l.espeak
 	}
+	// Unknown or missing lang – fall back to a generic Indic voice, Hindi
+	// unless TTS_ESPEAK_DEFAULT_VOICE says otherwise
+	return envString("TTS_ESPEAK_DEFAULT_VOICE", "hi")
+}
 
-	if err := cmd.Wait(); err != nil {
-		log.Printf("espeak-ng exited with error: %v", err)
-		return err
+// macBaseRate is say's speed in words per minute at rate 1.
+const macBaseRate = 180
+
+// macRate is the say -r rate for pros.
+func macRate(pros prosody) int {
+	return int(math.Round(macBaseRate * pros.Rate))
+}
+
+// macVoice returns the say voice for lang: the configured one, or Lekha
+// (Hindi, good for Sanskrit), or Rishi (Indian English) for IAST.
+func macVoice(lang string) string {
+	if v := configuredVoice(lang, "mac").Voice; v != "" {
+		return v
 	}
-	return nil
+	if lang == "iast" {
+		return "Rishi"
+	}
+	return "Lekha"
 }
 
 // synthesizeWithMac uses the macOS 'say' command.
 func synthesizeWithMac(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
-	// Determine voice
-	voice := "Lekha" // Default to Lekha (Hindi) which is good for Sanskrit
-	if req.Lang == "iast" {
-		voice = "Rishi" // Indian English for IAST
-	}
+	voice := macVoice(req.Lang)
 
-	// Determine rate
-	rate := "180" // Default
-	switch req.Granularity {
-	case "verse":
-		rate = "140" // Slower for verses
-	case "line":
-		rate = "160"
-	case "word":
-		rate = "180"
+	pros := resolveProsody("mac", req)
+	rate := strconv.Itoa(macRate(pros))
+
+	// say doesn't read SSML; keep the text and turn breaks into silence commands.
+	if req.SSML {
+		text = ssmlToText(text, func(d time.Duration) string {
+			return fmt.Sprintf(" [[slnc %d]] ", d.Milliseconds())
+		})
 	}
 
-	// Create temp AIFF file
-	tmpAiff, err := os.CreateTemp("", "tts-*.aiff")
+	// say can't write WAVE to a pipe (it seeks back to patch the header), so it
+	// writes straight to a WAV temp file instead of AIFF plus an afconvert pass.
+	// The deferred cleanup also runs when ctx kills say mid-write.
+	dir, cleanup, err := requestTempDir(ctx)
 	if err != nil {
 		return err
 	}
-	aiffPath := tmpAiff.Name()
-	tmpAiff.Close()
-	defer os.Remove(aiffPath)
+	defer cleanup()
+	wavPath := filepath.Join(dir, "say.wav")
 
-	// Use say to generate AIFF
-	args := []string{"-v", voice, "-r", rate, "-o", aiffPath, text}
-	log.Printf("tts[mac]: cmd=say %v", args)
-	cmd := exec.CommandContext(ctx, "say", args...)
+	args := []string{"-v", voice, "-r", rate, "--file-format=WAVE", "--data-format=LEI16@44100", "-o", wavPath, text}
+	reportVoice(ctx, voice, "", 44100)
+	logFrom(ctx).Debug("running say", "args", args)
+	cmd := exec.CommandContext(ctx, sayBin, args...)
+	// Don't wait on a killed say's output pipe past the deadline.
+	cmd.WaitDelay = time.Second
 	if output, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("mac tts error: %v, output: %s", err, string(output))
+		logFrom(ctx).Error("say failed", "err", err, "output", string(output))
 		return err
 	}
 
-	// Convert AIFF to WAV using afconvert
-	tmpWav, err := os.CreateTemp("", "tts-*.wav")
+	f, err := os.Open(wavPath)
 	if err != nil {
 		return err
 	}
-	wavPath := tmpWav.Name()
-	tmpWav.Close()
-	defer os.Remove(wavPath)
+	defer f.Close()
 
-	convCmd := exec.CommandContext(ctx, "afconvert", "-f", "WAVE", "-d", "LEI16@44100", aiffPath, wavPath)
-	if out, err := convCmd.CombinedOutput(); err != nil {
-		log.Printf("afconvert error: %v, output: %s", err, string(out))
-		return err
+	format := resolveFormat(req.Format, "wav")
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	if pros.Volume != 0 {
+		// say has no volume flag, so apply the gain while (re)encoding.
+		return filterAudio(ctx, w, f, format, "volume="+formatProsodyValue(pros.Volume)+"dB")
 	}
-
-	// Read back the WAV file
-	data, err := os.ReadFile(wavPath)
-	if err != nil {
-		return err
+	if format != "wav" {
+		return transcode(ctx, w, f, format)
 	}
 
-	w.Header().Set("Content-Type", "audio/wav")
-	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	if _, err := w.Write(data); err != nil {
-		return err
+	if fi, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
 	}
+	_, err = io.Copy(w, f)
+	return err
+}
 
-	return nil
+// sarvamSpeaker is the Sarvam speaker configured for lang, or amit.
+func sarvamSpeaker(lang string) string {
+	return firstNonEmpty(configuredVoice(lang, "sarvam").Voice, "amit")
 }
 
 // synthesizeWithSarvam uses the Sarvam.ai Text-to-Speech API.
-// It expects SARVAM_API_KEY to be set and writes an MP3 audio response.
+// It expects SARVAM_API_KEY to be set and writes an MP3 audio response unless
+// another format is requested. Sarvam produces MP3 and WAV natively; OGG and
+// Opus are transcoded from MP3 with ffmpeg.
 func synthesizeWithSarvam(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
 	apiKey := os.Getenv("SARVAM_API_KEY")
 	if apiKey == "" {
 		return fmt.Errorf("SARVAM_API_KEY not set")
 	}
 
-	langCode := sarvamLangCode(req.Lang)
+	m := configuredVoice(req.Lang, "sarvam")
+	langCode := firstNonEmpty(m.LanguageCode, sarvamLangCode(req.Lang))
+	speaker := firstNonEmpty(req.voice, sarvamSpeaker(req.Lang))
+	if req.SSML {
+		// Sarvam has no SSML input; synthesize the spoken text only.
+		text = ssmlToText(text, func(time.Duration) string { return " " })
+	}
+	format := resolveFormat(req.Format, "mp3")
+	codec := "mp3"
+	if format == "wav" {
+		codec = "wav"
+	}
 
 	body := map[string]any{
 		"text":                 text,
 		"target_language_code": langCode,
-		"model":               "bulbul:v3",
-		"speaker":             "amit",
-		"output_audio_codec":  "mp3",
+		"model":                "bulbul:v3",
+		"speaker":              speaker,
+		"output_audio_codec":   codec,
+	}
+	reportVoice(ctx, speaker, langCode, 0)
+	// Sarvam takes pace and loudness as multipliers and pitch in -0.75..0.75.
+	pros := resolveProsody("sarvam", req)
+	if pros.Rate != 1 {
+		body["pace"] = pros.Rate
+	}
+	if pros.Pitch != 0 {
+		body["pitch"] = pros.Pitch / 20 * 0.75
+	}
+	if pros.Volume != 0 {
+		body["loudness"] = gainToAmplitude(pros.Volume)
 	}
 
 	payload, err := json.Marshal(body)
@@ -287,73 +849,78 @@ func synthesizeWithSarvam(ctx context.Context, w http.ResponseWriter, text strin
 		return err
 	}
 
-	reqHTTP, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.sarvam.ai/text-to-speech", bytes.NewReader(payload))
-	if err != nil {
+	if err := waitProvider(ctx, "sarvam", text); err != nil {
 		return err
 	}
-	reqHTTP.Header.Set("Content-Type", "application/json")
-	reqHTTP.Header.Set("api-subscription-key", apiKey)
-
-	resp, err := http.DefaultClient.Do(reqHTTP)
+	// 429s and 5xx are retried (SARVAM_MAX_RETRIES, default 3) since
+	// Sarvam rate limits bursts.
+	start := time.Now()
+	resp, err := doWithRetry(ctx, "sarvam", envInt("SARVAM_MAX_RETRIES", 3), func() (*http.Request, error) {
+		reqHTTP, err := http.NewRequestWithContext(ctx, http.MethodPost, sarvamBaseURL()+"/text-to-speech", bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		reqHTTP.Header.Set("Content-Type", "application/json")
+		reqHTTP.Header.Set("api-subscription-key", apiKey)
+		return reqHTTP, nil
+	})
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("sarvam tts http status %d", resp.StatusCode)
-		return fmt.Errorf("sarvam tts status %d", resp.StatusCode)
-	}
-
-	var respBody struct {
-		Audios []string `json:"audios"`
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		logFrom(ctx).Warn("tts http error", "status", resp.StatusCode, "body", strings.TrimSpace(string(msg)))
+		return voiceError("sarvam", resp.StatusCode, msg, speaker, fmt.Errorf("sarvam tts status %d", resp.StatusCode))
+	}
+
+	var audio io.Reader
+	streaming := false
+	if sarvamStreaming() {
+		var respBody io.Reader
+		if respBody, streaming = sarvamAudioStream(resp.Body); streaming {
+			audio = respBody
+		} else {
+			logFrom(ctx).Warn("sarvam response not streamable, decoding it whole")
+			resp.Body = io.NopCloser(respBody)
+		}
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-		return err
+	if !streaming {
+		data, err := decodeSarvamAudios(resp.Body)
+		if err != nil {
+			return err
+		}
+		audio = bytes.NewReader(data)
 	}
 
-	if len(respBody.Audios) == 0 || respBody.Audios[0] == "" {
-		return fmt.Errorf("sarvam tts empty audios")
+	// Time to first byte is logged in both modes so they can be compared.
+	fw := &firstByteWriter{w: flushWriter{w}, start: start}
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	if format != codec {
+		err = transcode(ctx, fw, audio, format)
+	} else {
+		_, err = io.Copy(fw, audio)
 	}
-
-	data, err := base64.StdEncoding.DecodeString(respBody.Audios[0])
 	if err != nil {
 		return err
 	}
 
-	w.Header().Set("Content-Type", "audio/mpeg")
-	if _, err := w.Write(data); err != nil {
-		return err
-	}
-
-	log.Printf("tts[sarvam]: len=%d, lang=%q, bytes=%d", len([]rune(text)), req.Lang, len(data))
+	logFrom(ctx).Info("synthesized", "runes", len([]rune(text)), "lang", req.Lang, "bytes", fw.n,
+		"streaming", streaming, "ttfb_ms", fw.ttfb.Milliseconds())
 	return nil
 }
 
-// sarvamLangCode maps our primary language codes to BCP-47 codes for Sarvam.ai.
+// sarvamBaseURL is SARVAM_BASE_URL or the public API.
+func sarvamBaseURL() string {
+	return envString("SARVAM_BASE_URL", "https://api.sarvam.ai")
+}
+
+// sarvamLangCode maps our primary language codes to BCP-47 codes for
+// Sarvam.ai, falling back to Hindi.
 func sarvamLangCode(lang string) string {
-	switch lang {
-	case "deva":
-		return "hi-IN"
-	case "iast":
-		return "en-IN" // English (India) for transliteration
-	case "knda":
-		return "kn-IN"
-	case "tel":
-		return "te-IN"
-	case "tam":
-		return "ta-IN"
-	case "guj":
-		return "gu-IN"
-	case "pan":
-		return "pa-IN"
-	case "mr":
-		return "mr-IN"
-	case "ben":
-		return "bn-IN"
-	case "mal":
-		return "ml-IN"
-	default:
-		return "hi-IN"
+	if l, ok := lookupLang(lang); ok {
+		return l.bcp47
 	}
+	return "hi-IN"
 }
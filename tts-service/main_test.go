@@ -0,0 +1,748 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// stubSynthesizer replaces the synthesizer for provider for the duration of
+// the test. The stub streams if the provider it replaces does.
+func stubSynthesizer(t *testing.T, provider string, fn synthesizerFunc) {
+	t.Helper()
+	orig, had := providers[provider]
+	if isStreaming(provider) {
+		providers[provider] = streamingProvider(fn)
+	} else {
+		providers[provider] = bufferedProvider(fn)
+	}
+	t.Cleanup(func() {
+		if had {
+			providers[provider] = orig
+		} else {
+			delete(providers, provider)
+		}
+	})
+}
+
+// withCache swaps the shared audio cache for the duration of the test.
+func withCache(t *testing.T, c *audioCache) {
+	t.Helper()
+	orig := ttsCache
+	ttsCache = c
+	t.Cleanup(func() { ttsCache = orig })
+}
+
+func newTTSRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/tts", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestConcurrentIdenticalRequestsSynthesizeOnce(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	// Disable the cache so only request collapsing can prevent repeat work.
+	withCache(t, newAudioCache(0, 0))
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("RIFF-audio"))
+		return err
+	})
+
+	const n = 50
+	var started, done sync.WaitGroup
+	started.Add(n)
+	done.Add(n)
+	recs := make([]*httptest.ResponseRecorder, n)
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		go func(rec *httptest.ResponseRecorder) {
+			defer done.Done()
+			started.Done()
+			handleTTS(rec, newTTSRequest(`{"text":"नमो नमः","lang":"deva","granularity":"verse"}`))
+		}(recs[i])
+	}
+	started.Wait()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("synthesizer ran %d times, want 1", got)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, rec.Code)
+		}
+		if got := rec.Body.String(); got != "RIFF-audio" {
+			t.Fatalf("request %d: body %q", i, got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "audio/wav" {
+			t.Fatalf("request %d: content type %q", i, got)
+		}
+	}
+}
+
+func TestEspeakKilledWhenClientDisconnects(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+
+	// A stand-in for espeak-ng that records its PID and never finishes.
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "pid")
+	script := filepath.Join(dir, "espeak-ng")
+	body := "#!/bin/sh\necho $$ > " + pidFile + "\nprintf RIFF\nexec sleep 30\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := espeakBin
+	espeakBin = script
+	t.Cleanup(func() { espeakBin = orig })
+
+	srv := httptest.NewServer(http.HandlerFunc(handleTTS))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(`{"text":"नमः","lang":"deva"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	var pid int
+	waitFor(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(b)))
+		return err == nil
+	})
+
+	cancel()
+
+	// Signal 0 still succeeds for a zombie, so ESRCH means killed and reaped.
+	waitFor(t, 3*time.Second, func() bool {
+		return errors.Is(syscall.Kill(pid, 0), syscall.ESRCH)
+	})
+}
+
+// waitFor polls cond until it is true, failing the test after timeout.
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %s", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTextNormalizedToNFC(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+
+	var got []string
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		got = append(got, text)
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("RIFF-audio"))
+		return err
+	})
+
+	// ऩ composes from न + nukta; क़ is a composition exclusion and
+	// decomposes to क + nukta.
+	precomposed := "\u0929\u093e\u092e \u0958\u0932\u092e"
+	decomposed := "\u0928\u093c\u093e\u092e \u0915\u093c\u0932\u092e"
+	for _, text := range []string{precomposed, decomposed} {
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(`{"text":"`+text+`","lang":"deva"}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+	}
+	if len(got) != 2 || got[0] != got[1] {
+		t.Fatalf("synthesizer got %q, want identical input", got)
+	}
+}
+
+func TestWhitespaceVariantsShareCacheEntry(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(16, 1<<20))
+
+	var got []string
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		got = append(got, text)
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("RIFF-audio"))
+		return err
+	})
+
+	for _, text := range []string{`ॐ नमः  शिवाय `, `  ॐ\tनमः शिवाय`, `ॐ नमः शिवाय\nशिवाय`} {
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(`{"text":"`+text+`","lang":"deva"}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+	}
+	// The first spelling is synthesized as sent; the second is a cache hit;
+	// the third has an extra line and is synthesized.
+	if len(got) != 2 || got[0] != "ॐ नमः  शिवाय " {
+		t.Fatalf("synthesizer got %q", got)
+	}
+}
+
+func TestRangeRequest(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "sarvam")
+	withCache(t, newAudioCache(16, 1<<20))
+
+	audio := make([]byte, 4096)
+	for i := range audio {
+		audio[i] = byte(i)
+	}
+	stubSynthesizer(t, "sarvam", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, err := w.Write(audio)
+		return err
+	})
+
+	// The first request renders into the cache, the second is a cache hit.
+	for _, want := range []string{"miss", "hit"} {
+		req := newTTSRequest(`{"text":"नमः","lang":"deva"}`)
+		req.Header.Set("Range", "bytes=0-1023")
+		rec := httptest.NewRecorder()
+		handleTTS(rec, req)
+
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("cache %s: status %d, want 206", want, rec.Code)
+		}
+		if got := rec.Header().Get("X-TTS-Cache"); got != want {
+			t.Fatalf("X-TTS-Cache %q, want %q", got, want)
+		}
+		if got := rec.Header().Get("Content-Range"); got != "bytes 0-1023/4096" {
+			t.Fatalf("cache %s: Content-Range %q", want, got)
+		}
+		if got := rec.Header().Get("Accept-Ranges"); got != "bytes" {
+			t.Fatalf("cache %s: Accept-Ranges %q", want, got)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), audio[:1024]) {
+			t.Fatalf("cache %s: body is not the first 1024 bytes", want)
+		}
+	}
+}
+
+func TestWordPartsAsMultipart(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("audio:" + text))
+		return err
+	})
+
+	get := func() *httptest.ResponseRecorder {
+		req := newTTSRequest(`{"text":"राम नमः","lang":"deva","granularity":"word"}`)
+		req.Header.Set("Accept", "multipart/mixed")
+		rec := httptest.NewRecorder()
+		handleTTS(rec, req)
+		return rec
+	}
+	rec := get()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %q: %v", rec.Header().Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(bytes.NewReader(rec.Body.Bytes()), params["boundary"])
+	for i, word := range []string{"राम", "नमः"} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		_, dparams, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil || dparams["name"] != word {
+			t.Errorf("part %d: disposition %q: %v", i, part.Header.Get("Content-Disposition"), err)
+		}
+		if got := part.Header.Get("X-Word-Index"); got != strconv.Itoa(i) {
+			t.Errorf("part %d: index %q", i, got)
+		}
+		if body, _ := io.ReadAll(part); string(body) != "audio:"+word {
+			t.Errorf("part %d: body %q", i, body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("after last part: %v", err)
+	}
+	if again := get(); again.Body.String() != rec.Body.String() {
+		t.Error("identical requests gave different multipart bodies")
+	}
+}
+
+func TestResolvedVoiceHeaders(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	withCache(t, newAudioCache(10, 0))
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		reportVoice(ctx, "mb-hi1", "hi-IN", 0)
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(pcmToWAV(make([]byte, 3200), 16000, 1))
+		return err
+	})
+
+	// The second request is a cache hit and must report the same voice.
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(`{"text":"नमः","lang":"deva"}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, rec.Code)
+		}
+		for name, want := range map[string]string{
+			"X-TTS-Provider":      "mac",
+			"X-TTS-Voice":         "mb-hi1",
+			"X-TTS-Language-Code": "hi-IN",
+			"X-TTS-Sample-Rate":   "16000",
+		} {
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("request %d: %s = %q, want %q", i, name, got, want)
+			}
+		}
+	}
+}
+
+func TestAcceptWebMStreamsFlushed(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		if req.Format != "webm" {
+			t.Errorf("format = %q, want webm", req.Format)
+		}
+		w.Header().Set("Content-Type", audioContentTypes[req.Format])
+		_, err := w.Write([]byte("webm-cluster"))
+		return err
+	})
+
+	req := newTTSRequest(`{"text":"नमः","lang":"deva"}`)
+	req.Header.Set("Accept", "audio/webm")
+	rec := httptest.NewRecorder()
+	handleTTS(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "audio/webm; codecs=opus" {
+		t.Errorf("content type %q", got)
+	}
+	if !rec.Flushed {
+		t.Error("streamed audio was not flushed")
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Error("streamed response has a Content-Length")
+	}
+}
+
+func TestWriteDeadlineCoversSynthesis(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	t.Setenv("TTS_WRITE_TIMEOUT", "100ms")
+	withCache(t, newAudioCache(0, 0))
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		time.Sleep(300 * time.Millisecond) // longer than the server's WriteTimeout
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("RIFF-audio"))
+		return err
+	})
+
+	srv := httptest.NewUnstartedServer(logRequests(http.HandlerFunc(handleTTS)))
+	srv.Config.WriteTimeout = writeTimeout()
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"text":"नमः","lang":"deva"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "RIFF-audio" {
+		t.Fatalf("body %q, err %v", body, err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	t.Setenv("TTS_VERSE_PAUSE_MS", "300")
+	withCache(t, newAudioCache(16, 1<<20))
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		t.Error("dry run synthesized audio")
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"rāma । kṛṣṇa ।","lang":"iast","transliterate":"deva","granularity":"verse","dryRun":true}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var plan synthesisPlan
+	if err := json.Unmarshal(rec.Body.Bytes(), &plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Provider != "mac" || plan.Voice != "Lekha" || plan.Lang != "deva" || plan.LanguageCode != "hi-IN" || plan.Format != "wav" {
+		t.Errorf("plan = %+v", plan)
+	}
+	if len(plan.Chunks) != 2 || plan.Chunks[0] != "राम ।" || plan.Chunks[1] != "कृष्ण ।" {
+		t.Errorf("chunks = %q", plan.Chunks)
+	}
+	if plan.PauseMs != 300 || plan.EstimatedDurationMs <= 300 || plan.Key == "" {
+		t.Errorf("pause %d, estimate %d, key %q", plan.PauseMs, plan.EstimatedDurationMs, plan.Key)
+	}
+
+	rec = httptest.NewRecorder()
+	handleTTS(rec, httptest.NewRequest(http.MethodGet, "/api/tts?text=%E0%A4%B0%E0%A4%BE%E0%A4%AE&lang=deva&dryRun=true", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"chunks":["राम"]`) {
+		t.Errorf("GET dry run: status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	withCache(t, newAudioCache(16, 1<<20))
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(pcmToWAV(make([]byte, 100), 8000, 1))
+		return err
+	})
+
+	tests := []struct {
+		name, body string
+		filename   string
+	}{
+		{"named", `{"text":"राम","lang":"deva","download":true,"name":"Gita 2.47!"}`, "Gita-2-47.wav"},
+		{"first words", `{"text":"राम नाम सत्य है, सदा सत्य है","lang":"deva","download":true}`, "राम-नाम-सत्य-है.wav"},
+		{"inline", `{"text":"राम","lang":"deva","name":"x"}`, ""},
+		{"json envelope", `{"text":"राम","lang":"deva","download":true,"responseFormat":"json"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleTTS(rec, newTTSRequest(tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			cd := rec.Header().Get("Content-Disposition")
+			if tt.filename == "" {
+				if cd != "" {
+					t.Errorf("Content-Disposition = %q, want none", cd)
+				}
+				return
+			}
+			disposition, params, err := mime.ParseMediaType(cd)
+			if err != nil || disposition != "attachment" || params["filename"] != tt.filename {
+				t.Errorf("Content-Disposition = %q, want attachment; filename %q", cd, tt.filename)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, httptest.NewRequest(http.MethodGet, "/api/tts?text=%E0%A4%B0%E0%A4%BE%E0%A4%AE&lang=deva&download=1", nil))
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+		t.Errorf("GET download: Content-Disposition = %q", cd)
+	}
+}
+
+func TestOpenAIRequest(t *testing.T) {
+	var got map[string]any
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/audio/speech" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte("ID3 audio"))
+	}))
+	defer api.Close()
+	t.Setenv("TTS_PROVIDER", "openai")
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_BASE_URL", api.URL)
+	t.Setenv("OPENAI_TTS_VOICE", "nova")
+	withCache(t, newAudioCache(0, 0))
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"ॐ नमः शिवाय","lang":"deva","rate":1.5}`))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "ID3 audio" {
+		t.Fatalf("status %d body %q", rec.Code, rec.Body.String())
+	}
+	if got["voice"] != "nova" || got["model"] != "tts-1" || got["response_format"] != "mp3" || got["speed"] != 1.5 {
+		t.Errorf("request = %v", got)
+	}
+	if v := rec.Header().Get("X-TTS-Voice"); v != "nova" {
+		t.Errorf("X-TTS-Voice = %q", v)
+	}
+}
+
+func TestEspeakVoiceVariant(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+
+	// A stand-in for espeak-ng that records the voice it was given.
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "espeak-ng")
+	body := "#!/bin/sh\necho \"$2\" > " + argsFile + "\nprintf RIFF\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := espeakBin
+	espeakBin = script
+	t.Cleanup(func() { espeakBin = orig })
+
+	tests := []struct {
+		body, voice string
+		status      int
+	}{
+		{`{"text":"नमः","lang":"deva"}`, "hi", http.StatusOK},
+		{`{"text":"नमः","lang":"deva","gender":"Female"}`, "hi+f3", http.StatusOK},
+		{`{"text":"नमः","lang":"knda","voiceVariant":"+whisper"}`, "kn+whisper", http.StatusOK},
+		{`{"text":"नमः","lang":"deva","voiceVariant":"f9"}`, "", http.StatusBadRequest},
+		{`{"text":"नमः","lang":"deva","gender":"robot"}`, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		os.Remove(argsFile)
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(tt.body))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.body, rec.Code, tt.status)
+			continue
+		}
+		if tt.voice == "" {
+			continue
+		}
+		got, _ := os.ReadFile(argsFile)
+		if v := strings.TrimSpace(string(got)); v != tt.voice {
+			t.Errorf("%s: espeak -v %q, want %q", tt.body, v, tt.voice)
+		}
+	}
+}
+
+func TestGranularityRates(t *testing.T) {
+	for provider := range providers {
+		if provider == "espeak" {
+			continue
+		}
+		lim := providerProsody[provider]
+		for g, want := range map[string]float64{"verse": 140.0 / 180, "line": 160.0 / 180, "word": 1, "": 1} {
+			if got := resolveProsody(provider, ttsRequest{Granularity: g}).Rate; got != clamp(want, lim.minRate, lim.maxRate) {
+				t.Errorf("%s %q: rate %v, want %v", provider, g, got, want)
+			}
+		}
+	}
+	if got := macRate(resolveProsody("mac", ttsRequest{Granularity: "verse"})); got != 140 {
+		t.Errorf("mac verse -r %d, want 140", got)
+	}
+
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "espeak-ng")
+	body := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsFile + "\nprintf RIFF\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := espeakBin
+	espeakBin = script
+	t.Cleanup(func() { espeakBin = orig })
+	espeakArgs := func(granularity string) string {
+		os.Remove(argsFile)
+		handleTTS(httptest.NewRecorder(), newTTSRequest(`{"text":"राम","lang":"deva","granularity":"`+granularity+`"}`))
+		got, _ := os.ReadFile(argsFile)
+		return string(got)
+	}
+	// espeak keeps its own speeds: 140 wpm for words, 160 for lines and
+	// its natural 175 for verses.
+	for g, want := range map[string]string{"word": "-s\n140\n", "line": "-s\n160\n"} {
+		if got := espeakArgs(g); !strings.Contains(got, want) {
+			t.Errorf("espeak %s args %q, want %q", g, got, want)
+		}
+	}
+	if got := espeakArgs("verse"); strings.Contains(got, "-s\n") {
+		t.Errorf("espeak verse args %q, want the default speed", got)
+	}
+
+	t.Setenv("TTS_GRANULARITY_RATES", "verse=0.5, word=1.2")
+	for provider, want := range map[string]float64{"sarvam": 0.5, "polly": 0.5, "azure": 0.5, "openai": 0.5, "piper": 0.5, "elevenlabs": 0.7} {
+		if got := resolveProsody(provider, ttsRequest{Granularity: "verse"}).Rate; got != want {
+			t.Errorf("%s verse: rate %v, want %v", provider, got, want)
+		}
+	}
+	if got := resolveProsody("openai", ttsRequest{Granularity: "word", Rate: 2}).Rate; got != 2.4 {
+		t.Errorf("openai word at rate 2: %v, want 2.4", got)
+	}
+	if got := macRate(resolveProsody("mac", ttsRequest{Granularity: "line"})); got != 160 {
+		t.Errorf("mac line -r %d, want the default 160", got)
+	}
+
+	// A configured rate applies to espeak too; the rest keep its speeds.
+	if got := espeakArgs("verse"); !strings.Contains(got, "-s\n88\n") {
+		t.Errorf("espeak verse args %q, want -s 88", got)
+	}
+	if got := espeakArgs("line"); !strings.Contains(got, "-s\n160\n") {
+		t.Errorf("espeak line args %q, want -s 160", got)
+	}
+
+	t.Setenv("TTS_GRANULARITY_RATES", "chorus=2")
+	if _, err := parseGranularityRates("chorus=2"); err == nil {
+		t.Error("unknown granularity accepted")
+	}
+	if got := granularityRate("openai", "verse"); got != 140.0/180 {
+		t.Errorf("invalid setting: verse rate %v, want the default", got)
+	}
+
+	// Once resolved at startup, the setting no longer follows the
+	// environment.
+	t.Setenv("TTS_GRANULARITY_RATES", "verse=0.6")
+	resolveGranularityRates()
+	t.Cleanup(func() { granularityRates = nil })
+	t.Setenv("TTS_GRANULARITY_RATES", "verse=0.9")
+	if got := granularityRate("openai", "verse"); got != 0.6 {
+		t.Errorf("resolved verse rate %v, want 0.6", got)
+	}
+}
+
+func TestEspeakSpawnRetry(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	t.Setenv("TTS_ESPEAK_SPAWN_RETRIES", "2")
+	withCache(t, newAudioCache(0, 0))
+	script := filepath.Join(t.TempDir(), "espeak-ng")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nprintf RIFF\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := espeakBin
+	espeakBin = script
+	t.Cleanup(func() { espeakBin = orig })
+
+	var attempts int
+	var failures int
+	var failWith error
+	startCmd = func(cmd *exec.Cmd) error {
+		attempts++
+		if attempts <= failures {
+			return failWith
+		}
+		return cmd.Start()
+	}
+	t.Cleanup(func() { startCmd = (*exec.Cmd).Start })
+
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		status   int
+		attempts int
+	}{
+		{"recovers", 2, &os.SyscallError{Syscall: "fork", Err: syscall.EAGAIN}, http.StatusOK, 3},
+		{"gives up", 3, &os.SyscallError{Syscall: "fork", Err: syscall.ENOMEM}, http.StatusInternalServerError, 3},
+		{"not transient", 1, exec.ErrNotFound, http.StatusInternalServerError, 1},
+	}
+	for _, tt := range tests {
+		attempts, failures, failWith = 0, tt.failures, tt.err
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(`{"text":"राम","lang":"deva"}`))
+		if rec.Code != tt.status || attempts != tt.attempts {
+			t.Errorf("%s: status %d after %d attempts, want %d after %d", tt.name, rec.Code, attempts, tt.status, tt.attempts)
+		}
+	}
+}
+
+func TestEspeakBuffered(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+
+	// Half a second of 16-bit mono silence at espeak's rate.
+	audio := pcmToWAV(make([]byte, espeakSampleRate), espeakSampleRate, 1)
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(audio)
+		return err
+	})
+
+	for _, buffered := range []bool{false, true} {
+		t.Setenv("TTS_ESPEAK_BUFFER", strconv.FormatBool(buffered))
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(`{"text":"नमः","lang":"deva"}`))
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), audio) {
+			t.Fatalf("buffered=%v: status %d, %d bytes", buffered, rec.Code, rec.Body.Len())
+		}
+		length, duration := rec.Header().Get("Content-Length"), rec.Header().Get("X-Audio-Duration-Ms")
+		if buffered && (length != strconv.Itoa(len(audio)) || duration != "500") {
+			t.Errorf("buffered: Content-Length %q, duration %q", length, duration)
+		}
+		if !buffered && length != "" {
+			t.Errorf("streamed: Content-Length %q", length)
+		}
+	}
+}
+
+func TestElevenLabsRequest(t *testing.T) {
+	var calls atomic.Int32
+	var got struct {
+		Text          string         `json:"text"`
+		ModelID       string         `json:"model_id"`
+		VoiceSettings map[string]any `json:"voice_settings"`
+	}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		if r.URL.Path != "/text-to-speech/voice-1/stream" || r.URL.Query().Get("output_format") != "mp3_44100_128" || r.Header.Get("xi-api-key") != "xi-test" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte("ID3 audio"))
+	}))
+	defer api.Close()
+	t.Setenv("TTS_PROVIDER", "elevenlabs")
+	t.Setenv("ELEVENLABS_API_KEY", "xi-test")
+	t.Setenv("ELEVENLABS_BASE_URL", api.URL)
+	t.Setenv("ELEVENLABS_VOICE_ID", "voice-1")
+	t.Setenv("ELEVENLABS_STABILITY", "0.4")
+	withCache(t, newAudioCache(0, 0))
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"ॐ नमः शिवाय","lang":"deva","rate":1.1}`))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "ID3 audio" || calls.Load() != 2 {
+		t.Fatalf("status %d body %q after %d calls", rec.Code, rec.Body.String(), calls.Load())
+	}
+	if got.Text != "ॐ नमः शिवाय" || got.ModelID != "eleven_multilingual_v2" || got.VoiceSettings["stability"] != 0.4 || got.VoiceSettings["speed"] != 1.1 {
+		t.Errorf("request = %+v", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// mbrolaEnabled reports whether TTS_ESPEAK_MBROLA asks espeak to use mbrola
+// diphone voices, which sound far better than its formant voices.
+func mbrolaEnabled() bool {
+	return envBool("TTS_ESPEAK_MBROLA", false)
+}
+
+// mbrolaVoice is the espeak-ng mbrola voice for lang: TTS_ESPEAK_MBROLA_VOICE,
+// else "mb-<voice>1" for the language's standard voice (mb-hi1 for Hindi).
+func mbrolaVoice(lang string) string {
+	if v := os.Getenv("TTS_ESPEAK_MBROLA_VOICE"); v != "" {
+		return v
+	}
+	return "mb-" + espeakVoice(lang) + "1"
+}
+
+// mbrolaAvailable reports whether the mbrola binary and the diphone database
+// for voice are installed. Databases live in TTS_MBROLA_DIR (default
+// /usr/share/mbrola), either as <name>/<name> or directly as <name>.
+func mbrolaAvailable(voice string) bool {
+	if _, err := exec.LookPath("mbrola"); err != nil {
+		return false
+	}
+	name := strings.TrimPrefix(voice, "mb-")
+	dir := envString("TTS_MBROLA_DIR", "/usr/share/mbrola")
+	for _, path := range []string{filepath.Join(dir, name, name), filepath.Join(dir, name)} {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
+
+// espeakVoiceFor picks the espeak voice for lang: the voice config's
+// mapping, TTS_VOICE, then the mbrola voice when enabled and installed, and
+// finally the built-in voice. mbrolaMissing reports that the mbrola voice
+// was wanted but isn't installed, so callers can log the downgrade.
+func espeakVoiceFor(lang string) (voice string, mbrolaMissing bool) {
+	if v := firstNonEmpty(configuredVoice(lang, "espeak").Voice, os.Getenv("TTS_VOICE")); v != "" {
+		return v, false
+	}
+	if mbrolaEnabled() {
+		if mb := mbrolaVoice(lang); mbrolaAvailable(mb) {
+			return mb, false
+		}
+		return espeakVoice(lang), true
+	}
+	return espeakVoice(lang), false
+}
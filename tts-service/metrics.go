@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ttsRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tts_requests_total",
+		Help: "TTS requests by provider and HTTP status.",
+	}, []string{"provider", "status"})
+
+	ttsSynthesisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tts_synthesis_duration_seconds",
+		Help:    "Time spent in a provider synthesizing one piece of text.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"provider"})
+
+	ttsSynthesisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tts_synthesis_errors_total",
+		Help: "Synthesis calls that returned an error, including failures mid-stream.",
+	}, []string{"provider"})
+
+	ttsProviderRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tts_provider_retries_total",
+		Help: "Provider API calls retried after a 429 or 5xx response.",
+	}, []string{"provider", "status"})
+
+	ttsPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tts_panics_total",
+		Help: "Handler panics recovered and answered with a 500.",
+	})
+
+	ttsSynthesisInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tts_synthesis_in_flight",
+		Help: "Synthesis operations currently running.",
+	})
+
+	ttsRequestsInFlight = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "tts_http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	}, func() float64 { return float64(inFlightRequests.Load()) })
+
+	ttsRequestsShed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tts_requests_shed_total",
+		Help: "Requests answered with 503 because TTS_MAX_INFLIGHT was reached.",
+	})
+
+	ttsBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tts_circuit_breaker_state",
+		Help: "Provider circuit breaker state: 0 closed, 1 open, 2 half-open.",
+	}, []string{"provider"})
+
+	ttsProviderQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tts_provider_queue_wait_seconds",
+		Help:    "Time provider calls waited for TTS_MAX_RPS_<PROVIDER> and TTS_MAX_CPS_<PROVIDER> quota.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"provider"})
+)
+
+func init() {
+	prometheus.MustRegister(ttsRequests, ttsSynthesisDuration, ttsSynthesisErrors, ttsProviderRetries, ttsPanics, ttsSynthesisInFlight, ttsRequestsInFlight, ttsRequestsShed, ttsBreakerState, ttsProviderQueueWait)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// inFlightRequests counts requests currently being served.
+	inFlightRequests atomic.Int64
+	// activeRequests lets shutdown wait for handlers to finish cleaning up.
+	activeRequests sync.WaitGroup
+)
+
+// maxInFlight is TTS_MAX_INFLIGHT, the most requests served at once.
+func maxInFlight() int64 {
+	return envInt64("TTS_MAX_INFLIGHT", 0)
+}
+
+// trackRequests counts in-flight requests for shutdown reporting.
+func trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		activeRequests.Add(1)
+		inFlightRequests.Add(1)
+		defer func() {
+			inFlightRequests.Add(-1)
+			activeRequests.Done()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shedLoad answers 503 with Retry-After, before the body is read, while
+// more than limit requests (TTS_MAX_INFLIGHT; 0 disables shedding) are
+// being served, so a flood can't exhaust memory buffering bodies and audio.
+// Probes and scrapes are never shed. It runs inside trackRequests, which has
+// already counted the request.
+func shedLoad(limit int64, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+		default:
+			if inFlightRequests.Load() > limit {
+				ttsRequestsShed.Inc()
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, "overloaded", "server is at capacity, retry shortly")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanics turns a panicking handler into a logged 500 instead of a
+// dropped connection. If the response was already started it can only be
+// cut short.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v) // deliberate abort; let net/http handle it quietly
+			}
+			ttsPanics.Inc()
+			logFrom(r.Context()).Error("handler panic", "panic", v, "path", r.URL.Path, "stack", string(debug.Stack()))
+			if sw.status == 0 {
+				writeError(sw, http.StatusInternalServerError, "internal_error", "internal error")
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// waitTimeout waits for wg, giving up after d. It reports whether wg finished.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	panicky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++ // nil map write
+	})
+	before := testutil.ToFloat64(ttsPanics)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tts", nil)
+	req.Header.Set("X-Request-Id", "req-42")
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-Id", "req-42")
+	recoverPanics(panicky).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %v", rec.Body.String(), err)
+	}
+	if body.Error.Code != "internal_error" || body.Error.RequestID != "req-42" {
+		t.Fatalf("error %+v", body.Error)
+	}
+	if got := testutil.ToFloat64(ttsPanics) - before; got != 1 {
+		t.Fatalf("tts_panics_total rose by %v, want 1", got)
+	}
+}
+
+func TestCompressJSONButNotAudio(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	withCache(t, newAudioCache(0, 0))
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(pcmToWAV(make([]byte, 1000), 8000, 1))
+		return err
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/voices", handleVoices)
+	mux.HandleFunc("/api/tts", handleTTS)
+	srv := compress(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/voices", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("voices: Content-Encoding %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var voices voicesResponse
+	if err := json.NewDecoder(zr).Decode(&voices); err != nil {
+		t.Fatalf("decoding gzipped voices: %v", err)
+	}
+	if !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+		t.Errorf("Vary %q lacks Accept-Encoding", rec.Header().Get("Vary"))
+	}
+
+	req = newTTSRequest(`{"text":"नमः","lang":"deva","format":"wav"}`)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("tts status %d", rec.Code)
+	}
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("wav: Content-Encoding %q, want none", enc)
+	}
+	if _, err := parseWAV(rec.Body.Bytes()); err != nil {
+		t.Errorf("wav body: %v", err)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	var logs bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	var seen string
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("X-Request-Id")
+		logFrom(r.Context()).Info("inside")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	id := rec.Header().Get("X-Request-Id")
+	if len(id) != 36 || id[14] != '4' || seen != id {
+		t.Fatalf("response id %q, handler saw %q", id, seen)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if !strings.Contains(line, `"request_id":"`+id+`"`) {
+			t.Errorf("log line without request id: %s", line)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("X-Request-Id", "client-7")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-Id"); got != "client-7" || seen != "client-7" {
+		t.Errorf("client id: response %q, handler saw %q", got, seen)
+	}
+}
+
+func TestCombinedAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	t.Setenv("TTS_ACCESS_LOG_FORMAT", "combined")
+	t.Setenv("TTS_ACCESS_LOG", path)
+	if err := openAccessLog(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accessLog.w = nil })
+
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/tts?lang=deva", nil)
+	req.RemoteAddr = "203.0.113.9:51234"
+	req.Header.Set("Referer", "https://example.org/stotra")
+	req.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(data)
+	prefix, rest, ok := strings.Cut(line, " [")
+	_, rest, _ = strings.Cut(rest, "] ")
+	if !ok || prefix != "203.0.113.9 - -" {
+		t.Fatalf("line = %q", line)
+	}
+	if want := `"POST /api/tts?lang=deva HTTP/1.1" 201 5 "https://example.org/stotra" "curl/8.0 \"quoted\""` + "\n"; rest != want {
+		t.Errorf("line ends %q, want %q", rest, want)
+	}
+	if strings.Contains(logs.String(), `"msg":"request"`) {
+		t.Errorf("combined format also logged the slog request line: %s", logs.String())
+	}
+}
+
+func TestOptionsAdvertisesCapabilities(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	h := cors(corsPolicy{}, http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/tts", nil)
+	req.Header.Set("Origin", "https://example.org")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Methods") != corsAllowMethods {
+		t.Fatalf("status %d, headers %v", rec.Code, rec.Header())
+	}
+	var caps capabilitiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &caps); err != nil {
+		t.Fatal(err)
+	}
+	if caps.Provider != "espeak" || len(caps.Providers) != 1 || caps.NativeFormat != "wav" ||
+		len(caps.Formats) != len(supportedFormats) || caps.Languages[0] != "auto" || !caps.Features["batch"] || caps.Features["providerOverride"] {
+		t.Errorf("capabilities = %+v", caps)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/api/voices", nil))
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("other preflight: status %d, body %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestShedLoad(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tts" {
+			close(started)
+			<-release
+		}
+	})
+	h := trackRequests(shedLoad(1, slow))
+	before := testutil.ToFloat64(ttsRequestsShed)
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/tts", nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/voices", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over the limit: status %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if got := testutil.ToFloat64(ttsRequestsInFlight); got != 1 {
+		t.Errorf("in-flight gauge %v, want 1", got)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("readiness probe shed: status %d", rec.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("first request: status %d", code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/voices", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("under the limit again: status %d", rec.Code)
+	}
+	if got := testutil.ToFloat64(ttsRequestsShed) - before; got != 1 {
+		t.Errorf("tts_requests_shed_total rose by %v, want 1", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// acceptsMultipart reports whether the client asked for multipart/mixed.
+func acceptsMultipart(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "multipart/mixed")
+}
+
+// writeWordParts answers a word-granularity request sent with
+// "Accept: multipart/mixed" with one part per word, so players can start and
+// stop each word precisely instead of seeking within one clip. Syllable
+// granularity gets one part per akshara the same way.
+//
+// The response is "Content-Type: multipart/mixed; boundary=<b>". Clients
+// read <b> from that header (it is derived from the request, so identical
+// requests get byte-identical responses) and split the body on "--<b>" lines
+// as in RFC 2046; the final delimiter is "--<b>--". Each part carries:
+//
+//	Content-Type: audio/wav (or the requested format)
+//	Content-Disposition: inline; name*=utf-8''%E0%A4%B0%E0%A4%BE%E0%A4%AE
+//	X-Word-Index: 0
+//	X-Audio-Duration-Ms: 412
+//
+// The word is in the Content-Disposition name parameter, RFC 2231-encoded
+// when it isn't ASCII. In a browser, new Response(body, {headers}).formData()
+// doesn't accept multipart/mixed, so split the ArrayBuffer on the boundary
+// bytes instead.
+//
+// Every word is synthesized (or taken from the cache) before anything is
+// written, so a failure is still reported as a normal error response.
+func writeWordParts(w http.ResponseWriter, r *http.Request, job *ttsJob) {
+	spoken := job.req.Text
+	if job.req.SSML {
+		spoken = ssmlToText(spoken, nil)
+	}
+	words := strings.Fields(spoken)
+	if job.req.Granularity == "syllable" {
+		words = job.chunks
+	}
+	entries := make([]*cachedAudio, len(words))
+	for i, word := range words {
+		item := job.req
+		item.Text, item.SSML, item.Transliterate = word, false, ""
+		wordJob, perr := prepareTTS(item, job.provider)
+		if perr != nil {
+			writeAPIError(w, perr.status, perr.apiError)
+			return
+		}
+		entry, err := synthesizeJob(r.Context(), wordJob)
+		if serr := synthesisError(r.Context(), job.provider, err); serr != nil {
+			var open *circuitOpenError
+			switch {
+			case errors.As(err, &open):
+				w.Header().Set("Retry-After", strconv.Itoa(open.retryAfterSeconds()))
+			case serr.status == http.StatusServiceUnavailable:
+				w.Header().Set("Retry-After", "1")
+			}
+			writeAPIError(w, serr.status, serr.apiError)
+			return
+		}
+		entries[i] = entry
+	}
+
+	extendWriteDeadline(w, 0)
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary("tts-" + job.key[:32]); err != nil {
+		writeError(w, http.StatusInternalServerError, "synthesis_failed", "tts error")
+		return
+	}
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	for i, entry := range entries {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", entry.contentType)
+		h.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"name": words[i]}))
+		h.Set("X-Word-Index", strconv.Itoa(i))
+		if d, ok := audioDuration(entry.data, entry.contentType); ok {
+			h.Set("X-Audio-Duration-Ms", strconv.FormatInt(d.Milliseconds(), 10))
+		}
+		part, err := mw.CreatePart(h)
+		if err == nil {
+			_, err = part.Write(entry.data)
+		}
+		if err != nil {
+			logFrom(r.Context()).Warn("multipart write error", "err", err)
+			return
+		}
+	}
+	mw.Close()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// hindiNumbers spells 0–99; Hindi compounds below a hundred are irregular,
+// so each has its own word.
+var hindiNumbers = [100]string{
+	"शून्य", "एक", "दो", "तीन", "चार", "पाँच", "छह", "सात", "आठ", "नौ",
+	"दस", "ग्यारह", "बारह", "तेरह", "चौदह", "पंद्रह", "सोलह", "सत्रह", "अठारह", "उन्नीस",
+	"बीस", "इक्कीस", "बाईस", "तेईस", "चौबीस", "पच्चीस", "छब्बीस", "सत्ताईस", "अट्ठाईस", "उनतीस",
+	"तीस", "इकतीस", "बत्तीस", "तैंतीस", "चौंतीस", "पैंतीस", "छत्तीस", "सैंतीस", "अड़तीस", "उनतालीस",
+	"चालीस", "इकतालीस", "बयालीस", "तैंतालीस", "चवालीस", "पैंतालीस", "छियालीस", "सैंतालीस", "अड़तालीस", "उनचास",
+	"पचास", "इक्यावन", "बावन", "तिरेपन", "चौवन", "पचपन", "छप्पन", "सत्तावन", "अट्ठावन", "उनसठ",
+	"साठ", "इकसठ", "बासठ", "तिरेसठ", "चौंसठ", "पैंसठ", "छियासठ", "सड़सठ", "अड़सठ", "उनहत्तर",
+	"सत्तर", "इकहत्तर", "बहत्तर", "तिहत्तर", "चौहत्तर", "पचहत्तर", "छिहत्तर", "सतहत्तर", "अठहत्तर", "उनासी",
+	"अस्सी", "इक्यासी", "बयासी", "तिरासी", "चौरासी", "पचासी", "छियासी", "सत्तासी", "अट्ठासी", "नवासी",
+	"नब्बे", "इक्यानवे", "बानवे", "तिरानवे", "चौरानवे", "पचानवे", "छियानवे", "सत्तानवे", "अट्ठानवे", "निन्यानवे",
+}
+
+// numeralRun matches a run of digits, ASCII and Devanagari alike.
+var numeralRun = regexp.MustCompile(`[0-9०-९]+`)
+
+// expandNumbers spells out the numerals in Devanagari text so every provider
+// reads verse numbers the same way (TTS_EXPAND_NUMBERS, default on). Other
+// languages are left to the provider's own number handling.
+func expandNumbers(text, lang string) string {
+	if lang != "deva" || !envBool("TTS_EXPAND_NUMBERS", true) {
+		return text
+	}
+	expanded := numeralRun.ReplaceAllStringFunc(text, func(digits string) string {
+		return numberWords(digitValues(digits))
+	})
+	// Some words use nukta letters, which NFC keeps decomposed.
+	return norm.NFC.String(expanded)
+}
+
+// digitValues converts ASCII or Devanagari digits to their values.
+func digitValues(digits string) []int {
+	var vals []int
+	for _, r := range digits {
+		if r >= '०' && r <= '९' {
+			vals = append(vals, int(r-'०'))
+		} else {
+			vals = append(vals, int(r-'0'))
+		}
+	}
+	return vals
+}
+
+// numberWords spells the number with the given digits in Hindi. Numbers up
+// to 9999 are read as a whole; longer runs, and ones with leading zeros, are
+// read digit by digit.
+func numberWords(digits []int) string {
+	if len(digits) > 4 || (len(digits) > 1 && digits[0] == 0) {
+		words := make([]string, len(digits))
+		for i, d := range digits {
+			words[i] = hindiNumbers[d]
+		}
+		return strings.Join(words, " ")
+	}
+	n := 0
+	for _, d := range digits {
+		n = n*10 + d
+	}
+	if n < 100 {
+		return hindiNumbers[n]
+	}
+	var words []string
+	if n >= 1000 {
+		words = append(words, hindiNumbers[n/1000], "हज़ार")
+		n %= 1000
+	}
+	if n >= 100 {
+		words = append(words, hindiNumbers[n/100], "सौ")
+		n %= 100
+	}
+	if n > 0 {
+		words = append(words, hindiNumbers[n])
+	}
+	return strings.Join(words, " ")
+}
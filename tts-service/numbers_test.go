@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestExpandNumbers(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"॥ १ ॥", "॥ एक ॥"},
+		{"श्लोक 1.", "श्लोक एक."},
+		{"अध्याय १२ श्लोक 47", "अध्याय बारह श्लोक सैंतालीस"},
+		{"१०८ नामानि", "एक सौ आठ नामानि"},
+		{"2024", "दो हज़ार चौबीस"},
+		{"९९९९", "नौ हज़ार नौ सौ निन्यानवे"},
+		{"३८", "अड़तीस"},
+		{"0", "शून्य"},
+		{"०७", "शून्य सात"},
+		{"12345", "एक दो तीन चार पाँच"},
+		{"१2", "बारह"}, // scripts mixed within one number
+	}
+	for _, tt := range tests {
+		if got := expandNumbers(tt.in, "deva"); got != tt.want {
+			t.Errorf("expandNumbers(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if got := expandNumbers("verse 12", "en"); got != "verse 12" {
+		t.Errorf("non-Indic text changed to %q", got)
+	}
+	t.Setenv("TTS_EXPAND_NUMBERS", "false")
+	if got := expandNumbers("॥ १ ॥", "deva"); got != "॥ १ ॥" {
+		t.Errorf("expansion not disabled: %q", got)
+	}
+}
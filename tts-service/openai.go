@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// openAIVoiceNames are OpenAI's built-in voices. Each reads every language
+// the model knows, but they are tuned for English: Indic text, Sanskrit in
+// particular, is pronounced noticeably worse than by Sarvam, Polly or Azure,
+// and IAST is read as English.
+var openAIVoiceNames = []string{"alloy", "ash", "coral", "echo", "fable", "nova", "onyx", "sage", "shimmer"}
+
+// openAIFormats maps our formats to OpenAI response_format values; its opus
+// comes in an Ogg container like ours. ogg and webm are transcoded from MP3.
+var openAIFormats = map[string]string{"mp3": "mp3", "wav": "wav", "opus": "opus"}
+
+// openAIVoice returns the voice configured for lang, OPENAI_TTS_VOICE or
+// alloy.
+func openAIVoice(lang string) string {
+	return firstNonEmpty(configuredVoice(lang, "openai").Voice, os.Getenv("OPENAI_TTS_VOICE"), "alloy")
+}
+
+// openAIModel is OPENAI_TTS_MODEL: tts-1 (default, lower latency) or tts-1-hd.
+func openAIModel() string {
+	return envString("OPENAI_TTS_MODEL", "tts-1")
+}
+
+// synthesizeWithOpenAI uses OpenAI's audio/speech endpoint with
+// OPENAI_API_KEY, streaming the audio through as it arrives. The rate maps
+// to its speed parameter; it has no pitch or volume control.
+func synthesizeWithOpenAI(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("OPENAI_API_KEY not set")
+	}
+	if req.SSML {
+		// OpenAI reads no SSML; synthesize the spoken text only.
+		text = ssmlToText(text, func(time.Duration) string { return " " })
+	}
+
+	format := resolveFormat(req.Format, "mp3")
+	output, native := openAIFormats[format]
+	if !native {
+		output = "mp3"
+	}
+	voice := firstNonEmpty(req.voice, openAIVoice(req.Lang))
+	body := map[string]any{
+		"model":           openAIModel(),
+		"input":           text,
+		"voice":           voice,
+		"response_format": output,
+	}
+	if pros := resolveProsody("openai", req); pros.Rate != 1 {
+		body["speed"] = pros.Rate
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	reportVoice(ctx, voice, "", 0)
+
+	if err := waitProvider(ctx, "openai", text); err != nil {
+		return err
+	}
+	resp, err := doWithRetry(ctx, "openai", envInt("OPENAI_MAX_RETRIES", 3), func() (*http.Request, error) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, envString("OPENAI_BASE_URL", "https://api.openai.com/v1")+"/audio/speech", bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+apiKey)
+		return r, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		logFrom(ctx).Warn("tts http error", "status", resp.StatusCode, "body", strings.TrimSpace(string(msg)))
+		return voiceError("openai", resp.StatusCode, msg, voice, fmt.Errorf("openai tts status %d", resp.StatusCode))
+	}
+
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	if !native {
+		return transcode(ctx, w, resp.Body, format)
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return err
+	}
+	logFrom(ctx).Info("synthesized", "runes", len([]rune(text)), "lang", req.Lang, "voice", voice, "bytes", n)
+	return nil
+}
+
+// openAIVoices lists OpenAI's voices against every language we map, since
+// each voice reads them all.
+func openAIVoices() []voiceInfo {
+	langs := sarvamVoices()[0].Languages
+	voices := make([]voiceInfo, len(openAIVoiceNames))
+	for i, name := range openAIVoiceNames {
+		voices[i] = voiceInfo{Name: name, Languages: langs}
+	}
+	return voices
+}
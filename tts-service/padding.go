@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// clampSilencePad limits a requested silence pad to 0..TTS_MAX_SILENCE_PAD_MS
+// (default 5000).
+func clampSilencePad(ms int) int {
+	return max(0, min(ms, envInt("TTS_MAX_SILENCE_PAD_MS", 5000)))
+}
+
+// padded reports whether the request asks for lead or trail silence.
+func (r ttsRequest) padded() bool {
+	return r.LeadSilenceMs > 0 || r.TrailSilenceMs > 0
+}
+
+// padSilence adds leadMs and trailMs of silence around a rendered clip. WAV
+// gets zero samples in its own format spliced into the data chunk; other
+// formats are re-encoded by ffmpeg with the silence added.
+func padSilence(ctx context.Context, data []byte, contentType string, leadMs, trailMs int) ([]byte, error) {
+	lead := time.Duration(leadMs) * time.Millisecond
+	trail := time.Duration(trailMs) * time.Millisecond
+	if contentType == audioContentTypes["wav"] {
+		wav, err := parseWAV(data)
+		if err != nil {
+			return nil, err
+		}
+		padded := wav.silence(lead)
+		padded = append(padded, wav.data...)
+		wav.data = append(padded, wav.silence(trail)...)
+		return wav.bytes(), nil
+	}
+
+	format := formatForContentType(contentType)
+	if format == "" {
+		return nil, fmt.Errorf("can't pad %s", contentType)
+	}
+	filter := fmt.Sprintf("adelay=%d:all=1,apad=pad_dur=%dms", leadMs, trailMs)
+	var out bytes.Buffer
+	args := ffmpegArgs(format, filter, audioSampleRate(data, contentType), 0)
+	if err := runFFmpeg(ctx, &out, bytes.NewReader(data), args, format); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type wordPhonemes struct {
+	Word string `json:"word"`
+	IPA  string `json:"ipa"`
+}
+
+type phonemesResponse struct {
+	Lang  string         `json:"lang"`
+	Voice string         `json:"voice"`
+	IPA   string         `json:"ipa"`
+	Words []wordPhonemes `json:"words,omitempty"`
+}
+
+// handlePhonemes returns espeak-ng's IPA transcription of the text, using
+// the voice /api/tts would pick for espeak, so clients can show learners how
+// a verse is pronounced. ?words=true adds a per-word breakdown, transcribing
+// each word on its own.
+func handlePhonemes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	logger := logFrom(r.Context())
+
+	req, status, code, msg := decodeTTSRequest(w, r)
+	if status != 0 {
+		writeError(w, status, code, msg)
+		return
+	}
+	perWord := false
+	if v := r.URL.Query().Get("words"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", fmt.Sprintf("invalid words %q", v))
+			return
+		}
+		perWord = b
+	}
+
+	text := norm.NFC.String(req.Text)
+	if len([]rune(text)) == 0 {
+		writeError(w, http.StatusBadRequest, "text_required", "text is required")
+		return
+	}
+	if req.SSML || isSSML(text) {
+		writeError(w, http.StatusBadRequest, "invalid_ssml", "SSML input is not supported for phonemes")
+		return
+	}
+	text, perr := sanitizeInput(text, false, "text")
+	if perr != nil {
+		writeAPIError(w, perr.status, perr.apiError)
+		return
+	}
+	if maxText := envInt("TTS_MAX_TEXT", 2500); maxText > 0 && len([]rune(text)) > maxText {
+		writeAPIError(w, http.StatusBadRequest, apiError{Code: "text_too_long", Message: "text too long", MaxRunes: maxText})
+		return
+	}
+	if perr := validateLang(req.Lang); perr != nil {
+		writeAPIError(w, perr.status, perr.apiError)
+		return
+	}
+	if req.Lang == "auto" {
+		req.Lang = detectLang(text)
+	}
+	// Transcribe what espeak would actually be asked to say.
+	text = applyLexicon(expandNumbers(text, req.Lang), req.Lang, "espeak", false)
+
+	voice, _ := espeakVoiceFor(req.Lang)
+	ctx, cancel := context.WithTimeout(r.Context(), synthesisTimeout("espeak", 1))
+	defer cancel()
+	release, err := acquireSlot(ctx, "espeak")
+	if err != nil {
+		logger.Warn("tts busy", "err", err)
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "busy", "tts busy, retry later")
+		return
+	}
+	defer release()
+
+	resp := phonemesResponse{Lang: req.Lang, Voice: voice}
+	resp.IPA, err = espeakIPA(ctx, voice, text)
+	if err == nil && perWord {
+		for _, word := range strings.Fields(text) {
+			var ipa string
+			if ipa, err = espeakIPA(ctx, voice, word); err != nil {
+				break
+			}
+			resp.Words = append(resp.Words, wordPhonemes{Word: word, IPA: ipa})
+		}
+	}
+	if err != nil {
+		logger.Error("phonemes error", "err", err)
+		if errors.Is(timeoutError(ctx, err), errSynthesisTimeout) {
+			writeError(w, http.StatusGatewayTimeout, "synthesis_timeout", "phoneme transcription timed out")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "synthesis_failed", "phoneme transcription failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// espeakIPA runs espeak-ng quietly with --ipa and returns the transcription
+// on one line; espeak prints one line per clause.
+func espeakIPA(ctx context.Context, voice, text string) (string, error) {
+	args := []string{"-q", "--ipa"}
+	if voice != "" {
+		args = append(args, "-v", voice)
+	}
+	args = append(args, text)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, espeakBin, args...)
+	cmd.WaitDelay = time.Second
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("espeak --ipa: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Join(strings.Fields(stdout.String()), " "), nil
+}
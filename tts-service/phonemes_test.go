@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPhonemes(t *testing.T) {
+	t.Setenv("TTS_VOICE", "")
+	// A stand-in for espeak-ng that echoes its voice and text back as "IPA",
+	// split over two lines like espeak's per-clause output.
+	dir := t.TempDir()
+	script := filepath.Join(dir, "espeak-ng")
+	body := "#!/bin/sh\n[ \"$1 $2 $3\" = \"-q --ipa -v\" ] || exit 1\nprintf ' %s\\n[%s]\\n' \"$4\" \"$5\"\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := espeakBin
+	espeakBin = script
+	t.Cleanup(func() { espeakBin = orig })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/phonemes?words=true", strings.NewReader(`{"text":"राम नमः","lang":"deva"}`))
+	rec := httptest.NewRecorder()
+	handlePhonemes(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp phonemesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Voice != "hi" || resp.IPA != "hi [राम नमः]" {
+		t.Errorf("voice, ipa = %q, %q", resp.Voice, resp.IPA)
+	}
+	want := []wordPhonemes{{"राम", "hi [राम]"}, {"नमः", "hi [नमः]"}}
+	if len(resp.Words) != len(want) {
+		t.Fatalf("words = %+v, want %+v", resp.Words, want)
+	}
+	for i := range want {
+		if resp.Words[i] != want[i] {
+			t.Errorf("words[%d] = %+v, want %+v", i, resp.Words[i], want[i])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handlePhonemes(rec, httptest.NewRequest(http.MethodPost, "/api/phonemes", strings.NewReader(`{"text":"<speak>राम</speak>","lang":"deva"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("SSML status = %d, want 400", rec.Code)
+	}
+}
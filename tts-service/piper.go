@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// piperBin is the piper executable.
+var piperBin = "piper"
+
+// piperModel returns the ONNX voice model for lang: the voice config's
+// mapping, else the entry for lang in the PIPER_MODELS JSON map (e.g.
+// {"deva":"/models/hi_IN-pratham-medium.onnx"}), else PIPER_MODEL.
+func piperModel(lang string) string {
+	if m := configuredVoice(lang, "piper").Voice; m != "" {
+		return m
+	}
+	if raw := os.Getenv("PIPER_MODELS"); raw != "" {
+		var models map[string]string
+		if err := json.Unmarshal([]byte(raw), &models); err != nil {
+			slog.Warn("invalid PIPER_MODELS", "err", err)
+		} else if m := models[lang]; m != "" {
+			return m
+		}
+	}
+	return os.Getenv("PIPER_MODEL")
+}
+
+// piperSampleRate reads the sample rate from the model's .onnx.json config,
+// which piper expects next to the model.
+func piperSampleRate(model string) int {
+	var cfg struct {
+		Audio struct {
+			SampleRate int `json:"sample_rate"`
+		} `json:"audio"`
+	}
+	if b, err := os.ReadFile(model + ".json"); err == nil && json.Unmarshal(b, &cfg) == nil && cfg.Audio.SampleRate > 0 {
+		return cfg.Audio.SampleRate
+	}
+	return 22050
+}
+
+// synthesizeWithPiper runs the piper neural TTS with text on stdin and
+// streams its raw PCM output to the client behind a WAV header, like espeak.
+func synthesizeWithPiper(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+	model := piperModel(req.Lang)
+	if model == "" {
+		return fmt.Errorf("PIPER_MODEL not set")
+	}
+	if req.SSML {
+		// piper doesn't read SSML; keep the spoken text.
+		text = ssmlToText(text, func(time.Duration) string { return " " })
+	}
+
+	args := []string{"--model", model, "--output-raw"}
+	// length_scale stretches phoneme durations, so it is the inverse of rate.
+	if pros := resolveProsody("piper", req); pros.Rate != 1 {
+		args = append(args, "--length_scale", strconv.FormatFloat(1/pros.Rate, 'f', 3, 64))
+	}
+	logFrom(ctx).Info("synthesizing", "runes", len([]rune(text)), "model", filepath.Base(model))
+	reportVoice(ctx, filepath.Base(model), "", piperSampleRate(model))
+
+	cmd := exec.CommandContext(ctx, piperBin, args...)
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = strings.NewReader(text)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		logFrom(ctx).Error("piper start error", "err", err)
+		return err
+	}
+
+	wav := io.MultiReader(bytes.NewReader(streamingWAVHeader(piperSampleRate(model), 1)), stdout)
+	format := resolveFormat(req.Format, "wav")
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	var streamErr error
+	if format != "wav" {
+		if streamErr = transcode(ctx, w, wav, format); streamErr != nil {
+			logFrom(ctx).Error("piper transcode error", "err", streamErr)
+		}
+	} else if n, err := io.Copy(w, wav); err != nil {
+		logFrom(ctx).Warn("piper streaming error", "bytes", n, "err", err)
+		streamErr = err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		logFrom(ctx).Error("piper exited with error", "err", err)
+		return err
+	}
+	return streamErr
+}
+
+// piperVoices lists the configured piper models.
+func piperVoices() []voiceInfo {
+	byModel := map[string][]string{}
+	if m := os.Getenv("PIPER_MODEL"); m != "" {
+		byModel[m] = nil
+	}
+	var models map[string]string
+	if raw := os.Getenv("PIPER_MODELS"); raw != "" && json.Unmarshal([]byte(raw), &models) == nil {
+		for lang, m := range models {
+			byModel[m] = append(byModel[m], sarvamLangCode(lang))
+		}
+	}
+	voices := []voiceInfo{}
+	for m, langs := range byModel {
+		sort.Strings(langs)
+		voices = append(voices, voiceInfo{Name: strings.TrimSuffix(filepath.Base(m), ".onnx"), Languages: langs})
+	}
+	sort.Slice(voices, func(i, j int) bool { return voices[i].Name < voices[j].Name })
+	return voices
+}
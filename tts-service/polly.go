@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/polly"
+	"github.com/aws/aws-sdk-go-v2/service/polly/types"
+	"github.com/aws/smithy-go"
+)
+
+// pollyClient loads the AWS configuration once per process.
+var pollyClient = sync.OnceValues(newPollyClient)
+
+// newPollyClient builds a Polly client from the default credential chain
+// (environment, shared config, instance role). POLLY_ENDPOINT replaces the
+// regional endpoint, e.g. for a VPC endpoint or a test server.
+func newPollyClient() (*polly.Client, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("AWS_REGION not set")
+	}
+	return polly.NewFromConfig(cfg, func(o *polly.Options) {
+		if endpoint := os.Getenv("POLLY_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// pollyEngine returns POLLY_ENGINE ("standard" or "neural"), defaulting to
+// standard, which every Indian voice supports.
+func pollyEngine() types.Engine {
+	if strings.EqualFold(os.Getenv("POLLY_ENGINE"), "neural") {
+		return types.EngineNeural
+	}
+	return types.EngineStandard
+}
+
+// pollyVoiceID picks the Polly voice for one of our language codes unless
+// the voice config or POLLY_VOICE_ID overrides it. Polly has no voices for the other Indian
+// languages, so they are read by the Hindi voice.
+func pollyVoiceID(lang string, engine types.Engine) string {
+	if v := configuredVoice(lang, "polly").Voice; v != "" {
+		return v
+	}
+	if v := os.Getenv("POLLY_VOICE_ID"); v != "" {
+		return v
+	}
+	if engine == types.EngineNeural {
+		return "Kajal" // bilingual hi-IN/en-IN neural voice
+	}
+	if lang == "iast" {
+		return "Raveena" // Indian English
+	}
+	return "Aditi"
+}
+
+// synthesizeWithPolly uses Amazon Polly. Prosody is applied through an SSML
+// <prosody> element; neural voices ignore pitch. Polly produces MP3 and Ogg
+// Vorbis natively and WAV from its headerless PCM output; Opus is transcoded
+// from MP3 with ffmpeg.
+func synthesizeWithPolly(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+	client, err := pollyClient()
+	if err != nil {
+		return err
+	}
+
+	engine := pollyEngine()
+	voice := firstNonEmpty(req.voice, pollyVoiceID(req.Lang, engine))
+	format := resolveFormat(req.Format, "mp3")
+	input := &polly.SynthesizeSpeechInput{
+		Engine:       engine,
+		VoiceId:      types.VoiceId(voice),
+		OutputFormat: types.OutputFormatMp3,
+		TextType:     types.TextTypeSsml,
+		Text:         aws.String(pollySSML(text, req.SSML, resolveProsody("polly", req), engine)),
+		LanguageCode: types.LanguageCode(configuredVoice(req.Lang, "polly").LanguageCode),
+	}
+	pcmRate := pollyPCMSampleRate()
+	rate := 0
+	if format == "wav" {
+		rate = pcmRate
+	}
+	reportVoice(ctx, voice, string(input.LanguageCode), rate)
+	switch format {
+	case "ogg":
+		input.OutputFormat = types.OutputFormatOggVorbis
+	case "wav":
+		input.OutputFormat = types.OutputFormatPcm
+		input.SampleRate = aws.String(strconv.Itoa(pcmRate))
+	}
+
+	if err := waitProvider(ctx, "polly", text); err != nil {
+		return err
+	}
+	out, err := client.SynthesizeSpeech(ctx, input)
+	if err != nil {
+		// Unknown voice IDs fail validation of the VoiceId field; known
+		// ones may lack the engine.
+		var engineErr *types.EngineNotSupportedException
+		var apiErr smithy.APIError
+		if errors.As(err, &engineErr) || errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationException" &&
+			strings.Contains(apiErr.ErrorMessage(), "'voiceId'") {
+			return &voiceUnavailableError{voice: voice, err: err}
+		}
+		return err
+	}
+	defer out.AudioStream.Close()
+
+	w.Header().Set("Content-Type", audioContentTypes[format])
+	if format == "wav" {
+		pcm, err := io.ReadAll(out.AudioStream)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(pcmToWAV(pcm, pcmRate, 1))
+		return err
+	}
+	if format != "mp3" && format != "ogg" {
+		return transcode(ctx, w, out.AudioStream, format)
+	}
+	n, err := io.Copy(w, out.AudioStream)
+	if err != nil {
+		return err
+	}
+	logFrom(ctx).Info("synthesized", "runes", len([]rune(text)), "lang", req.Lang, "voice", voice, "engine", string(engine), "bytes", n)
+	return nil
+}
+
+// pollyPCMSampleRate is the rate Polly renders PCM at for WAV output
+// (POLLY_PCM_SAMPLE_RATE): 8000 or 16000, the only rates it offers for PCM.
+func pollyPCMSampleRate() int {
+	if rate := envInt("POLLY_PCM_SAMPLE_RATE", 16000); rate == 8000 || rate == 16000 {
+		return rate
+	}
+	slog.Warn("POLLY_PCM_SAMPLE_RATE must be 8000 or 16000, using 16000")
+	return 16000
+}
+
+// pollySSML wraps text (plain or an SSML document) in <speak><prosody>.
+func pollySSML(text string, isSSMLText bool, pros prosody, engine types.Engine) string {
+	var body string
+	if isSSMLText {
+		body = innerSpeak(text)
+	} else {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(text))
+		body = b.String()
+	}
+
+	var attrs []string
+	if pros.Rate != 1 {
+		attrs = append(attrs, fmt.Sprintf(`rate="%d%%"`, int(math.Round(pros.Rate*100))))
+	}
+	if pros.Pitch != 0 && engine != types.EngineNeural {
+		// Semitones to a relative frequency change.
+		pct := (math.Pow(2, pros.Pitch/12) - 1) * 100
+		attrs = append(attrs, fmt.Sprintf(`pitch="%+d%%"`, int(math.Round(pct))))
+	}
+	if pros.Volume != 0 {
+		attrs = append(attrs, `volume="`+signedDB(pros.Volume)+`"`)
+	}
+	if len(attrs) > 0 {
+		body = "<prosody " + strings.Join(attrs, " ") + ">" + body + "</prosody>"
+	}
+	return "<speak>" + body + "</speak>"
+}
+
+// innerSpeak returns the content of an SSML document's <speak> root.
+func innerSpeak(ssml string) string {
+	s := strings.TrimSpace(ssml)
+	if i := strings.Index(s, ">"); i >= 0 && strings.HasPrefix(s, "<speak") {
+		s = s[i+1:]
+	}
+	return strings.TrimSuffix(strings.TrimSpace(s), "</speak>")
+}
+
+func signedDB(db float64) string {
+	v := strconv.FormatFloat(db, 'f', 1, 64)
+	if db > 0 {
+		v = "+" + v
+	}
+	return v + "dB"
+}
+
+// pollyVoices lists the Polly voices available for the configured engine.
+func pollyVoices(ctx context.Context) ([]voiceInfo, error) {
+	client, err := pollyClient()
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.DescribeVoices(ctx, &polly.DescribeVoicesInput{Engine: pollyEngine()})
+	if err != nil {
+		return nil, err
+	}
+	voices := make([]voiceInfo, 0, len(out.Voices))
+	for _, v := range out.Voices {
+		info := voiceInfo{Name: string(v.Id), Languages: []string{string(v.LanguageCode)}}
+		for _, l := range v.AdditionalLanguageCodes {
+			info.Languages = append(info.Languages, string(l))
+		}
+		switch v.Gender {
+		case types.GenderMale:
+			info.Gender = "male"
+		case types.GenderFemale:
+			info.Gender = "female"
+		}
+		voices = append(voices, info)
+	}
+	return voices, nil
+}
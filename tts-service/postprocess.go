@@ -0,0 +1,25 @@
+package main
+
+import "context"
+
+// postProcessing reports whether any step runs on audio after synthesis.
+func postProcessing() bool {
+	return trimEnabled() || normalizeEnabled()
+}
+
+// postProcess trims silence from a rendered clip and then normalizes its
+// loudness, as configured. Normalizing last measures only the speech.
+func postProcess(ctx context.Context, entry *cachedAudio, sampleRate int) (*cachedAudio, error) {
+	var err error
+	if trimEnabled() {
+		if entry, err = trimEntry(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+	if normalizeEnabled() {
+		if entry, err = normalizeEntry(ctx, entry, sampleRate); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
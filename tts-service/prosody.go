@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// prosody is the effective speaking rate, pitch and volume for a request.
+// Rate is a multiplier of the provider's baseline speed, Pitch is in
+// semitones and Volume is a gain in dB.
+type prosody struct {
+	Rate   float64
+	Pitch  float64
+	Volume float64
+}
+
+// prosodyLimits bounds the prosody a provider can honor. Requested values
+// outside these ranges are clamped rather than rejected.
+type prosodyLimits struct {
+	minRate, maxRate     float64
+	minPitch, maxPitch   float64
+	minVolume, maxVolume float64
+}
+
+var providerProsody = map[string]prosodyLimits{
+	// espeak-ng: -s 80..450 wpm around a 175 wpm default, -p 0..99, -a 0..200.
+	"espeak": {minRate: 80.0 / 175, maxRate: 450.0 / 175, minPitch: -20, maxPitch: 20, minVolume: -40, maxVolume: 6},
+	// say has no pitch flag; volume is applied with ffmpeg.
+	"mac": {minRate: 0.25, maxRate: 4, minVolume: -40, maxVolume: 20},
+	// Sarvam: pace 0.3..3, pitch -0.75..0.75, loudness 0.3..3 (about -10.5..+9.5 dB).
+	"sarvam": {minRate: 0.3, maxRate: 3, minPitch: -20, maxPitch: 20, minVolume: -10.5, maxVolume: 9.5},
+	// Polly SSML prosody: rate 20%..200%, pitch -33%..+50% (standard voices only), volume in dB.
+	"polly": {minRate: 0.2, maxRate: 2, minPitch: -7, maxPitch: 7, minVolume: -20, maxVolume: 6},
+	// Azure SSML prosody: rate 0.5x..2x, pitch in semitones, volume as a relative percentage.
+	"azure": {minRate: 0.5, maxRate: 2, minPitch: -12, maxPitch: 12, minVolume: -20, maxVolume: 6},
+	// piper: --length_scale only; no pitch or volume control.
+	"piper": {minRate: 0.25, maxRate: 4},
+	// OpenAI: speed only; no pitch or volume control.
+	"openai": {minRate: 0.25, maxRate: 4},
+	// ElevenLabs: voice_settings.speed only.
+	"elevenlabs": {minRate: 0.7, maxRate: 1.2},
+	// fake: the tone follows whatever is asked, within reason.
+	"fake": {minRate: 0.25, maxRate: 4, minPitch: -12, maxPitch: 12, minVolume: -40, maxVolume: 6},
+}
+
+// defaultGranularityRates slow verses and lines down relative to words, as
+// the mac provider always read them (140, 160 and 180 wpm).
+var defaultGranularityRates = map[string]float64{
+	"verse": 140.0 / 180,
+	"line":  160.0 / 180,
+	"word":  1,
+}
+
+// providerGranularityRates replace the defaults for providers tuned on
+// their own. espeak keeps the speeds it was tuned to (syllables and words
+// 140, lines 160 and verses 175 wpm) since it is hard to follow when
+// slowed further.
+var providerGranularityRates = map[string]map[string]float64{
+	"espeak": {
+		"verse":    1,
+		"line":     160.0 / espeakBaseSpeed,
+		"word":     140.0 / espeakBaseSpeed,
+		"syllable": 140.0 / espeakBaseSpeed,
+	},
+}
+
+// granularityRates are the rates set by TTS_GRANULARITY_RATES, resolved at
+// startup. It is nil until then, and granularityRate reads the environment
+// on each call instead, so tests can change it.
+var granularityRates map[string]float64
+
+// parseGranularityRates reads TTS_GRANULARITY_RATES, a list like
+// "verse=0.8,line=0.9,word=1". The rates it names apply to every provider.
+func parseGranularityRates(s string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || f <= 0 || !validGranularity(name) || name == "" {
+			return nil, fmt.Errorf("invalid granularity rate %q", pair)
+		}
+		rates[name] = f
+	}
+	return rates, nil
+}
+
+// resolveGranularityRates sets granularityRates from
+// TTS_GRANULARITY_RATES, warning about and ignoring an invalid setting.
+func resolveGranularityRates() {
+	rates, err := parseGranularityRates(os.Getenv("TTS_GRANULARITY_RATES"))
+	if err != nil {
+		slog.Warn("ignoring invalid TTS_GRANULARITY_RATES, using the defaults", "err", err)
+		rates = map[string]float64{}
+	}
+	granularityRates = rates
+}
+
+// granularityRate is the speed factor for granularity with provider: the
+// TTS_GRANULARITY_RATES setting, else the provider's own default, else the
+// shared default, else 1.
+func granularityRate(provider, granularity string) float64 {
+	rates := granularityRates
+	if rates == nil {
+		rates, _ = parseGranularityRates(os.Getenv("TTS_GRANULARITY_RATES"))
+	}
+	if f, ok := rates[granularity]; ok {
+		return f
+	}
+	if f, ok := providerGranularityRates[provider][granularity]; ok {
+		return f
+	}
+	if f, ok := defaultGranularityRates[granularity]; ok {
+		return f
+	}
+	return 1
+}
+
+// resolveProsody clamps the request's rate, pitch and volume to what provider
+// supports. The rate is the request's (1 when unset) scaled by the
+// granularity's rate, so providers read verses and words at the same
+// relative pace unless tuned otherwise (see providerGranularityRates).
+func resolveProsody(provider string, req ttsRequest) prosody {
+	lim := providerProsody[provider]
+	rate := req.Rate
+	if rate == 0 {
+		rate = 1
+	}
+	rate *= granularityRate(provider, req.Granularity)
+	return prosody{
+		Rate:   clamp(rate, lim.minRate, lim.maxRate),
+		Pitch:  clamp(req.Pitch, lim.minPitch, lim.maxPitch),
+		Volume: clamp(req.Volume, lim.minVolume, lim.maxVolume),
+	}
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// gainToAmplitude converts a dB gain to a linear amplitude multiplier.
+func gainToAmplitude(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+func formatProsodyValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// Provider is a speech engine.
+type Provider interface {
+	// Synthesize renders text in full and returns the audio.
+	Synthesize(ctx context.Context, text string, req ttsRequest) ([]byte, audioMeta, error)
+}
+
+// StreamProvider is a Provider that can write audio to the client while it
+// is produced. Its StreamSynthesize sets Content-Type on w before the first
+// write.
+type StreamProvider interface {
+	Provider
+	StreamSynthesize(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error
+}
+
+// audioMeta describes audio returned by Synthesize: what the engine
+// reported through reportVoice along with the content type. LanguageCode
+// and SampleRate are empty when the engine doesn't report them.
+type audioMeta struct {
+	ContentType  string
+	Voice        string
+	LanguageCode string
+	SampleRate   int
+}
+
+// synthesizerFunc renders text as audio, writing the response to w. Each
+// engine is written as one and adapted to Provider with bufferedProvider or
+// streamingProvider.
+type synthesizerFunc func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error
+
+// bufferedProvider is an engine that only has the audio once it is done.
+type bufferedProvider synthesizerFunc
+
+func (p bufferedProvider) Synthesize(ctx context.Context, text string, req ttsRequest) ([]byte, audioMeta, error) {
+	buf := newResponseBuffer()
+	engineCtx, info := withSynthesisInfo(ctx)
+	err := p(engineCtx, buf, text, req)
+	if info.Voice != "" {
+		// Pass the voice on to the caller's info too.
+		reportVoice(ctx, info.Voice, info.LanguageCode, info.SampleRate)
+	}
+	if err != nil {
+		return nil, audioMeta{}, err
+	}
+	return buf.buf.Bytes(), audioMeta{
+		ContentType:  buf.header.Get("Content-Type"),
+		Voice:        info.Voice,
+		LanguageCode: info.LanguageCode,
+		SampleRate:   info.SampleRate,
+	}, nil
+}
+
+// streamingProvider is an engine that produces audio progressively.
+type streamingProvider synthesizerFunc
+
+func (p streamingProvider) Synthesize(ctx context.Context, text string, req ttsRequest) ([]byte, audioMeta, error) {
+	return bufferedProvider(p).Synthesize(ctx, text, req)
+}
+
+func (p streamingProvider) StreamSynthesize(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+	return p(ctx, w, text, req)
+}
+
+// providers maps provider names to their implementations.
+var providers = map[string]Provider{
+	"espeak":     streamingProvider(synthesizeWithEspeak),
+	"mac":        bufferedProvider(synthesizeWithMac),
+	"sarvam":     sarvamProvider(),
+	"polly":      bufferedProvider(synthesizeWithPolly),
+	"azure":      bufferedProvider(synthesizeWithAzure),
+	"piper":      streamingProvider(synthesizeWithPiper),
+	"openai":     streamingProvider(synthesizeWithOpenAI),
+	"elevenlabs": streamingProvider(synthesizeWithElevenLabs),
+	"fake":       bufferedProvider(synthesizeWithFake),
+}
+
+// isStreaming reports whether provider writes audio while it is produced.
+func isStreaming(provider string) bool {
+	_, ok := providers[provider].(StreamProvider)
+	return ok
+}
+
+// writeSynthesis renders text with p into w, streaming when p can and
+// otherwise writing the finished clip with its length.
+func writeSynthesis(ctx context.Context, p Provider, w http.ResponseWriter, text string, req ttsRequest) error {
+	if sp, ok := p.(StreamProvider); ok {
+		return sp.StreamSynthesize(ctx, w, text, req)
+	}
+	data, meta, err := p.Synthesize(ctx, text, req)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", meta.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err = w.Write(data)
+	return err
+}
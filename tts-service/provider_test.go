@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// stubBinary installs a shell script as *bin for the duration of the test.
+func stubBinary(t *testing.T, bin *string, body string) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), filepath.Base(*bin))
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := *bin
+	*bin = script
+	t.Cleanup(func() { *bin = orig })
+}
+
+func TestProviders(t *testing.T) {
+	tone, err := filepath.Abs("testdata/tone-500ms.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubBinary(t, &espeakBin, "cat "+tone+"\n")
+	stubBinary(t, &piperBin, "head -c 64 /dev/zero\n")
+	t.Setenv("PIPER_MODEL", filepath.Join(t.TempDir(), "voice.onnx"))
+	// say writes to the file after -o.
+	stubBinary(t, &sayBin, `while [ $# -gt 0 ]; do [ "$1" = -o ] && cp `+tone+` "$2"; shift; done`+"\n")
+
+	audio := bytes.Repeat([]byte{0}, 64)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/text-to-speech":
+			// Sarvam returns base64 audio in JSON.
+			json.NewEncoder(w).Encode(map[string][]string{"audios": {base64.StdEncoding.EncodeToString(audio)}})
+		case "/v1/speech":
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write(audio)
+		default:
+			// OpenAI and Azure answer in the requested container;
+			// ElevenLabs sends PCM for WAV.
+			w.Write(audio)
+		}
+	}))
+	defer api.Close()
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("OPENAI_BASE_URL", api.URL)
+	t.Setenv("ELEVENLABS_API_KEY", "test")
+	t.Setenv("ELEVENLABS_BASE_URL", api.URL)
+	t.Setenv("SARVAM_API_KEY", "test")
+	t.Setenv("SARVAM_BASE_URL", api.URL)
+	t.Setenv("AZURE_TTS_KEY", "test")
+	t.Setenv("AZURE_TTS_REGION", "centralindia")
+	t.Setenv("AZURE_TTS_BASE_URL", api.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "ap-south-1")
+	t.Setenv("POLLY_ENDPOINT", api.URL)
+	origPolly := pollyClient
+	pollyClient = sync.OnceValues(newPollyClient)
+	t.Cleanup(func() { pollyClient = origPolly })
+
+	tests := []struct {
+		provider    string
+		format      string
+		contentType string
+		streams     bool
+	}{
+		{"espeak", "wav", "audio/wav", true},
+		{"mac", "wav", "audio/wav", false},
+		{"sarvam", "mp3", "audio/mpeg", false},
+		{"polly", "mp3", "audio/mpeg", false},
+		{"azure", "mp3", "audio/mpeg", false},
+		{"piper", "wav", "audio/wav", true},
+		{"openai", "mp3", "audio/mpeg", true},
+		{"elevenlabs", "wav", "audio/wav", true},
+		{"fake", "wav", "audio/wav", false},
+	}
+	if len(tests) != len(providers) {
+		t.Fatalf("%d providers tested, want all %d", len(tests), len(providers))
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			p := providers[tt.provider]
+			req := ttsRequest{Text: "राम", Lang: "deva", Format: tt.format}
+			data, meta, err := p.Synthesize(context.Background(), req.Text, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.ContentType != tt.contentType || len(data) == 0 {
+				t.Errorf("Synthesize: %d bytes of %q, want %q audio", len(data), meta.ContentType, tt.contentType)
+			}
+			if meta.Voice == "" {
+				t.Error("Synthesize: no voice in the metadata")
+			}
+
+			sp, ok := p.(StreamProvider)
+			if ok != tt.streams {
+				t.Fatalf("StreamProvider = %v, want %v", ok, tt.streams)
+			}
+			if !ok {
+				return
+			}
+			rec := httptest.NewRecorder()
+			if err := sp.StreamSynthesize(context.Background(), rec, req.Text, req); err != nil {
+				t.Fatal(err)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType || !bytes.Equal(rec.Body.Bytes(), data) {
+				t.Errorf("StreamSynthesize: %d bytes of %q, want the %d bytes Synthesize returned", rec.Body.Len(), ct, len(data))
+			}
+		})
+	}
+
+	// Buffered providers report what they resolved in the metadata.
+	req := ttsRequest{Text: "राम", Lang: "deva", Format: "mp3"}
+	if _, meta, err := providers["azure"].Synthesize(context.Background(), req.Text, req); err != nil || meta.Voice != "hi-IN-MadhurNeural" || meta.LanguageCode != "hi-IN" {
+		t.Errorf("azure metadata %+v, err %v", meta, err)
+	}
+	req.Format = "wav"
+	if _, meta, err := providers["mac"].Synthesize(context.Background(), req.Text, req); err != nil || meta.SampleRate != 44100 {
+		t.Errorf("mac metadata %+v, err %v", meta, err)
+	}
+
+	// Buffered providers are written whole, with their length.
+	rec := httptest.NewRecorder()
+	req = ttsRequest{Text: "राम", Lang: "deva"}
+	if err := writeSynthesis(context.Background(), providers["fake"], rec, req.Text, req); err != nil {
+		t.Fatal(err)
+	}
+	if cl := rec.Header().Get("Content-Length"); cl == "" || cl != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length %q for %d bytes", cl, rec.Body.Len())
+	}
+
+	for name := range providers {
+		if want := name == "espeak" || name == "piper" || name == "openai" || name == "elevenlabs"; isStreaming(name) != want {
+			t.Errorf("isStreaming(%q) = %v, want %v", name, !want, want)
+		}
+	}
+}
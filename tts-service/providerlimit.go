@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/time/rate"
+)
+
+// providerLimiter smooths outbound calls to one provider so bursts stay
+// under its request and character quotas instead of earning 429s. Unlike
+// synthSlots, which bound local CPU, it queues calls to network providers.
+type providerLimiter struct {
+	requests *rate.Limiter // nil when unlimited
+	chars    *rate.Limiter // nil when unlimited
+}
+
+// providerLimiterFromEnv builds the limiter for provider from
+// TTS_MAX_RPS_<PROVIDER> (requests per second) and TTS_MAX_CPS_<PROVIDER>
+// (characters per second), e.g. TTS_MAX_RPS_SARVAM. It returns nil when
+// neither is set. Each allows a second's worth of burst.
+func providerLimiterFromEnv(provider string) *providerLimiter {
+	rps := envFloat("TTS_MAX_RPS_"+strings.ToUpper(provider), 0)
+	cps := envFloat("TTS_MAX_CPS_"+strings.ToUpper(provider), 0)
+	if rps <= 0 && cps <= 0 {
+		return nil
+	}
+	l := &providerLimiter{}
+	if rps > 0 {
+		l.requests = rate.NewLimiter(rate.Limit(rps), int(math.Ceil(rps)))
+	}
+	if cps > 0 {
+		l.chars = rate.NewLimiter(rate.Limit(cps), int(math.Ceil(cps)))
+	}
+	return l
+}
+
+// providerLimiters holds the configured limiters by provider, read once at
+// startup.
+var providerLimiters = make(map[string]*providerLimiter)
+
+func init() {
+	for name := range providers {
+		if l := providerLimiterFromEnv(name); l != nil {
+			providerLimiters[name] = l
+		}
+	}
+}
+
+// waitProvider blocks until provider's limiter admits a call sending text,
+// recording the wait in tts_provider_queue_wait_seconds. A wait that would
+// run past ctx's deadline fails at once with errSynthesisTimeout; a
+// cancelled ctx (the client went away) is returned as is. A text longer
+// than a second's characters waits for the whole bucket.
+func waitProvider(ctx context.Context, provider, text string) error {
+	l := providerLimiters[provider]
+	if l == nil {
+		return nil
+	}
+	start := time.Now()
+	defer func() {
+		ttsProviderQueueWait.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	}()
+	if l.requests != nil {
+		if err := l.requests.Wait(ctx); err != nil {
+			return quotaWaitError(ctx, provider+" request quota", err)
+		}
+	}
+	if l.chars != nil {
+		n := min(utf8.RuneCountInString(text), l.chars.Burst())
+		if err := l.chars.WaitN(ctx, n); err != nil {
+			return quotaWaitError(ctx, provider+" character quota", err)
+		}
+	}
+	return nil
+}
+
+// quotaWaitError reports a failed limiter wait. Running out of time, or
+// the limiter seeing that the wait would, is a synthesis timeout; any
+// other failure, such as cancellation, is returned unchanged.
+func quotaWaitError(ctx context.Context, quota string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	if _, ok := ctx.Deadline(); ok || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s: %v", errSynthesisTimeout, quota, err)
+	}
+	return err
+}
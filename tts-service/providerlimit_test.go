@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withProviderLimiter installs the limiter the environment configures for
+// provider until the test ends.
+func withProviderLimiter(t *testing.T, provider string) {
+	t.Helper()
+	prev, had := providerLimiters[provider]
+	providerLimiters[provider] = providerLimiterFromEnv(provider)
+	t.Cleanup(func() {
+		if had {
+			providerLimiters[provider] = prev
+		} else {
+			delete(providerLimiters, provider)
+		}
+	})
+}
+
+func TestProviderLimiterQueuesOverRPS(t *testing.T) {
+	calls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("ID3 audio"))
+	}))
+	defer api.Close()
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_BASE_URL", api.URL)
+	t.Setenv("TTS_MAX_RPS_OPENAI", "10")
+	withProviderLimiter(t, "openai")
+
+	call := func() time.Duration {
+		start := time.Now()
+		if err := synthesizeWithOpenAI(context.Background(), httptest.NewRecorder(), "नमः", ttsRequest{Lang: "deva"}); err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start)
+	}
+	// A second's burst goes straight through; the next call queues for
+	// the bucket to refill.
+	for i := 0; i < 10; i++ {
+		if d := call(); d > 50*time.Millisecond {
+			t.Fatalf("call %d waited %v inside the burst", i+1, d)
+		}
+	}
+	if d := call(); d < 50*time.Millisecond {
+		t.Errorf("call 11 waited %v, want about 100ms", d)
+	}
+	if calls != 11 {
+		t.Errorf("provider called %d times, want 11", calls)
+	}
+}
+
+func TestProviderLimiterRespectsDeadline(t *testing.T) {
+	calls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("ID3 audio"))
+	}))
+	defer api.Close()
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_BASE_URL", api.URL)
+	t.Setenv("TTS_MAX_CPS_OPENAI", "4")
+	withProviderLimiter(t, "openai")
+
+	if err := synthesizeWithOpenAI(context.Background(), httptest.NewRecorder(), "नमः", ttsRequest{Lang: "deva"}); err != nil {
+		t.Fatal(err)
+	}
+	// The characters just sent take most of a second to refill, longer
+	// than this deadline allows, so the call fails without waiting.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := synthesizeWithOpenAI(ctx, httptest.NewRecorder(), "नमः", ttsRequest{Lang: "deva"})
+	if !errors.Is(err, errSynthesisTimeout) {
+		t.Fatalf("err = %v, want errSynthesisTimeout", err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("failed after %v, want at once", d)
+	}
+	if calls != 1 {
+		t.Errorf("provider called %d times, want 1", calls)
+	}
+}
+
+func TestProviderLimiterCancel(t *testing.T) {
+	t.Setenv("TTS_MAX_RPS_OPENAI", "1")
+	withProviderLimiter(t, "openai")
+	if err := waitProvider(context.Background(), "openai", "नमः"); err != nil {
+		t.Fatal(err)
+	}
+	// A client that goes away while queued is not a timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err := waitProvider(ctx, "openai", "नमः")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, errSynthesisTimeout) {
+		t.Fatalf("err = %v, want context.Canceled and not a timeout", err)
+	}
+}
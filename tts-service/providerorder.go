@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// providerOrder is the provider preference resolved from TTS_PROVIDER at
+// startup. It is nil until then, and activeProvider resolves the
+// environment on each call instead, so tests can change it.
+var providerOrder []string
+
+// defaultProviderName is used when TTS_PROVIDER names nothing usable: mac
+// where the say command exists, espeak elsewhere.
+func defaultProviderName() string {
+	if isMacOS() {
+		return "mac"
+	}
+	return "espeak"
+}
+
+// parseProviderOrder resolves TTS_PROVIDER, a comma-separated list of
+// providers in order of preference ("azure,espeak"). Unknown names and
+// providers missing configuration or binaries are dropped, with a reason
+// for each in skipped. When none of the listed providers is available the
+// known ones are kept anyway, so requests report what is missing rather
+// than silently switching engines.
+func parseProviderOrder(value string) (order, skipped []string) {
+	var known []string
+	for _, p := range strings.Split(value, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" || slices.Contains(known, p) {
+			continue
+		}
+		if _, ok := providers[p]; !ok {
+			skipped = append(skipped, p+": unknown provider")
+			continue
+		}
+		known = append(known, p)
+		if missing := providerMissing(p); len(missing) > 0 {
+			skipped = append(skipped, p+": missing "+strings.Join(missing, ", "))
+			continue
+		}
+		order = append(order, p)
+	}
+	switch {
+	case len(order) > 0:
+		return order, skipped
+	case len(known) > 0:
+		return known, skipped
+	default:
+		return []string{defaultProviderName()}, skipped
+	}
+}
+
+// resolveProviderOrder sets providerOrder from TTS_PROVIDER and logs the
+// effective order.
+func resolveProviderOrder() {
+	order, skipped := parseProviderOrder(os.Getenv("TTS_PROVIDER"))
+	for _, s := range skipped {
+		slog.Warn("skipping provider from TTS_PROVIDER", "reason", s)
+	}
+	providerOrder = order
+	if slices.Contains(order, "fake") {
+		slog.Warn("the fake provider is configured; it returns test tones, not speech")
+	}
+	slog.Info("provider order resolved", "order", strings.Join(order, ","))
+}
+
+// activeProvider returns the preferred provider: the first available one
+// in TTS_PROVIDER, or the platform default. Requests may still pick another
+// with "provider" when TTS_ALLOW_PROVIDER_OVERRIDE is set.
+func activeProvider() string {
+	if len(providerOrder) > 0 {
+		return providerOrder[0]
+	}
+	order, _ := parseProviderOrder(os.Getenv("TTS_PROVIDER"))
+	return order[0]
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestProviderOrder(t *testing.T) {
+	t.Setenv("SARVAM_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("ELEVENLABS_API_KEY", "test")
+
+	tests := []struct {
+		value   string
+		want    []string
+		skipped int
+	}{
+		{"", []string{defaultProviderName()}, 0},
+		{"openai", []string{"openai"}, 0},
+		{" elevenlabs , openai,elevenlabs", []string{"elevenlabs", "openai"}, 0},
+		{"sarvam,google,openai", []string{"openai"}, 2},
+		// Nothing available: keep what was asked for so requests say why.
+		{"sarvam", []string{"sarvam"}, 1},
+		{"google", []string{defaultProviderName()}, 1},
+	}
+	for _, tt := range tests {
+		order, skipped := parseProviderOrder(tt.value)
+		if !slices.Equal(order, tt.want) || len(skipped) != tt.skipped {
+			t.Errorf("parseProviderOrder(%q) = %v, skipped %v; want %v with %d skipped", tt.value, order, skipped, tt.want, tt.skipped)
+		}
+	}
+
+	t.Setenv("TTS_PROVIDER", "sarvam,openai")
+	if got := activeProvider(); got != "openai" {
+		t.Errorf("activeProvider() = %q, want openai", got)
+	}
+	providerOrder = []string{"elevenlabs"}
+	t.Cleanup(func() { providerOrder = nil })
+	if got := activeProvider(); got != "elevenlabs" {
+		t.Errorf("activeProvider() with resolved order = %q, want elevenlabs", got)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type authKeyKey struct{}
+
+// withAuthKey returns a context carrying the ID of the token that
+// authenticated the request.
+func withAuthKey(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, authKeyKey{}, id)
+}
+
+// authKeyFrom returns the token ID carried by ctx, or "".
+func authKeyFrom(ctx context.Context) string {
+	id, _ := ctx.Value(authKeyKey{}).(string)
+	return id
+}
+
+// tokenID names a token in usage reports without revealing it: the first 12
+// hex digits of its SHA-256 (`printf %s "$TOKEN" | sha256sum | cut -c1-12`).
+func tokenID(sum [32]byte) string {
+	return hex.EncodeToString(sum[:6])
+}
+
+// keyUsage is what one token used in the current window.
+type keyUsage struct {
+	Requests int64 `json:"requests"`
+	Runes    int64 `json:"runes"` // characters of text synthesized
+}
+
+// usageTracker meters requests and synthesized characters per token over
+// fixed windows aligned to the Unix epoch, so the default 24h window resets
+// at midnight UTC. Limits of 0 only count.
+type usageTracker struct {
+	window      time.Duration
+	maxRequests int64
+	maxRunes    int64
+	path        string // where counts are persisted; "" keeps them in memory
+
+	mu    sync.Mutex
+	start time.Time
+	keys  map[string]*keyUsage
+}
+
+// usage is the tracker behind the API when TTS_AUTH_TOKENS is set; nil
+// otherwise.
+var usage *usageTracker
+
+func newUsageTracker(window time.Duration, maxRequests, maxRunes int64, path string) *usageTracker {
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	return &usageTracker{
+		window:      window,
+		maxRequests: maxRequests,
+		maxRunes:    maxRunes,
+		path:        path,
+		start:       time.Now().Truncate(window),
+		keys:        make(map[string]*keyUsage),
+	}
+}
+
+// usageTrackerFromEnv builds the tracker configured by TTS_QUOTA_WINDOW
+// (default 24h), TTS_QUOTA_REQUESTS and TTS_QUOTA_RUNES (per token per
+// window; 0 is unlimited) and TTS_USAGE_FILE, loading any counts saved
+// there for the current window.
+func usageTrackerFromEnv() *usageTracker {
+	u := newUsageTracker(envDuration("TTS_QUOTA_WINDOW", 24*time.Hour),
+		envInt64("TTS_QUOTA_REQUESTS", 0), envInt64("TTS_QUOTA_RUNES", 0), os.Getenv("TTS_USAGE_FILE"))
+	if err := u.load(); err != nil {
+		slog.Error("usage counts not loaded, starting from zero", "path", u.path, "err", err)
+	}
+	return u
+}
+
+// roll starts a new window, clearing the counts, once the current one has
+// passed. The caller holds u.mu.
+func (u *usageTracker) roll(now time.Time) {
+	if start := now.Truncate(u.window); start.After(u.start) {
+		u.start = start
+		u.keys = make(map[string]*keyUsage)
+	}
+}
+
+// admit counts a request for key, or returns how long until the window
+// resets when key has used up its quota.
+func (u *usageTracker) admit(key string) (time.Duration, bool) {
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(now)
+	k := u.keys[key]
+	if k == nil {
+		k = &keyUsage{}
+		u.keys[key] = k
+	}
+	if (u.maxRequests > 0 && k.Requests >= u.maxRequests) || (u.maxRunes > 0 && k.Runes >= u.maxRunes) {
+		return u.start.Add(u.window).Sub(now), false
+	}
+	k.Requests++
+	return 0, true
+}
+
+// addRunes counts n synthesized characters against key. A request admitted
+// under the limit is finished even if it goes over; the next one is refused.
+func (u *usageTracker) addRunes(key string, n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(time.Now())
+	if k := u.keys[key]; k != nil {
+		k.Runes += int64(n)
+	}
+}
+
+// recordRunes counts n synthesized characters against the request's token.
+func recordRunes(ctx context.Context, n int) {
+	if key := authKeyFrom(ctx); usage != nil && key != "" {
+		usage.addRunes(key, n)
+	}
+}
+
+// usageSnapshot is the persisted and reported form of the counts.
+type usageSnapshot struct {
+	WindowStart time.Time           `json:"windowStart"`
+	WindowEnd   time.Time           `json:"windowEnd"`
+	MaxRequests int64               `json:"maxRequests,omitempty"`
+	MaxRunes    int64               `json:"maxRunes,omitempty"`
+	Keys        map[string]keyUsage `json:"keys"`
+}
+
+func (u *usageTracker) snapshot() usageSnapshot {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(time.Now())
+	s := usageSnapshot{
+		WindowStart: u.start.UTC(),
+		WindowEnd:   u.start.Add(u.window).UTC(),
+		MaxRequests: u.maxRequests,
+		MaxRunes:    u.maxRunes,
+		Keys:        make(map[string]keyUsage, len(u.keys)),
+	}
+	for id, k := range u.keys {
+		s.Keys[id] = *k
+	}
+	return s
+}
+
+// load restores counts saved for the current window. Counts from an
+// earlier window, or a missing file, leave the tracker empty.
+func (u *usageTracker) load() error {
+	if u.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(u.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s usageSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !s.WindowStart.Equal(u.start) {
+		return nil
+	}
+	for id, k := range s.Keys {
+		k := k
+		u.keys[id] = &k
+	}
+	return nil
+}
+
+// save writes the counts to u.path, replacing the file atomically so a
+// crash mid-write keeps the previous counts.
+func (u *usageTracker) save() error {
+	if u.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(u.snapshot())
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(u.path), ".usage-*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), u.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// saveLoop persists the counts every interval (TTS_USAGE_SAVE_INTERVAL)
+// until ctx is done. main saves once more at shutdown.
+func (u *usageTracker) saveLoop(ctx context.Context, interval time.Duration) {
+	if u.path == "" || interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := u.save(); err != nil {
+				slog.Warn("usage counts not saved", "path", u.path, "err", err)
+			}
+		}
+	}
+}
+
+// meterUsage counts each synthesis API request against its token and
+// answers 429 once the token's quota for the window is used up. It runs
+// inside requireToken, which identifies the token.
+func meterUsage(u *usageTracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tts", "/api/tts/batch", "/api/tts/ssml", "/api/tts/variants", "/api/phonemes":
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := authKeyFrom(r.Context())
+		if key == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wait, ok := u.admit(key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "quota_exceeded", "usage quota exceeded for this key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleUsage reports each token's usage in the current window, keyed by
+// tokenID. Like the other admin endpoints it is only registered when
+// TTS_ADMIN_TOKENS is set.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	if usage == nil {
+		writeError(w, http.StatusNotFound, "not_found", "usage tracking is disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, usage.snapshot())
+}
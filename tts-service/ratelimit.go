@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ipLimiter keeps a token bucket per client IP. Buckets idle for longer than
+// ipLimiterIdle are dropped.
+type ipLimiter struct {
+	limit      rate.Limit
+	burst      int
+	trustProxy bool
+
+	mu        sync.Mutex
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+const ipLimiterIdle = 10 * time.Minute
+
+func newIPLimiter(perSecond float64, burst int, trustProxy bool) *ipLimiter {
+	return &ipLimiter{
+		limit:      rate.Limit(perSecond),
+		burst:      max(1, burst),
+		trustProxy: trustProxy,
+		clients:    make(map[string]*clientBucket),
+		lastSweep:  time.Now(),
+	}
+}
+
+// ipLimiterFromEnv builds the limiter configured by TTS_RATE_LIMIT (requests
+// per second per IP; 0 disables limiting), TTS_RATE_BURST and
+// TTS_TRUST_PROXY. It returns nil when limiting is disabled.
+func ipLimiterFromEnv() *ipLimiter {
+	perSecond := envFloat("TTS_RATE_LIMIT", 0)
+	if perSecond <= 0 {
+		return nil
+	}
+	return newIPLimiter(perSecond, envInt("TTS_RATE_BURST", int(math.Ceil(perSecond))), envBool("TTS_TRUST_PROXY", false))
+}
+
+// reserve takes a token for ip, returning how long the client must wait when
+// none is available.
+func (l *ipLimiter) reserve(ip string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > ipLimiterIdle {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > ipLimiterIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &clientBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+	l.mu.Unlock()
+
+	res := c.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// clientIP returns the address requests are limited by.
+func (l *ipLimiter) clientIP(r *http.Request) string {
+	return clientIP(r, l.trustProxy)
+}
+
+// clientIP returns the client's address. Behind a trusted proxy that is the
+// last X-Forwarded-For hop, the one the proxy appended; earlier entries are
+// client-supplied.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit rejects requests over the client's rate with 429 before they
+// reach next. Health and metrics endpoints are exempt so probes and scrapes
+// are never throttled.
+func rateLimit(l *ipLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wait := l.reserve(l.clientIP(r)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitRejectsOverBurst(t *testing.T) {
+	const burst = 3
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := rateLimit(newIPLimiter(0.001, burst, false), ok)
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/tts?text=x", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < burst; i++ {
+		if rec := do("192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, rec.Code)
+		}
+	}
+	rec := do("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request %d: status %d, want 429", burst+1, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("429 without Retry-After")
+	}
+
+	// Other clients have their own bucket.
+	if rec := do("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("other client: status %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimitUsesForwardedForBehindProxy(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := rateLimit(newIPLimiter(0.001, 1, true), ok)
+
+	do := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/tts?text=x", nil)
+		req.RemoteAddr = "10.0.0.1:80" // the proxy
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do("198.51.100.7"); code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", code)
+	}
+	// A spoofed leading entry doesn't give the client a fresh bucket.
+	if code := do("203.0.113.9, 198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", code)
+	}
+	if code := do("198.51.100.8"); code != http.StatusOK {
+		t.Fatalf("other client: status %d, want 200", code)
+	}
+}
+
+func TestRateLimitExemptsProbes(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := rateLimit(newIPLimiter(0.001, 1, false), ok)
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("probe %d: status %d, want 200", i+1, rec.Code)
+		}
+	}
+}
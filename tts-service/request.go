@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultMaxBodyBytes bounds POST bodies unless TTS_MAX_BODY_BYTES is set.
+// It comfortably fits the longest allowed text as JSON-escaped Devanagari.
+const defaultMaxBodyBytes = 64 << 10
+
+// decodeTTSRequest reads a ttsRequest from the JSON body of a POST or the
+// query string of a GET. It returns the status, error code and message to
+// report when the request can't be decoded.
+func decodeTTSRequest(w http.ResponseWriter, r *http.Request) (ttsRequest, int, string, string) {
+	var req ttsRequest
+	if r.Method == http.MethodGet {
+		if err := queryRequest(r.URL.Query(), &req); err != nil {
+			return req, http.StatusBadRequest, "invalid_query", err.Error()
+		}
+		return req, 0, "", ""
+	}
+
+	// Cap the body before decoding so an oversized payload is never buffered.
+	limit := envInt64("TTS_MAX_BODY_BYTES", defaultMaxBodyBytes)
+	body := http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body exceeds %d bytes", limit)
+		}
+		return req, http.StatusBadRequest, "invalid_json", "invalid JSON"
+	}
+	return req, 0, "", ""
+}
+
+// queryRequest fills req from query parameters named like the JSON fields.
+// Values are already percent-decoded, so Devanagari text arrives intact.
+func queryRequest(q url.Values, req *ttsRequest) error {
+	req.Text = q.Get("text")
+	req.Texts = q["texts"]
+	req.Lang = q.Get("lang")
+	req.Granularity = q.Get("granularity")
+	req.Format = q.Get("format")
+	req.Provider = q.Get("provider")
+	req.Gender = q.Get("gender")
+	req.VoiceVariant = q.Get("voiceVariant")
+	req.Transliterate = q.Get("transliterate")
+	req.ResponseFormat = q.Get("responseFormat")
+	req.Name = q.Get("name")
+	for name, dst := range map[string]*bool{"ssml": &req.SSML, "dryRun": &req.DryRun, "download": &req.Download} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dst = b
+	}
+	if v := q.Get("sampleRateHertz"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid sampleRateHertz %q", v)
+		}
+		req.SampleRateHertz = n
+	}
+	for name, dst := range map[string]*int{"channels": &req.Channels, "bitrate": &req.Bitrate, "segmentPauseMs": &req.SegmentPauseMs, "leadSilenceMs": &req.LeadSilenceMs, "trailSilenceMs": &req.TrailSilenceMs} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dst = n
+	}
+	for name, dst := range map[string]*float64{"rate": &req.Rate, "pitch": &req.Pitch, "volume": &req.Volume} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dst = f
+	}
+	return nil
+}
+
+// cacheableMaxAge is how long browsers and CDNs may keep GET audio. A clip is
+// fully determined by its request, so it only changes with the configuration.
+const cacheableMaxAge = 86400
+
+// setCacheHeaders marks a GET response as publicly cacheable under an ETag
+// derived from the request hash.
+func setCacheHeaders(h http.Header, key string) {
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(cacheableMaxAge))
+	h.Set("ETag", etagFor(key))
+}
+
+func etagFor(key string) string {
+	return `"` + key + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison RFC 9110 prescribes for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// clearCacheHeaders undoes setCacheHeaders for responses that must not be
+// kept, such as errors and fallback audio.
+func clearCacheHeaders(h http.Header) {
+	h.Set("Cache-Control", "no-store")
+	h.Del("ETag")
+}
+
+// requestError is a validation failure to report to the client.
+type requestError struct {
+	status int
+	apiError
+}
+
+func badRequest(code, message string) *requestError {
+	return &requestError{http.StatusBadRequest, apiError{Code: code, Message: message}}
+}
+
+// fieldError is a 400 naming the request field at fault.
+func fieldError(field, code, message string) *requestError {
+	return &requestError{http.StatusBadRequest, apiError{Code: code, Message: message, Field: field}}
+}
+
+// validate checks the combinations of fields a request may not use
+// together, before anything is normalized or synthesized.
+func (req ttsRequest) validate() *requestError {
+	switch {
+	case req.Text == "" && len(req.Texts) == 0:
+		return fieldError("text", "text_required", "text is required")
+	case req.Text != "" && len(req.Texts) > 0:
+		return fieldError("texts", "text_conflict", "text and texts are mutually exclusive")
+	case req.SSML && len(req.Texts) > 0:
+		return fieldError("ssml", "invalid_ssml", "SSML input is not supported for texts")
+	case req.SSML && req.Transliterate != "":
+		return fieldError("transliterate", "unsupported_transliteration", "transliteration is not supported for SSML input")
+	case req.Gender != "" && req.VoiceVariant != "":
+		return fieldError("voiceVariant", "variant_conflict", "gender and voiceVariant are mutually exclusive")
+	case req.SegmentPauseMs != 0 && len(req.Texts) == 0:
+		return fieldError("segmentPauseMs", "segment_pause_without_texts", "segmentPauseMs only applies to texts")
+	}
+	return nil
+}
+
+// ttsJob is a validated request ready for synthesis.
+type ttsJob struct {
+	req      ttsRequest
+	provider string
+	text     string   // normalized text, converted to SSML for verse breaks
+	chunks   []string // text split into provider-sized pieces
+	key      string   // cache key
+	detected string   // language detected for lang "auto"
+}
+
+// validGranularity reports whether g is a granularity we read differently;
+// empty leaves each provider at its natural pace.
+func validGranularity(g string) bool {
+	switch g {
+	case "", "verse", "line", "word", "syllable":
+		return true
+	}
+	return false
+}
+
+// defaultGranularity is used for requests that don't name one
+// (TTS_DEFAULT_GRANULARITY). An invalid setting is ignored; main warns
+// about it at startup.
+func defaultGranularity() string {
+	if g := strings.ToLower(os.Getenv("TTS_DEFAULT_GRANULARITY")); validGranularity(g) {
+		return g
+	}
+	return ""
+}
+
+// checkJob applies the checks that need the prepared job: that ffmpeg is
+// there if the job needs it, and the duration limit.
+func checkJob(job *ttsJob) (*ttsJob, *requestError) {
+	if perr := requireFFmpeg(job); perr != nil {
+		return nil, perr
+	}
+	return limitDuration(job)
+}
+
+// prepareTTS validates req and resolves everything synthesis needs: the
+// provider (defaultProvider unless overridden), normalized and transliterated
+// text, its chunks and the cache key.
+func prepareTTS(req ttsRequest, defaultProvider string) (*ttsJob, *requestError) {
+	if perr := req.validate(); perr != nil {
+		return nil, perr
+	}
+	req.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	if !validFormat(req.Format) {
+		return nil, fieldError("format", "unsupported_format", unsupportedFormatMessage(req.Format))
+	}
+	if req.Bitrate != 0 {
+		if !slices.Contains(mp3Bitrates(), req.Bitrate) {
+			return nil, fieldError("bitrate", "unsupported_bitrate", fmt.Sprintf("unsupported bitrate %d (supported: %s)", req.Bitrate, joinInts(mp3Bitrates())))
+		}
+		if req.Format != "" && req.Format != "mp3" {
+			return nil, fieldError("bitrate", "unsupported_bitrate", fmt.Sprintf("bitrate applies to mp3, not %q", req.Format))
+		}
+		req.Format = "mp3"
+	}
+	if !validSampleRate(req.Format, req.SampleRateHertz) {
+		return nil, fieldError("sampleRateHertz", "unsupported_sample_rate",
+			fmt.Sprintf("unsupported sampleRateHertz %d for format %q", req.SampleRateHertz, req.Format))
+	}
+	if req.Channels < 0 || req.Channels > 2 {
+		return nil, fieldError("channels", "unsupported_channels", fmt.Sprintf("unsupported channels %d (supported: 1, 2)", req.Channels))
+	}
+	if perr := normalizeVariant(&req); perr != nil {
+		return nil, perr
+	}
+	req.LeadSilenceMs = clampSilencePad(req.LeadSilenceMs)
+	req.TrailSilenceMs = clampSilencePad(req.TrailSilenceMs)
+
+	req.Granularity = strings.ToLower(strings.TrimSpace(req.Granularity))
+	if req.Granularity == "" {
+		req.Granularity = defaultGranularity()
+	}
+	if !validGranularity(req.Granularity) {
+		return nil, fieldError("granularity", "unsupported_granularity",
+			fmt.Sprintf("unsupported granularity %q (supported: verse, line, word, syllable)", req.Granularity))
+	}
+
+	switch req.ResponseFormat {
+	case "", "audio", "json":
+	default:
+		return nil, fieldError("responseFormat", "unsupported_response_format",
+			fmt.Sprintf("unsupported responseFormat %q (supported: audio, json)", req.ResponseFormat))
+	}
+
+	provider := defaultProvider
+	if req.Provider != "" {
+		if !envBool("TTS_ALLOW_PROVIDER_OVERRIDE", false) {
+			return nil, &requestError{http.StatusForbidden, apiError{Code: "provider_override_disabled", Message: "per-request provider selection is disabled", Field: "provider"}}
+		}
+		if _, ok := providers[req.Provider]; !ok {
+			return nil, fieldError("provider", "unknown_provider", fmt.Sprintf("unknown provider %q", req.Provider))
+		}
+		if missing := providerMissing(req.Provider); len(missing) > 0 {
+			return nil, fieldError("provider", "provider_unavailable",
+				fmt.Sprintf("provider %q is unavailable: missing %s", req.Provider, strings.Join(missing, ", ")))
+		}
+		provider = req.Provider
+	}
+
+	if len(req.Texts) > 0 {
+		for i, t := range req.Texts {
+			clean, perr := sanitizeInput(t, false, fmt.Sprintf("texts[%d]", i))
+			if perr != nil {
+				return nil, perr
+			}
+			req.Texts[i] = clean
+		}
+		joined, perr := joinSegments(req.Texts)
+		if perr != nil {
+			return nil, perr
+		}
+		req.Text = joined
+	}
+
+	// Normalize to NFC so text typed with different IMEs reaches providers,
+	// the length checks and the cache key in one canonical form.
+	text := norm.NFC.String(req.Text)
+	req.Text = text
+	if perr := requireReadable(text); perr != nil {
+		return nil, perr
+	}
+
+	if !req.SSML && len(req.Texts) == 0 && isSSML(text) {
+		req.SSML = true
+	}
+	text, perr := sanitizeInput(text, req.SSML, "text")
+	if perr != nil {
+		return nil, perr
+	}
+	req.Text = text
+	if req.SSML {
+		if err := validateSSML(text); err != nil {
+			return nil, fieldError("text", "invalid_ssml", "malformed SSML: "+err.Error())
+		}
+	}
+
+	if perr := validateLang(req.Lang); perr != nil {
+		return nil, perr
+	}
+	detected := ""
+	if req.Lang == "auto" {
+		spoken := text
+		if req.SSML {
+			spoken = ssmlToText(text, nil)
+		}
+		detected = detectLang(spoken)
+		req.Lang = detected
+	}
+
+	if req.Transliterate != "" {
+		if req.Transliterate != "deva" {
+			return nil, fieldError("transliterate", "unsupported_transliteration",
+				fmt.Sprintf("unsupported transliteration target %q (supported: deva)", req.Transliterate))
+		}
+		if req.SSML {
+			return nil, fieldError("transliterate", "unsupported_transliteration", "transliteration is not supported for SSML input")
+		}
+		text = iastToDevanagari(text)
+		req.Text, req.Lang = text, req.Transliterate
+	}
+	text = shapeText(text, req.Lang)
+	if !req.SSML {
+		text = expandNumbers(text, req.Lang)
+	}
+
+	// TTS_MAX_TEXT caps the total input length (0 disables the cap). Text
+	// longer than maxChunkRunes is split and synthesized in pieces.
+	if maxText := envInt("TTS_MAX_TEXT", 2500); maxText > 0 && len([]rune(text)) > maxText {
+		return nil, &requestError{http.StatusBadRequest, apiError{Code: "text_too_long", Message: "text too long", MaxRunes: maxText}}
+	}
+	if req.SSML && len([]rune(text)) > maxChunkRunes {
+		// Splitting would cut through markup, so SSML must fit in one call.
+		return nil, &requestError{http.StatusBadRequest, apiError{Code: "text_too_long", Message: "SSML input too long", MaxRunes: maxChunkRunes}}
+	}
+	if len(req.Texts) > 0 {
+		chunks, segments, perr := splitSegments(text)
+		if perr != nil {
+			return nil, perr
+		}
+		req.pause, req.segments = segmentPause(req.SegmentPauseMs), segments
+		return checkJob(&ttsJob{req: req, provider: provider, text: text, chunks: chunks, key: cacheKey(text, req, provider), detected: detected})
+	}
+
+	// Syllable granularity reads each akshara on its own, with a short gap.
+	if req.Granularity == "syllable" {
+		if req.SSML {
+			return nil, fieldError("granularity", "unsupported_granularity", "syllable granularity does not support SSML input")
+		}
+		chunks, perr := splitSyllables(text)
+		if perr != nil {
+			return nil, perr
+		}
+		req.pause = syllablePause()
+		return checkJob(&ttsJob{req: req, provider: provider, text: text, chunks: chunks, key: cacheKey(text, req, provider), detected: detected})
+	}
+
+	// Verses pause after each danda. Providers that honour SSML get <break>s;
+	// the rest render each pada separately with silence spliced between.
+	pause := time.Duration(0)
+	if !req.SSML && req.Granularity == "verse" {
+		pause = versePause()
+	}
+	split := splitText
+	if pause > 0 && !ssmlBreakProviders[provider] {
+		split = splitPadas
+		req.pause = pause
+	}
+	chunks, err := split(text, maxChunkRunes)
+	if err != nil {
+		return nil, &requestError{http.StatusBadRequest, apiError{
+			Code:     "text_unsplittable",
+			Message:  fmt.Sprintf("text contains a segment longer than %d characters", maxChunkRunes),
+			MaxRunes: maxChunkRunes,
+		}}
+	}
+
+	if pause > 0 && ssmlBreakProviders[provider] {
+		for i := range chunks {
+			chunks[i] = insertVerseBreaks(chunks[i], pause)
+		}
+		text = insertVerseBreaks(text, pause)
+		req.SSML = true
+	}
+
+	return checkJob(&ttsJob{req: req, provider: provider, text: text, chunks: chunks, key: cacheKey(text, req, provider), detected: detected})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConditionalGet(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "espeak")
+	withCache(t, newAudioCache(0, 0))
+
+	var calls atomic.Int32
+	stubSynthesizer(t, "espeak", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write([]byte("RIFF-audio"))
+		return err
+	})
+
+	const target = "/api/tts?text=%E0%A4%A8%E0%A4%AE%E0%A4%83&lang=deva&granularity=verse"
+	rec := httptest.NewRecorder()
+	handleTTS(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("missing ETag")
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+		wantCalls   int32
+	}{
+		{"matching", etag, http.StatusNotModified, 0},
+		{"matching in list", `"other", W/` + etag, http.StatusNotModified, 0},
+		{"wildcard", "*", http.StatusNotModified, 0},
+		{"not matching", `"other"`, http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			rec := httptest.NewRecorder()
+			handleTTS(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("synthesizer ran %d times, want %d", got, tt.wantCalls)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Fatalf("ETag %q, want %q", got, etag)
+			}
+			if tt.wantStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Fatalf("304 with body %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestOversizedBody(t *testing.T) {
+	t.Setenv("TTS_MAX_BODY_BYTES", "64")
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"`+strings.Repeat("ॐ", 100)+`"}`))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"body_too_large"`) {
+		t.Fatalf("body %s, want body_too_large", rec.Body.String())
+	}
+}
+
+func TestGranularityValidation(t *testing.T) {
+	tests := []struct {
+		granularity, fallback, want string
+		wantErr                     bool
+	}{
+		{"verse", "", "verse", false},
+		{" Word ", "", "word", false},
+		{"", "", "", false},
+		{"", "line", "line", false},
+		{"", "bogus", "", false},
+		{"sentence", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TTS_DEFAULT_GRANULARITY", tt.fallback)
+		job, perr := prepareTTS(ttsRequest{Text: "नमः", Lang: "deva", Granularity: tt.granularity}, "espeak")
+		if tt.wantErr {
+			if perr == nil || perr.Code != "unsupported_granularity" {
+				t.Errorf("granularity %q: err = %v, want unsupported_granularity", tt.granularity, perr)
+			}
+			continue
+		}
+		if perr != nil {
+			t.Errorf("granularity %q: %v", tt.granularity, perr.Message)
+			continue
+		}
+		if job.req.Granularity != tt.want {
+			t.Errorf("granularity %q with default %q = %q, want %q", tt.granularity, tt.fallback, job.req.Granularity, tt.want)
+		}
+	}
+}
+
+func TestStrictLang(t *testing.T) {
+	if _, perr := prepareTTS(ttsRequest{Text: "नमः", Lang: "hindi"}, "espeak"); perr != nil {
+		t.Fatalf("lenient: %v", perr.Message)
+	}
+
+	t.Setenv("TTS_STRICT_LANG", "true")
+	for _, lang := range []string{"", "auto", "deva", "mal"} {
+		if _, perr := prepareTTS(ttsRequest{Text: "नमः", Lang: lang}, "espeak"); perr != nil {
+			t.Errorf("lang %q: %v", lang, perr.Message)
+		}
+	}
+	_, perr := prepareTTS(ttsRequest{Text: "नमः", Lang: "hindi"}, "espeak")
+	if perr == nil || perr.status != http.StatusBadRequest || perr.Code != "unsupported_lang" {
+		t.Fatalf("strict: got %+v, want unsupported_lang", perr)
+	}
+	if want := `unsupported lang "hindi" (supported: auto, deva, iast, knda, tel, tam, guj, pan, mr, ben, mal)`; perr.Message != want {
+		t.Errorf("message = %q, want %q", perr.Message, want)
+	}
+}
+
+func TestValidateFieldCombinations(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         ttsRequest
+		field, code string
+	}{
+		{"no text", ttsRequest{Lang: "deva"}, "text", "text_required"},
+		{"text and texts", ttsRequest{Text: "नमः", Texts: []string{"शिवाय"}}, "texts", "text_conflict"},
+		{"ssml texts", ttsRequest{Texts: []string{"<speak>नमः</speak>"}, SSML: true}, "ssml", "invalid_ssml"},
+		{"ssml transliterated", ttsRequest{Text: "<speak>namah</speak>", SSML: true, Transliterate: "deva"}, "transliterate", "unsupported_transliteration"},
+		{"segment pause without texts", ttsRequest{Text: "नमः", SegmentPauseMs: 300}, "segmentPauseMs", "segment_pause_without_texts"},
+		{"valid text", ttsRequest{Text: "नमः", Transliterate: "deva"}, "", ""},
+		{"valid texts", ttsRequest{Texts: []string{"नमः"}, SegmentPauseMs: 300}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perr := tt.req.validate()
+			if tt.code == "" {
+				if perr != nil {
+					t.Fatalf("unexpected error %+v", perr.apiError)
+				}
+				return
+			}
+			if perr == nil || perr.status != http.StatusBadRequest || perr.Code != tt.code || perr.Field != tt.field {
+				t.Fatalf("got %+v, want %s on field %s", perr, tt.code, tt.field)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"नमः","texts":["शिवाय"]}`))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"field":"texts"`) {
+		t.Errorf("handler: status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUnreadableText(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Setenv("TTS_STRICT_LANG", strconv.FormatBool(strict))
+		for _, tt := range []struct {
+			text, code string
+		}{
+			{"   ", "text_required"},
+			{"\n\n", "text_required"},
+			{"\u3000\t", "text_required"},
+			{"।", "text_unreadable"},
+			{"॥ ... ।", "text_unreadable"},
+			{"१२ ।", ""},
+			{"राम।", ""},
+		} {
+			if !strict && tt.code == "text_unreadable" {
+				tt.code = ""
+			}
+			_, perr := prepareTTS(ttsRequest{Text: tt.text, Lang: "deva"}, "espeak")
+			switch {
+			case tt.code == "" && perr != nil:
+				t.Errorf("strict %v, %q: unexpected error %+v", strict, tt.text, perr.apiError)
+			case tt.code != "" && (perr == nil || perr.status != http.StatusBadRequest || perr.Code != tt.code || perr.Field != "text"):
+				t.Errorf("strict %v, %q: got %+v, want %s", strict, tt.text, perr, tt.code)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":" \n ","lang":"deva"}`))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"text_required"`) {
+		t.Errorf("handler: status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFFmpegUnavailable(t *testing.T) {
+	ffmpegMissing.Store(true)
+	t.Cleanup(func() { ffmpegMissing.Store(false) })
+
+	tests := []struct {
+		provider string
+		req      ttsRequest
+		field    string // "" when the request needs no ffmpeg
+	}{
+		{"espeak", ttsRequest{Text: "राम"}, ""},
+		{"espeak", ttsRequest{Text: "राम", Format: "wav", LeadSilenceMs: 200}, ""},
+		{"espeak", ttsRequest{Text: "राम", Format: "mp3"}, "format"},
+		{"sarvam", ttsRequest{Text: "राम", Format: "mp3"}, ""},
+		{"sarvam", ttsRequest{Text: "राम", Format: "ogg"}, "format"},
+		{"espeak", ttsRequest{Text: "राम", SampleRateHertz: 16000}, "sampleRateHertz"},
+		{"sarvam", ttsRequest{Text: "राम", TrailSilenceMs: 200}, "trailSilenceMs"},
+		{"mac", ttsRequest{Text: "राम", Volume: -6}, "volume"},
+		{"sarvam", ttsRequest{Texts: []string{"राम", "सीता"}}, "format"},
+		{"sarvam", ttsRequest{Texts: []string{"राम", "सीता"}, Format: "wav"}, ""},
+	}
+	for _, tt := range tests {
+		_, perr := prepareTTS(tt.req, tt.provider)
+		switch {
+		case tt.field == "" && perr != nil:
+			t.Errorf("%s %+v: %+v, want no error", tt.provider, tt.req, perr)
+		case tt.field != "" && (perr == nil || perr.status != http.StatusNotImplemented || perr.Field != tt.field):
+			t.Errorf("%s %+v: %+v, want 501 for %s", tt.provider, tt.req, perr, tt.field)
+		}
+	}
+
+	if got := availableFormats("polly"); strings.Join(got, ",") != "wav,mp3,ogg" {
+		t.Errorf("polly formats without ffmpeg = %q", got)
+	}
+}
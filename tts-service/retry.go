@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff between provider retries: base doubles per attempt up to max, with
+// jitter so clients that were throttled together don't retry together.
+const (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// doWithRetry sends the request built by newReq, retrying up to maxRetries
+// times on 429 and 5xx responses. It honors Retry-After and gives up early
+// when ctx is cancelled or the wait would run past its deadline; the last
+// response is then returned for the caller to report.
+func doWithRetry(ctx context.Context, provider string, maxRetries int, newReq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !retryableStatus(resp.StatusCode) || attempt >= maxRetries {
+			return resp, nil
+		}
+
+		delay := retryDelay(resp.Header.Get("Retry-After"), attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		logFrom(ctx).Warn("retrying provider call", "status", resp.StatusCode, "attempt", attempt+1, "max_retries", maxRetries, "delay", delay.String())
+		ttsProviderRetries.WithLabelValues(provider, strconv.Itoa(resp.StatusCode)).Inc()
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryDelay returns the wait before retry attempt+1: the server's
+// Retry-After when given, else exponential backoff with jitter.
+func retryDelay(retryAfter string, attempt int) time.Duration {
+	if retryAfter != "" {
+		if secs, err := strconv.Atoi(retryAfter); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(retryAfter); err == nil {
+			return max(0, time.Until(t))
+		}
+	}
+	d := min(retryMaxDelay, retryBaseDelay<<attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// htmlTag matches HTML tags and comments in text pasted from web pages.
+// A tag must start with a letter, "/" or "!", so "a < b" and "<3" are left
+// as text.
+var htmlTag = regexp.MustCompile(`(?s)<!--.*?-->|</?[A-Za-z][^<>]*>|<![^<>]*>`)
+
+// htmlBreakTag matches the tags that end a line, which become newlines so
+// line granularity still sees the lines of a pasted verse.
+var htmlBreakTag = regexp.MustCompile(`(?i)^<(br|/p|/div|/li|/h[1-6]|/tr)\b`)
+
+// sanitizeMode is TTS_SANITIZE: "strip" (default) removes HTML tags,
+// control characters and stray zero-width characters before synthesis;
+// "reject" refuses input containing any of them.
+func sanitizeMode() string {
+	if strings.EqualFold(envString("TTS_SANITIZE", "strip"), "reject") {
+		return "reject"
+	}
+	return "strip"
+}
+
+// sanitizeText cleans text for synthesis and names what it removed. Control
+// characters other than newline are dropped (tabs and carriage returns
+// become spaces and newlines), as are zero-width spaces and joiners that
+// don't sit between two letters of a script that uses them. Unless text is
+// SSML, HTML tags are removed and entities decoded; any "<" left over is
+// plain text, which providers given SSML receive escaped.
+func sanitizeText(text string, ssml bool) (string, []string) {
+	var found []string
+	if !ssml && htmlTag.MatchString(text) {
+		found = append(found, "HTML tags")
+		text = htmlTag.ReplaceAllStringFunc(text, func(tag string) string {
+			if htmlBreakTag.MatchString(tag) {
+				return "\n"
+			}
+			return " "
+		})
+	}
+	if !ssml && strings.Contains(text, "&") {
+		text = html.UnescapeString(text)
+	}
+
+	runes := []rune(text)
+	var b strings.Builder
+	var control, zeroWidth bool
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+		case r == '\r':
+			if i+1 < len(runes) && runes[i+1] == '\n' {
+				continue
+			}
+			r = '\n'
+		case r == '\t':
+			r = ' '
+		case r < 0x20 || (r >= 0x7f && r <= 0x9f):
+			control = true
+			continue
+		case r == '\u200b' || r == '\u2060' || r == '\ufeff': // zero-width space, word joiner, BOM
+			zeroWidth = true
+			continue
+		case r == '\u200c' || r == '\u200d':
+			// ZWNJ and ZWJ choose conjunct forms, so they only mean
+			// something inside a word: after a virama or letter and
+			// before a letter, as in क्<ZWJ>ष.
+			if i == 0 || i+1 == len(runes) || !joinable(runes[i-1]) || !unicode.IsLetter(runes[i+1]) || runes[i+1] <= unicode.MaxASCII {
+				zeroWidth = true
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	if control {
+		found = append(found, "control characters")
+	}
+	if zeroWidth {
+		found = append(found, "zero-width characters")
+	}
+	return b.String(), found
+}
+
+// joinable reports whether a zero-width joiner may follow r.
+func joinable(r rune) bool {
+	return r > unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsMark(r))
+}
+
+// sanitizeInput applies sanitizeText according to TTS_SANITIZE. field names
+// the input in error messages.
+func sanitizeInput(text string, ssml bool, field string) (string, *requestError) {
+	clean, found := sanitizeText(text, ssml)
+	if len(found) == 0 {
+		return text, nil
+	}
+	if sanitizeMode() == "reject" {
+		return "", badRequest("invalid_characters", fmt.Sprintf("%s contains %s", field, strings.Join(found, ", ")))
+	}
+	if strings.TrimSpace(clean) == "" {
+		return "", badRequest("text_empty_after_sanitize", fmt.Sprintf("%s has nothing to read once %s are removed", field, strings.Join(found, ", ")))
+	}
+	return clean, nil
+}
+
+// requireReadable rejects text with nothing for an engine to read, which
+// would otherwise render silence or fail inside the provider: blank text
+// always, and in strict mode, text that is only punctuation ("।", "...").
+func requireReadable(text string) *requestError {
+	if strings.TrimSpace(text) == "" {
+		return fieldError("text", "text_required", "text is empty or only whitespace")
+	}
+	if !strictMode() {
+		return nil
+	}
+	for _, r := range text {
+		if !unicode.IsSpace(r) && !unicode.IsPunct(r) {
+			return nil
+		}
+	}
+	return fieldError("text", "text_unreadable", "text is only punctuation")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		name, in string
+		ssml     bool
+		want     string
+		found    int
+	}{
+		{"pasted html", `<p class="verse">ॐ नमः&nbsp;शिवाय<br/>शिवाय नमः</p><!-- ad -->`, false, " ॐ नमः शिवाय\nशिवाय नमः\n ", 1},
+		{"script tag", `<script>x</script>नमः`, false, " x नमः", 1},
+		{"angle brackets kept", "a < b > c <3", false, "a < b > c <3", 0},
+		{"control characters", "नमः\x00\x1b\u0085 शिवाय\r\nॐ\t", false, "नमः शिवाय\nॐ ", 1},
+		{"zero-width space", "न\u200bमः\ufeff", false, "नमः", 1},
+		{"joiner in conjunct", "क्\u200dष", false, "क्\u200dष", 0},
+		{"stray joiners", "\u200dनमः \u200cशिवाय\u200d", false, "नमः शिवाय", 1},
+		{"joiner in latin", "a\u200db", false, "ab", 1},
+		{"ssml tags kept", "<speak>नमः\x07</speak>", true, "<speak>नमः</speak>", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := sanitizeText(tt.in, tt.ssml)
+			if got != tt.want || len(found) != tt.found {
+				t.Errorf("sanitizeText(%q) = %q, %v; want %q with %d findings", tt.in, got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestSanitizeRequests(t *testing.T) {
+	if _, perr := prepareTTS(ttsRequest{Text: "<div><span></span></div>\u200b", Lang: "deva"}, "espeak"); perr == nil || perr.Code != "text_empty_after_sanitize" {
+		t.Errorf("markup only: got %+v, want text_empty_after_sanitize", perr)
+	}
+
+	job, perr := prepareTTS(ttsRequest{Text: "<b>नमः</b> शिवाय", Lang: "deva"}, "espeak")
+	if perr != nil {
+		t.Fatal(perr.Message)
+	}
+	if job.text != " नमः  शिवाय" {
+		t.Errorf("text = %q", job.text)
+	}
+
+	t.Setenv("TTS_SANITIZE", "reject")
+	_, perr = prepareTTS(ttsRequest{Texts: []string{"नमः", "<i>शिवाय</i>"}, Lang: "deva"}, "espeak")
+	if perr == nil || perr.status != http.StatusBadRequest || perr.Code != "invalid_characters" || perr.Message != "texts[1] contains HTML tags" {
+		t.Errorf("reject: got %+v", perr)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// sarvamStreaming reports whether SARVAM_TTS_STREAMING is set, so Sarvam's
+// base64 audio is decoded and written to the client as the response body
+// arrives rather than once it has been read in full. Sarvam still renders
+// the whole clip before answering; this saves the time spent receiving and
+// decoding it, which is most of the wait for long passages.
+func sarvamStreaming() bool {
+	return envBool("SARVAM_TTS_STREAMING", false)
+}
+
+// sarvamProvider registers Sarvam as a streaming provider when
+// SARVAM_TTS_STREAMING is set at startup.
+func sarvamProvider() Provider {
+	if sarvamStreaming() {
+		return streamingProvider(synthesizeWithSarvam)
+	}
+	return bufferedProvider(synthesizeWithSarvam)
+}
+
+// sarvamAudioStream returns the first clip of a Sarvam response body
+// ({"audios": ["<base64>", ...], ...}), decoded while it is read. When the
+// body isn't shaped that way it returns ok false and a reader replaying the
+// whole body for the buffered decoder.
+func sarvamAudioStream(body io.Reader) (audio io.Reader, ok bool) {
+	var seen bytes.Buffer
+	dec := json.NewDecoder(io.TeeReader(body, &seen))
+	replay := func() (io.Reader, bool) { return io.MultiReader(&seen, body), false }
+
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return replay()
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return replay()
+		}
+		if key != "audios" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return replay()
+			}
+			continue
+		}
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return replay()
+		}
+		// The clip is read straight from the body from here on, so it is
+		// no longer kept in seen.
+		r := bufio.NewReader(io.MultiReader(dec.Buffered(), body))
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return replay()
+			}
+			if b == '"' {
+				return base64.NewDecoder(base64.StdEncoding, &jsonStringReader{r: r}), true
+			}
+			if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+				return replay()
+			}
+		}
+	}
+	return replay()
+}
+
+// jsonStringReader reads the rest of a JSON string whose opening quote has
+// been consumed, up to its closing quote. Only the "\/" escape is expected
+// in base64.
+type jsonStringReader struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func (s *jsonStringReader) Read(p []byte) (int, error) {
+	if s.done {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		if n > 0 && s.r.Buffered() == 0 {
+			// Hand over what has arrived rather than wait for more.
+			return n, nil
+		}
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+		switch b {
+		case '"':
+			s.done = true
+			return n, io.EOF
+		case '\\':
+			if b, err = s.r.ReadByte(); err != nil || b != '/' {
+				return n, errors.New("unexpected escape in base64 audio")
+			}
+		}
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
+
+// flushWriter flushes after every write, so streamed audio reaches the
+// client as soon as it is decoded.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok && err == nil {
+		fl.Flush()
+	}
+	return n, err
+}
+
+// decodeSarvamAudios decodes a whole Sarvam response and returns its first
+// clip.
+func decodeSarvamAudios(body io.Reader) ([]byte, error) {
+	var respBody struct {
+		Audios []string `json:"audios"`
+	}
+	if err := json.NewDecoder(body).Decode(&respBody); err != nil {
+		return nil, err
+	}
+	if len(respBody.Audios) == 0 || respBody.Audios[0] == "" {
+		return nil, fmt.Errorf("sarvam tts empty audios")
+	}
+	return base64.StdEncoding.DecodeString(respBody.Audios[0])
+}
+
+// firstByteWriter counts what is written and records when the first byte
+// went out, relative to start.
+type firstByteWriter struct {
+	w     io.Writer
+	start time.Time
+	ttfb  time.Duration
+	n     int64
+}
+
+func (f *firstByteWriter) Write(p []byte) (int, error) {
+	if f.n == 0 && len(p) > 0 {
+		f.ttfb = time.Since(f.start)
+	}
+	n, err := f.w.Write(p)
+	f.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestSarvamAudioStream(t *testing.T) {
+	audio := bytes.Repeat([]byte("ID3 sarvam audio?>"), 500)
+	b64 := base64.StdEncoding.EncodeToString(audio)
+	escaped := strings.ReplaceAll(b64, "/", `\/`)
+
+	for name, body := range map[string]string{
+		"audios first":   `{"audios": ["` + b64 + `"], "request_id": "r"}`,
+		"request first":  `{"request_id": {"id": "r"}, "audios":[ "` + escaped + `", "x"]}`,
+		"indented array": "{\n  \"audios\": [\n    \"" + b64 + "\"\n  ]\n}",
+	} {
+		r, ok := sarvamAudioStream(iotest.OneByteReader(strings.NewReader(body)))
+		if !ok {
+			t.Errorf("%s: not streamed", name)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, audio) {
+			t.Errorf("%s: %d bytes, err %v; want the %d byte clip", name, len(got), err, len(audio))
+		}
+	}
+
+	// Anything else is replayed whole for the buffered decoder.
+	for _, body := range []string{`{"audios": []}`, `{"audios": "x"}`, `[1]`, `{"error": "bad"}`} {
+		r, ok := sarvamAudioStream(strings.NewReader(body))
+		if got, _ := io.ReadAll(r); ok || string(got) != body {
+			t.Errorf("%s: streamed %v, replayed %q", body, ok, got)
+		}
+	}
+}
+
+// signalWriter reports its first write on wrote.
+type signalWriter struct {
+	*httptest.ResponseRecorder
+	once  sync.Once
+	wrote chan struct{}
+}
+
+func (s *signalWriter) Write(p []byte) (int, error) {
+	s.once.Do(func() { close(s.wrote) })
+	return s.ResponseRecorder.Write(p)
+}
+
+func TestSarvamStreaming(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 4096)
+	b64 := base64.StdEncoding.EncodeToString(audio)
+	w := &signalWriter{ResponseRecorder: httptest.NewRecorder(), wrote: make(chan struct{})}
+
+	// The API sends half the clip and holds the rest back until the client
+	// has received audio, which only a streaming decode allows.
+	api := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, `{"request_id":"r","audios":["`+b64[:len(b64)/2])
+		rw.(http.Flusher).Flush()
+		select {
+		case <-w.wrote:
+		case <-time.After(5 * time.Second):
+			t.Error("no audio reached the client before the response ended")
+		}
+		io.WriteString(rw, b64[len(b64)/2:]+`"]}`)
+	}))
+	defer api.Close()
+	t.Setenv("SARVAM_API_KEY", "test")
+	t.Setenv("SARVAM_BASE_URL", api.URL)
+	t.Setenv("SARVAM_TTS_STREAMING", "true")
+
+	if err := synthesizeWithSarvam(context.Background(), w, "राम", ttsRequest{Lang: "deva", Format: "mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.Body.Bytes(), audio) || !w.Flushed {
+		t.Errorf("got %d bytes (flushed %v), want the %d byte clip flushed", w.Body.Len(), w.Flushed, len(audio))
+	}
+}
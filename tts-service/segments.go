@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// segmentError reports which element of Texts failed to synthesize.
+type segmentError struct {
+	index int
+	err   error
+}
+
+func (e *segmentError) Error() string {
+	return fmt.Sprintf("segment %d: %v", e.index, e.err)
+}
+
+func (e *segmentError) Unwrap() error { return e.err }
+
+// synthesisFailed is the error reported when synthesis fails, naming the
+// failing segment for Texts requests.
+func synthesisFailed(err error) apiError {
+	e := apiError{Code: "synthesis_failed", Message: "tts error"}
+	var seg *segmentError
+	if errors.As(err, &seg) {
+		e.Message = fmt.Sprintf("tts error in texts[%d]", seg.index)
+		e.Segment = &seg.index
+	}
+	return e
+}
+
+// joinSegments validates Texts and joins them one per line, so the whole
+// request is normalized, transliterated and length-checked like a single
+// text. Line breaks inside a segment are read as spaces anyway, so they are
+// folded to keep the separator unambiguous.
+func joinSegments(texts []string) (string, *requestError) {
+	segs := make([]string, len(texts))
+	for i, t := range texts {
+		segs[i] = strings.Join(strings.Fields(t), " ")
+		if segs[i] == "" {
+			return "", badRequest("text_required", fmt.Sprintf("texts[%d] is empty", i))
+		}
+	}
+	return strings.Join(segs, "\n"), nil
+}
+
+// splitSegments splits joined texts back into segments, and each segment
+// into chunks, returning the segment each chunk came from.
+func splitSegments(text string) (chunks []string, segments []int, perr *requestError) {
+	for i, seg := range strings.Split(text, "\n") {
+		parts, err := splitText(seg, maxChunkRunes)
+		if err != nil {
+			return nil, nil, &requestError{http.StatusBadRequest, apiError{
+				Code:     "text_unsplittable",
+				Message:  fmt.Sprintf("texts[%d] contains a segment longer than %d characters", i, maxChunkRunes),
+				MaxRunes: maxChunkRunes,
+			}}
+		}
+		for _, p := range parts {
+			chunks = append(chunks, p)
+			segments = append(segments, i)
+		}
+	}
+	return chunks, segments, nil
+}
+
+// segmentPause is the silence between Texts: ms when positive, else
+// TTS_SEGMENT_PAUSE_MS (default 600), capped like silence padding.
+func segmentPause(ms int) time.Duration {
+	if ms <= 0 {
+		ms = envInt("TTS_SEGMENT_PAUSE_MS", 600)
+	}
+	return time.Duration(clampSilencePad(ms)) * time.Millisecond
+}
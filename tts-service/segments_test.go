@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTextsJoinedWithPause(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	withCache(t, newAudioCache(0, 0))
+	var spoken []string
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		if text == "fail" {
+			return errors.New("boom")
+		}
+		spoken = append(spoken, text)
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(pcmToWAV(make([]byte, 100), 8000, 1))
+		return err
+	})
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"texts":["राम", "कृष्ण\nगोविन्द"],"lang":"deva","segmentPauseMs":10}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(spoken) != 2 || spoken[0] != "राम" || spoken[1] != "कृष्ण गोविन्द" {
+		t.Errorf("segments spoken = %q", spoken)
+	}
+	wav, err := parseWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two 100-byte clips and 10ms of 8 kHz 16-bit silence between them.
+	if want := 100 + 160 + 100; len(wav.data) != want {
+		t.Errorf("joined data = %d bytes, want %d", len(wav.data), want)
+	}
+
+	rec = httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"texts":["राम", "fail"],"lang":"deva"}`))
+	var resp errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("status %d: %v", rec.Code, err)
+	}
+	if rec.Code != http.StatusInternalServerError || resp.Error.Segment == nil || *resp.Error.Segment != 1 {
+		t.Errorf("status %d, error %+v; want 500 naming segment 1", rec.Code, resp.Error)
+	}
+
+	rec = httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"राम","texts":["राम"],"lang":"deva"}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("text with texts: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// indicScript describes a Brahmic script block. These blocks share the
+// ISCII layout, so signs sit at the same offsets from the block's base.
+type indicScript struct {
+	base    rune
+	preBase []rune // vowel signs drawn left of their consonant, as offsets
+}
+
+// Offsets within an Indic block.
+const (
+	indicModifiersFirst = 0x01 // candrabindu, anusvara, visarga
+	indicModifiersLast  = 0x03
+	indicConsonantFirst = 0x15
+	indicConsonantLast  = 0x39
+	indicNukta          = 0x3C
+	indicSignsFirst     = 0x3E // dependent vowel signs
+	indicSignsLast      = 0x4C
+	indicVirama         = 0x4D
+)
+
+// shapingScripts are the scripts reordered for each lang. Telugu and Kannada
+// have no pre-base vowel signs but still get the nukta and modifier fixes;
+// iast and unknown languages aren't touched.
+var shapingScripts = map[string]indicScript{
+	"deva": {0x0900, []rune{0x3F}},
+	"mr":   {0x0900, []rune{0x3F}},
+	"ben":  {0x0980, []rune{0x3F, 0x47, 0x48}},
+	"pan":  {0x0A00, []rune{0x3F}},
+	"guj":  {0x0A80, []rune{0x3F}},
+	"tam":  {0x0B80, []rune{0x46, 0x47, 0x48}},
+	"tel":  {0x0C00, nil},
+	"knda": {0x0C80, nil},
+	"mal":  {0x0D00, []rune{0x46, 0x47, 0x48}},
+}
+
+// bidiControls are the directional marks, embeddings and isolates, which
+// no Indic text needs and which only confuse word splitting.
+const bidiControls = "\u061c\u200e\u200f\u202a\u202b\u202c\u202d\u202e\u2066\u2067\u2068\u2069"
+
+func (s indicScript) offset(r rune) rune {
+	if r < s.base || r >= s.base+0x80 {
+		return -1
+	}
+	return r - s.base
+}
+
+func (s indicScript) consonant(r rune) bool {
+	o := s.offset(r)
+	return o >= indicConsonantFirst && o <= indicConsonantLast
+}
+
+func (s indicScript) vowelSign(r rune) bool {
+	o := s.offset(r)
+	return o >= indicSignsFirst && o <= indicSignsLast
+}
+
+func (s indicScript) modifier(r rune) bool {
+	o := s.offset(r)
+	return o >= indicModifiersFirst && o <= indicModifiersLast
+}
+
+// shapeText strips bidi controls and, for langs whose script needs it,
+// puts vowel signs and marks into the logical order engines expect. NFC
+// can't do this, since vowel signs have combining class 0:
+//
+//   - A pre-base vowel sign typed in visual order, before its consonant
+//     (ि + क, common in text copied from PDFs and legacy fonts), is moved
+//     after the consonant cluster it belongs to: कि.
+//   - A nukta typed after the vowel sign (क + ि + ़) goes before it: क़ि.
+//   - Candrabindu, anusvara or visarga typed before the vowel sign
+//     (क + ं + ा) goes after it: कां.
+//
+// The result is normalized to NFC again, which composes two-part vowels
+// such as Bengali ো that the reordering brought together.
+func shapeText(text, lang string) string {
+	if strings.ContainsAny(text, bidiControls) {
+		text = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(bidiControls, r) {
+				return -1
+			}
+			return r
+		}, text)
+	}
+	script, ok := shapingScripts[lang]
+	if !ok {
+		return text
+	}
+	runes := []rune(text)
+	changed := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case slices.Contains(script.preBase, script.offset(r)) && !script.carries(runes, i) &&
+			i+1 < len(runes) && script.consonant(runes[i+1]):
+			end := script.clusterEnd(runes, i+1)
+			copy(runes[i:end-1], runes[i+1:end])
+			runes[end-1] = r
+			changed = true
+			i = end - 1
+		case script.offset(r) == indicNukta && i >= 2 && script.vowelSign(runes[i-1]) && script.consonant(runes[i-2]):
+			runes[i-1], runes[i] = runes[i], runes[i-1]
+			changed = true
+		case script.modifier(r) && i+1 < len(runes) && script.vowelSign(runes[i+1]) && script.carries(runes, i):
+			runes[i], runes[i+1] = runes[i+1], runes[i]
+			changed = true
+			i++
+		}
+	}
+	if !changed {
+		return text
+	}
+	return norm.NFC.String(string(runes))
+}
+
+// carries reports whether the sign at i follows a consonant (or its nukta),
+// so it is in logical order.
+func (s indicScript) carries(runes []rune, i int) bool {
+	if i == 0 {
+		return false
+	}
+	prev := runes[i-1]
+	return s.consonant(prev) || s.offset(prev) == indicNukta
+}
+
+// clusterEnd returns the index just past the consonant cluster starting at
+// i: consonants with their nuktas, joined by viramas.
+func (s indicScript) clusterEnd(runes []rune, i int) int {
+	for i < len(runes) && s.consonant(runes[i]) {
+		i++
+		if i < len(runes) && s.offset(runes[i]) == indicNukta {
+			i++
+		}
+		if i+1 < len(runes) && s.offset(runes[i]) == indicVirama && s.consonant(runes[i+1]) {
+			i++
+			continue
+		}
+		break
+	}
+	return i
+}
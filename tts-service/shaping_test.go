@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestShapeText(t *testing.T) {
+	tests := []struct {
+		name, lang, in, want string
+	}{
+		{"logical order untouched", "deva", "किताब", "किताब"},
+		{"visual pre-base matra", "deva", "िकताब", "किताब"},
+		{"pre-base matra over a conjunct", "deva", "िक्ष", "क्षि"},
+		{"pre-base matra mid-sentence", "deva", "राम िकया", "राम किया"},
+		{"nukta after matra", "deva", "जि़दगी", "ज़िदगी"},
+		{"anusvara before matra", "deva", "कंा", "कां"},
+		{"marathi", "mr", "िकती", "किती"},
+		{"bengali two-part vowel", "ben", "েকা", "কো"},
+		{"bengali e", "ben", "েদশ", "দেশ"},
+		{"tamil", "tam", "ெசன்னை", "சென்னை"},
+		{"malayalam two-part vowel", "mal", "െകാ", "കൊ"},
+		{"gurmukhi", "pan", "ਿਸਖ", "ਸਿਖ"},
+		{"kannada unchanged", "knda", "ಕಿರಣ", "ಕಿರಣ"},
+		{"bidi controls stripped", "deva", "\u202bराम\u202c \u200fसीता\u2069", "राम सीता"},
+		{"iast only loses bidi", "iast", "\u200erāma िक", "rāma िक"},
+		{"other scripts not reordered", "tam", "िक", "िक"},
+	}
+	for _, tt := range tests {
+		if got := shapeText(tt.in, tt.lang); got != tt.want {
+			t.Errorf("%s: shapeText(%q, %s) = %q, want %q", tt.name, tt.in, tt.lang, got, tt.want)
+		}
+	}
+}
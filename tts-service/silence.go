@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// trimEnabled reports whether TTS_TRIM_SILENCE asks for leading and
+// trailing silence to be cut from rendered audio.
+func trimEnabled() bool {
+	return envBool("TTS_TRIM_SILENCE", false)
+}
+
+// silenceThreshold is the level, in dBFS, below which audio counts as
+// silence (TTS_SILENCE_THRESHOLD_DB, default -50).
+func silenceThreshold() float64 {
+	return envFloat("TTS_SILENCE_THRESHOLD_DB", -50)
+}
+
+// silenceMaxTrim caps how much is cut from each end of a WAV clip
+// (TTS_SILENCE_MAX_TRIM, default 1s).
+func silenceMaxTrim() time.Duration {
+	return envDuration("TTS_SILENCE_MAX_TRIM", time.Second)
+}
+
+// trimKey is the part of the cache key describing silence trimming.
+func trimKey() string {
+	if !trimEnabled() {
+		return ""
+	}
+	return "trim=" + strconv.FormatFloat(silenceThreshold(), 'f', -1, 64) + "/" + silenceMaxTrim().String()
+}
+
+// trimEntry returns a copy of entry with leading and trailing silence
+// removed. 16-bit PCM WAV is scanned directly; other formats go through
+// ffmpeg's silenceremove, which isn't bound by the max-trim setting.
+func trimEntry(ctx context.Context, entry *cachedAudio) (*cachedAudio, error) {
+	trimmed := *entry
+	if entry.contentType == audioContentTypes["wav"] {
+		data, ok := trimWAVSilence(entry.data, silenceThreshold(), silenceMaxTrim())
+		if ok {
+			trimmed.data = data
+			return &trimmed, nil
+		}
+	}
+	if !ffmpegAvailable() {
+		return entry, nil // left untrimmed rather than failed
+	}
+	format := formatForContentType(entry.contentType)
+	if format == "" {
+		return nil, fmt.Errorf("can't trim %s", entry.contentType)
+	}
+	// silenceremove only trims the start, so the clip is reversed to trim
+	// the end the same way.
+	edge := fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%gdB", silenceThreshold())
+	var out bytes.Buffer
+	args := withBitrate(ffmpegArgs(format, edge+",areverse,"+edge+",areverse", 0, 0), entry.bitrate)
+	if err := runFFmpeg(ctx, &out, bytes.NewReader(entry.data), args, format); err != nil {
+		return nil, err
+	}
+	trimmed.data = out.Bytes()
+	return &trimmed, nil
+}
+
+// trimWAVSilence cuts frames quieter than thresholdDB from both ends of a
+// 16-bit PCM WAV, at most maxTrim from each end. It reports false for WAVs
+// in other sample formats. A clip that is silent throughout is returned
+// unchanged.
+func trimWAVSilence(data []byte, thresholdDB float64, maxTrim time.Duration) ([]byte, bool) {
+	wav, err := parseWAV(data)
+	if err != nil || len(wav.format) < 16 {
+		return nil, false
+	}
+	pcm := binary.LittleEndian.Uint16(wav.format[0:2]) == 1
+	bits := binary.LittleEndian.Uint16(wav.format[14:16])
+	channels := int(binary.LittleEndian.Uint16(wav.format[2:4]))
+	if !pcm || bits != 16 || channels == 0 {
+		return nil, false
+	}
+	frameSize := channels * 2
+	rate := int64(binary.LittleEndian.Uint32(wav.format[4:8]))
+	frames := len(wav.data) / frameSize
+	limit := min(frames, int(rate*int64(maxTrim)/int64(time.Second)))
+	threshold := int(32768 * math.Pow(10, thresholdDB/20))
+
+	loud := func(frame int) bool {
+		for ch := 0; ch < channels; ch++ {
+			off := frame*frameSize + ch*2
+			s := int(int16(binary.LittleEndian.Uint16(wav.data[off : off+2])))
+			if s > threshold || -s > threshold {
+				return true
+			}
+		}
+		return false
+	}
+	start := 0
+	for start < limit && !loud(start) {
+		start++
+	}
+	end := frames
+	for frames-end < limit && end > start && !loud(end-1) {
+		end--
+	}
+	if start >= end {
+		return data, true
+	}
+	wav.data = wav.data[start*frameSize : end*frameSize]
+	return wav.bytes(), true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// paddedWAV builds a mono 16-bit WAV of silence, a tone and more silence.
+func paddedWAV(rate, lead, tone, tail int) []byte {
+	header := streamingWAVHeader(rate, 1)
+	wav, _ := parseWAV(header)
+	samples := make([]byte, 2*(lead+tone+tail))
+	for i := lead; i < lead+tone; i++ {
+		v := int16(8000)
+		if i%2 == 1 {
+			v = -8000
+		}
+		binary.LittleEndian.PutUint16(samples[2*i:], uint16(v))
+	}
+	wav.data = samples
+	return wav.bytes()
+}
+
+func TestTrimWAVSilence(t *testing.T) {
+	const rate = 16000
+	in := paddedWAV(rate, rate/2, rate/4, rate/2)
+	out, ok := trimWAVSilence(in, -50, time.Second)
+	if !ok {
+		t.Fatal("16-bit PCM WAV not trimmed")
+	}
+	before, _ := audioDuration(in, "audio/wav")
+	after, _ := audioDuration(out, "audio/wav")
+	if after >= before {
+		t.Fatalf("trimmed clip is %v, original %v", after, before)
+	}
+	if after != 250*time.Millisecond {
+		t.Errorf("trimmed clip is %v, want the 250ms tone", after)
+	}
+
+	// Trimming stops at the cap, leaving the rest of the padding.
+	out, _ = trimWAVSilence(in, -50, 100*time.Millisecond)
+	if got, _ := audioDuration(out, "audio/wav"); got != 1050*time.Millisecond {
+		t.Errorf("capped trim left %v, want 1.05s", got)
+	}
+
+	// A silent clip is left alone rather than trimmed to nothing.
+	silent := paddedWAV(rate, rate, 0, 0)
+	out, _ = trimWAVSilence(silent, -50, 2*time.Second)
+	if len(out) != len(silent) {
+		t.Errorf("silent clip trimmed from %d to %d bytes", len(silent), len(out))
+	}
+}
+
+func TestVersePausesSplicedAsSilence(t *testing.T) {
+	chunks, err := splitPadas("धर्मक्षेत्रे कुरुक्षेत्रे समवेता युयुत्सवः। मामकाः पाण्डवाश्चैव किमकुर्वत सञ्जय॥ १ ॥", 800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"धर्मक्षेत्रे कुरुक्षेत्रे समवेता युयुत्सवः।", "मामकाः पाण्डवाश्चैव किमकुर्वत सञ्जय॥", "१ ॥"}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Fatalf("padas = %q, want %q", chunks, want)
+	}
+
+	const rate = 16000
+	tone := paddedWAV(rate, 0, rate/4, 0)
+	joined, err := concatWAV([][]byte{tone, tone}, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := audioDuration(joined, "audio/wav"); got != 800*time.Millisecond {
+		t.Errorf("joined clip is %v, want two 250ms tones and a 300ms pause", got)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// startCmd starts a command; tests replace it to simulate fork failures.
+var startCmd = (*exec.Cmd).Start
+
+// spawnBackoff is the wait before the first retry of a failed spawn; it
+// doubles for each further attempt.
+const spawnBackoff = 50 * time.Millisecond
+
+// transientSpawnError reports whether err is a fork or exec failure that may
+// pass once load drops (EAGAIN, ENOMEM), as opposed to a missing binary or
+// bad arguments, which would fail the same way again.
+func transientSpawnError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOMEM)
+}
+
+// startWithRetry starts the command newCmd builds and returns it with its
+// stdout, retrying up to retries times with backoff when the spawn fails
+// transiently. Nothing has been written to the client yet when it retries,
+// since the process never started.
+func startWithRetry(ctx context.Context, name string, retries int, newCmd func() *exec.Cmd) (*exec.Cmd, io.ReadCloser, error) {
+	backoff := spawnBackoff
+	for attempt := 1; ; attempt++ {
+		cmd := newCmd()
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		// A failed Start closes the pipe, so each attempt gets a fresh one.
+		err = startCmd(cmd)
+		if err == nil {
+			return cmd, stdout, nil
+		}
+		if attempt > retries || !transientSpawnError(err) {
+			return nil, nil, err
+		}
+		logFrom(ctx).Warn("spawn failed, retrying", "bin", name, "attempt", attempt, "backoff", backoff.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
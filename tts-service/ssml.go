@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isSSML reports whether text looks like an SSML document (a <speak> root).
+func isSSML(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), "<speak")
+}
+
+// validateSSML checks that text is well-formed XML with a <speak> root.
+func validateSSML(text string) error {
+	dec := xml.NewDecoder(strings.NewReader(text))
+	sawRoot := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok && !sawRoot {
+			if start.Name.Local != "speak" {
+				return errors.New("root element must be <speak>")
+			}
+			sawRoot = true
+		}
+	}
+	if !sawRoot {
+		return errors.New("missing <speak> root element")
+	}
+	return nil
+}
+
+// ssmlBreakProviders honour SSML <break>s natively, so verse pauses are sent
+// to them as markup; other providers have silence spliced between padas.
+var ssmlBreakProviders = map[string]bool{"polly": true, "azure": true}
+
+// versePause is the pause after each danda in verse granularity:
+// TTS_VERSE_PAUSE_MS, or TTS_VERSE_BREAK when TTS_VERSE_BREAKS is set.
+// Zero disables verse pauses.
+func versePause() time.Duration {
+	if ms := envInt("TTS_VERSE_PAUSE_MS", 0); ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	if envBool("TTS_VERSE_BREAKS", false) {
+		return envDuration("TTS_VERSE_BREAK", 400*time.Millisecond)
+	}
+	return 0
+}
+
+// insertVerseBreaks turns plain text into SSML with a pause after every
+// danda, so padas of a verse are read with a breath between them.
+func insertVerseBreaks(text string, pause time.Duration) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	brk := `<break time="` + strconv.FormatInt(pause.Milliseconds(), 10) + `ms"/>`
+	escaped := b.String()
+	escaped = strings.ReplaceAll(escaped, "॥", "॥"+brk)
+	escaped = strings.ReplaceAll(escaped, "।", "।"+brk)
+	return "<speak>" + escaped + "</speak>"
+}
+
+// ssmlToText flattens SSML into the text it speaks, calling onBreak for each
+// <break> so providers without SSML support can substitute their own pause
+// markup. Unknown tags are dropped and their text kept.
+func ssmlToText(ssml string, onBreak func(time.Duration) string) string {
+	var b strings.Builder
+	dec := xml.NewDecoder(strings.NewReader(ssml))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.StartElement:
+			if t.Name.Local == "break" && onBreak != nil {
+				b.WriteString(onBreak(breakDuration(t)))
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// breakDuration reads the time (or strength) of a <break> element.
+func breakDuration(el xml.StartElement) time.Duration {
+	for _, a := range el.Attr {
+		switch a.Name.Local {
+		case "time":
+			if d, err := time.ParseDuration(a.Value); err == nil {
+				return d
+			}
+		case "strength":
+			switch a.Value {
+			case "none":
+				return 0
+			case "x-weak":
+				return 100 * time.Millisecond
+			case "weak":
+				return 250 * time.Millisecond
+			case "strong":
+				return 750 * time.Millisecond
+			case "x-strong":
+				return time.Second
+			}
+		}
+	}
+	return 500 * time.Millisecond
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+)
+
+// ssmlUploadOverhead allows for multipart boundaries and part headers on top
+// of the document itself.
+const ssmlUploadOverhead = 4 << 10
+
+// xmlDeclaration matches a leading <?xml ...?> declaration, which providers
+// that embed the document in their own request would choke on.
+var xmlDeclaration = regexp.MustCompile(`^\s*<\?xml[^>]*\?>`)
+
+// handleTTSSSML synthesizes an SSML document sent as the request body
+// (text/xml, application/xml or application/ssml+xml) or as the "file" part
+// of a multipart/form-data upload. The other options come from the query
+// string, as for GET /api/tts; the audio is returned the same way.
+func handleTTSSSML(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	serveTTS(w, r, decodeSSMLRequest)
+}
+
+// decodeSSMLRequest reads the document for handleTTSSSML, capped at
+// TTS_MAX_SSML_BYTES (default TTS_MAX_BODY_BYTES). The document is
+// validated with the rest of the request in prepareTTS, so malformed XML is
+// answered with invalid_ssml like an inline document.
+func decodeSSMLRequest(w http.ResponseWriter, r *http.Request) (ttsRequest, int, string, string) {
+	var req ttsRequest
+	if err := queryRequest(r.URL.Query(), &req); err != nil {
+		return req, http.StatusBadRequest, "invalid_query", err.Error()
+	}
+	if req.Text != "" || len(req.Texts) > 0 {
+		return req, http.StatusBadRequest, "invalid_query", "text comes from the SSML document, not the query"
+	}
+
+	limit := envInt64("TTS_MAX_SSML_BYTES", envInt64("TTS_MAX_BODY_BYTES", defaultMaxBodyBytes))
+	tooLarge := fmt.Sprintf("SSML document exceeds %d bytes", limit)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var doc []byte
+	switch mediaType {
+	case "text/xml", "application/xml", "application/ssml+xml":
+		var err error
+		doc, err = io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return req, http.StatusRequestEntityTooLarge, "body_too_large", tooLarge
+			}
+			return req, http.StatusBadRequest, "invalid_body", "could not read request body"
+		}
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, limit+ssmlUploadOverhead)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return req, http.StatusBadRequest, "invalid_body", "invalid multipart body"
+		}
+		for doc == nil {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return req, http.StatusBadRequest, "text_required", `multipart body has no "file" part`
+			}
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					return req, http.StatusRequestEntityTooLarge, "body_too_large", tooLarge
+				}
+				return req, http.StatusBadRequest, "invalid_body", "invalid multipart body"
+			}
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+			doc, err = io.ReadAll(io.LimitReader(part, limit+1))
+			part.Close()
+			var maxErr *http.MaxBytesError
+			if int64(len(doc)) > limit || errors.As(err, &maxErr) {
+				return req, http.StatusRequestEntityTooLarge, "body_too_large", tooLarge
+			}
+			if err != nil {
+				return req, http.StatusBadRequest, "invalid_body", "invalid multipart body"
+			}
+		}
+	default:
+		return req, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			"send the SSML document as text/xml, application/ssml+xml or a multipart/form-data \"file\" part"
+	}
+
+	doc = bytes.TrimPrefix(doc, []byte("\ufeff"))
+	doc = xmlDeclaration.ReplaceAll(doc, nil)
+	req.Text = string(bytes.TrimSpace(doc))
+	req.SSML = true
+	return req, 0, "", ""
+}
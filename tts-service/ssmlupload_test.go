@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSMLUpload(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	t.Setenv("TTS_MAX_SSML_BYTES", "256")
+	withCache(t, newAudioCache(16, 1<<20))
+	var got ttsRequest
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		got = req
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(pcmToWAV(make([]byte, 100), 8000, 1))
+		return err
+	})
+
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<speak>राम <break time="200ms"/> नाम</speak>`
+	upload := func(field, content string) (*bytes.Buffer, string) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("note", "ignored")
+		fw, _ := mw.CreateFormFile(field, "verse.ssml")
+		fw.Write([]byte(content))
+		mw.Close()
+		return &body, mw.FormDataContentType()
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        func() (*bytes.Buffer, string)
+		status      int
+		code        string
+	}{
+		{"xml body", http.MethodPost, "application/ssml+xml", func() (*bytes.Buffer, string) { return bytes.NewBufferString(doc), "" }, http.StatusOK, ""},
+		{"multipart", http.MethodPost, "", func() (*bytes.Buffer, string) {
+			return upload("file", strings.Replace(doc, "नाम", "सत्य", 1))
+		}, http.StatusOK, ""},
+		{"no file part", http.MethodPost, "", func() (*bytes.Buffer, string) { return upload("other", doc) }, http.StatusBadRequest, "text_required"},
+		{"malformed", http.MethodPost, "text/xml", func() (*bytes.Buffer, string) { return bytes.NewBufferString("<speak>राम"), "" }, http.StatusBadRequest, "invalid_ssml"},
+		{"too large", http.MethodPost, "text/xml", func() (*bytes.Buffer, string) {
+			return bytes.NewBufferString("<speak>" + strings.Repeat("राम ", 40) + "</speak>"), ""
+		}, http.StatusRequestEntityTooLarge, "body_too_large"},
+		{"upload too large", http.MethodPost, "", func() (*bytes.Buffer, string) {
+			return upload("file", "<speak>"+strings.Repeat("राम ", 40)+"</speak>")
+		}, http.StatusRequestEntityTooLarge, "body_too_large"},
+		{"json", http.MethodPost, "application/json", func() (*bytes.Buffer, string) { return bytes.NewBufferString(`{"text":"राम"}`), "" }, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"get", http.MethodGet, "", func() (*bytes.Buffer, string) { return &bytes.Buffer{}, "" }, http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ttsRequest{}
+			body, multipartType := tt.body()
+			r := httptest.NewRequest(tt.method, "/api/tts/ssml?lang=deva", body)
+			r.Header.Set("Content-Type", tt.contentType)
+			if multipartType != "" {
+				r.Header.Set("Content-Type", multipartType)
+			}
+			rec := httptest.NewRecorder()
+			handleTTSSSML(rec, r)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				if !strings.Contains(rec.Body.String(), `"`+tt.code+`"`) {
+					t.Errorf("body %s, want %s", rec.Body, tt.code)
+				}
+				return
+			}
+			if !got.SSML || !strings.HasPrefix(got.Text, "<speak>") || got.Lang != "deva" {
+				t.Errorf("synthesized %+v, want the SSML document without its declaration", got)
+			}
+		})
+	}
+}
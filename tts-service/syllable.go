@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Devanagari character classes used to find akshara boundaries.
+const (
+	devaNukta  = '़'
+	devaVirama = '्'
+	zwnj       = '\u200c'
+	zwj        = '\u200d'
+)
+
+// isDevaConsonant reports whether r is a Devanagari consonant, including the
+// precomposed nukta forms U+0958..U+095F that unnormalized input may carry.
+func isDevaConsonant(r rune) bool {
+	return (r >= 'क' && r <= 'ह') || (r >= '\u0958' && r <= '\u095f') || (r >= 'ॸ' && r <= 'ॿ')
+}
+
+// isDevaSign reports whether r is a sign that belongs to the akshara before
+// it: a matra, nukta, virama, candrabindu, anusvara or visarga.
+func isDevaSign(r rune) bool {
+	switch {
+	case r >= 'ऀ' && r <= 'ः': // inverted candrabindu, candrabindu, anusvara, visarga
+		return true
+	case r >= 'ऺ' && r <= 'ॏ' && r != 'ऽ': // matras, nukta, virama; not avagraha
+		return true
+	case r >= '॑' && r <= 'ॗ', r == 'ॢ', r == 'ॣ': // svara marks, vocalic l/ll matras
+		return true
+	}
+	return false
+}
+
+// isDevanagari reports whether r is in the Devanagari block.
+func isDevanagari(r rune) bool {
+	return r >= 'ऀ' && r <= 'ॿ'
+}
+
+// aksharas splits a Devanagari word into aksharas, the written syllables a
+// learner sounds out: a consonant cluster joined by viramas with its matra
+// and any candrabindu, anusvara or visarga (स्ते, त्रि, क्ष्म्यः), or an
+// independent vowel with its signs (ऋ, अं). This is the extended grapheme
+// cluster of Unicode 15.1 (UAX #29 rule GB9c, which keeps virama-linked
+// conjuncts together); Go's standard library has no segmenter for it.
+//
+// A ZWJ or ZWNJ after a virama only changes how the conjunct is drawn, so it
+// stays in the cluster. A word-final dead consonant (the न् of भगवान्) has no
+// vowel to carry it and is read with the akshara before it. Anything outside
+// Devanagari is returned as one piece.
+func aksharas(word string) []string {
+	var out []string
+	var cur []rune
+	linked := false // the last consonant took a virama and waits for the next
+	flush := func() {
+		if len(cur) > 0 {
+			out = append(out, string(cur))
+			cur = nil
+		}
+	}
+	for _, r := range word {
+		switch {
+		case isDevaConsonant(r):
+			if !linked {
+				flush()
+			}
+			linked = false
+		case isDevaSign(r):
+			linked = r == devaVirama || (linked && r == devaNukta)
+		case (r == zwj || r == zwnj) && linked:
+		case isDevanagari(r):
+			// Independent vowels, om, avagraha and digits start their own akshara.
+			flush()
+			linked = false
+		default:
+			if len(cur) > 0 && !isDevanagari(cur[len(cur)-1]) {
+				break
+			}
+			flush()
+			linked = false
+		}
+		cur = append(cur, r)
+	}
+	flush()
+
+	// Join a trailing dead consonant to the akshara it closes.
+	if n := len(out); n > 1 && isDeadConsonant(out[n-1]) {
+		out[n-2] += out[n-1]
+		out = out[:n-1]
+	}
+	return out
+}
+
+// isDeadConsonant reports whether a is a consonant (cluster) ending in a
+// virama, with no vowel of its own.
+func isDeadConsonant(a string) bool {
+	a = strings.TrimRight(a, string([]rune{zwj, zwnj}))
+	return strings.HasSuffix(a, string(devaVirama)) && isDevaConsonant([]rune(a)[0])
+}
+
+// syllables splits text into aksharas word by word. Punctuation such as
+// dandas is dropped; it has nothing to read on its own.
+func syllables(text string) []string {
+	var out []string
+	for _, word := range strings.Fields(text) {
+		for _, a := range aksharas(word) {
+			if strings.Trim(a, "।॥,.;:!?'\"()-") != "" {
+				out = append(out, a)
+			}
+		}
+	}
+	return out
+}
+
+// syllablePause is the silence between aksharas for syllable granularity
+// (TTS_SYLLABLE_PAUSE_MS, default 250).
+func syllablePause() time.Duration {
+	return time.Duration(clampSilencePad(envInt("TTS_SYLLABLE_PAUSE_MS", 250))) * time.Millisecond
+}
+
+// splitSyllables makes each akshara of text its own chunk, so it is
+// synthesized alone and joined with syllablePause between. Every akshara is
+// one provider call, so TTS_MAX_SYLLABLES (default 200) caps how many a
+// request may have.
+func splitSyllables(text string) ([]string, *requestError) {
+	chunks := syllables(text)
+	if len(chunks) == 0 {
+		return nil, fieldError("text", "text_required", "text has no syllables to read")
+	}
+	if limit := envInt("TTS_MAX_SYLLABLES", 200); limit > 0 && len(chunks) > limit {
+		return nil, &requestError{http.StatusBadRequest, apiError{
+			Code:    "text_too_long",
+			Message: fmt.Sprintf("text has %d syllables; syllable granularity allows at most %d", len(chunks), limit),
+			Field:   "text",
+		}}
+	}
+	return chunks, nil
+}
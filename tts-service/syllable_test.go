@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAksharas(t *testing.T) {
+	for _, tc := range []struct {
+		word string
+		want []string
+	}{
+		{"राम", []string{"रा", "म"}},
+		{"नमस्ते", []string{"न", "म", "स्ते"}},
+		{"क्षत्रिय", []string{"क्ष", "त्रि", "य"}},
+		{"श्रीमद्भगवद्गीता", []string{"श्री", "म", "द्भ", "ग", "व", "द्गी", "ता"}},
+		{"संस्कृतम्", []string{"सं", "स्कृ", "तम्"}},
+		{"भगवान्", []string{"भ", "ग", "वान्"}},
+		{"अर्जुन", []string{"अ", "र्जु", "न"}},
+		{"ऋषिः", []string{"ऋ", "षिः"}},
+		{"लक्ष्म्यै", []string{"ल", "क्ष्म्यै"}},
+		{"चाँद", []string{"चाँ", "द"}},
+		{"ज़िंदगी", []string{"ज़िं", "द", "गी"}},
+		{"ॐ", []string{"ॐ"}},
+		{"सोऽहम्", []string{"सो", "ऽ", "हम्"}},
+		{"क्\u200dष", []string{"क्\u200dष"}},
+		{"rama", []string{"rama"}},
+	} {
+		if got := aksharas(tc.word); !slices.Equal(got, tc.want) {
+			t.Errorf("aksharas(%q) = %q, want %q", tc.word, got, tc.want)
+		}
+	}
+
+	if got, want := syllables("धर्मक्षेत्रे कुरुक्षेत्रे ।"), []string{"ध", "र्म", "क्षे", "त्रे", "कु", "रु", "क्षे", "त्रे"}; !slices.Equal(got, want) {
+		t.Errorf("syllables = %q, want %q", got, want)
+	}
+}
+
+func TestSyllableGranularity(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "mac")
+	t.Setenv("TTS_SYLLABLE_PAUSE_MS", "10")
+	withCache(t, newAudioCache(0, 0))
+	var spoken []string
+	stubSynthesizer(t, "mac", func(ctx context.Context, w http.ResponseWriter, text string, req ttsRequest) error {
+		spoken = append(spoken, text)
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err := w.Write(pcmToWAV(make([]byte, 100), 8000, 1))
+		return err
+	})
+
+	rec := httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"नमस्ते","lang":"deva","granularity":"syllable"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if want := []string{"न", "म", "स्ते"}; !slices.Equal(spoken, want) {
+		t.Errorf("spoken = %q, want %q", spoken, want)
+	}
+	wav, err := parseWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three 100-byte clips and two 10ms gaps of 8 kHz 16-bit silence.
+	if want := 3*100 + 2*160; len(wav.data) != want {
+		t.Errorf("joined data = %d bytes, want %d", len(wav.data), want)
+	}
+
+	t.Setenv("TTS_MAX_SYLLABLES", "2")
+	rec = httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"नमस्ते","lang":"deva","granularity":"syllable"}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("over TTS_MAX_SYLLABLES: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+)
+
+// synthesisInfo records what actually produced a clip: the provider and the
+// voice, language code and sample rate it resolved, which can differ from
+// what the request asked for (voice config, env overrides, fallbacks).
+type synthesisInfo struct {
+	Provider     string
+	Voice        string
+	LanguageCode string
+	SampleRate   int
+}
+
+type synthesisInfoKey struct{}
+
+// withSynthesisInfo returns a context that synthesizers running under it
+// report into, and the info they fill in.
+func withSynthesisInfo(ctx context.Context) (context.Context, *synthesisInfo) {
+	info := &synthesisInfo{}
+	return context.WithValue(ctx, synthesisInfoKey{}, info), info
+}
+
+// reportVoice is called by synthesizers once they have resolved their voice,
+// before writing any audio. languageCode and sampleRate may be empty when the
+// provider doesn't take or report them; the sample rate is then read from
+// the rendered audio.
+func reportVoice(ctx context.Context, voice, languageCode string, sampleRate int) {
+	if info, ok := ctx.Value(synthesisInfoKey{}).(*synthesisInfo); ok {
+		info.Voice, info.LanguageCode = voice, languageCode
+		if info.SampleRate == 0 {
+			info.SampleRate = sampleRate
+		}
+	}
+}
+
+// startSynthesis resets the info for an attempt with provider, so a failed
+// attempt earlier in the fallback chain doesn't leak its voice. A requested
+// sample rate, which the output is resampled to, wins over the provider's.
+func startSynthesis(ctx context.Context, provider string, sampleRate int) {
+	if info, ok := ctx.Value(synthesisInfoKey{}).(*synthesisInfo); ok {
+		*info = synthesisInfo{Provider: provider, SampleRate: sampleRate}
+	}
+}
+
+// completeInfo fills in the sample rate from the rendered clip when the
+// provider didn't report it.
+func completeInfo(info synthesisInfo, data []byte, contentType string) synthesisInfo {
+	if info.SampleRate == 0 {
+		info.SampleRate, _ = clipSampleRate(data, contentType)
+	}
+	return info
+}
+
+// setSynthesisHeaders reports info as X-TTS-* response headers.
+func setSynthesisHeaders(h http.Header, info synthesisInfo) {
+	if info.Provider != "" {
+		h.Set("X-TTS-Provider", info.Provider)
+	}
+	if info.Voice != "" {
+		h.Set("X-TTS-Voice", info.Voice)
+	}
+	if info.LanguageCode != "" {
+		h.Set("X-TTS-Language-Code", info.LanguageCode)
+	}
+	if info.SampleRate != 0 {
+		h.Set("X-TTS-Sample-Rate", strconv.Itoa(info.SampleRate))
+	}
+}
+
+// logAttrs returns info as structured log fields.
+func (info synthesisInfo) logAttrs() []any {
+	return []any{"voice", info.Voice, "languageCode", info.LanguageCode, "sampleRate", info.SampleRate}
+}
+
+// clipSampleRate reads the sample rate of a WAV or MP3 clip.
+func clipSampleRate(data []byte, contentType string) (int, bool) {
+	switch contentType {
+	case audioContentTypes["wav"]:
+		if wav, err := parseWAV(data); err == nil && len(wav.format) >= 8 {
+			return int(binary.LittleEndian.Uint32(wav.format[4:8])), true
+		}
+	case audioContentTypes["mp3"]:
+		data = stripID3v2(data)
+		for off := 0; off+4 <= len(data); off++ {
+			if f, ok := parseMP3Frame(data[off:]); ok {
+				return f.sampleRate, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errSynthesisTimeout marks a synthesis cut off by its deadline.
+var errSynthesisTimeout = errors.New("synthesis timed out")
+
+// synthesisTimeout is the deadline for synthesizing text split into chunks
+// with provider. TTS_TIMEOUT (default 15s) is the budget per chunk, which
+// TTS_TIMEOUT_<PROVIDER> (e.g. TTS_TIMEOUT_SARVAM) overrides, so long
+// chunked passages get proportionally longer than a single word.
+func synthesisTimeout(provider string, chunks int) time.Duration {
+	base := envDuration("TTS_TIMEOUT", 15*time.Second)
+	base = envDuration("TTS_TIMEOUT_"+strings.ToUpper(provider), base)
+	return base * time.Duration(max(1, chunks))
+}
+
+// timeoutError wraps err with errSynthesisTimeout when ctx hit its deadline,
+// since providers report the cancellation in their own ways (a killed
+// process, a failed HTTP call).
+func timeoutError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", errSynthesisTimeout, err)
+	}
+	return err
+}
+
+// writeTimeout is the server's WriteTimeout (TTS_WRITE_TIMEOUT, default
+// 30s; 0 disables it). The server starts it when the request headers have
+// been read, which would cut off long passages and streams that are sent
+// while they are synthesized, so the synthesis handlers move the deadline
+// with extendWriteDeadline: handleTTS to the synthesis deadline plus
+// writeTimeout before it starts, and handlers that only write once
+// synthesis is done to writeTimeout from then. A stalled client therefore
+// holds a connection for at most the synthesis deadline plus writeTimeout.
+func writeTimeout() time.Duration {
+	return envDuration("TTS_WRITE_TIMEOUT", 30*time.Second)
+}
+
+// extendWriteDeadline gives the response synthesis plus writeTimeout from
+// now to be written. Writers that can't take a deadline (tests) are left
+// alone.
+func extendWriteDeadline(w http.ResponseWriter, synthesis time.Duration) {
+	if wt := writeTimeout(); wt > 0 {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(synthesis + wt))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// timepoint marks when a word starts within a clip.
+type timepoint struct {
+	Word        string  `json:"word"`
+	TimeSeconds float64 `json:"timeSeconds"`
+}
+
+// audioEnvelope is the JSON form of an audio response. Timepoints are only
+// filled for word granularity.
+type audioEnvelope struct {
+	ContentType string      `json:"contentType"`
+	DurationMs  int64       `json:"durationMs,omitempty"`
+	Audio       string      `json:"audio"`
+	Provider    string      `json:"provider"`
+	Timepoints  []timepoint `json:"timepoints,omitempty"`
+}
+
+// acceptsJSON reports whether the Accept header asks for a JSON envelope.
+func acceptsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// estimateTimepoints spreads duration across the words of text in proportion
+// to their length. None of our providers report word boundaries, so this
+// mirrors the estimate the frontend used before server timepoints existed.
+func estimateTimepoints(text string, duration time.Duration) []timepoint {
+	words := strings.Fields(text)
+	total := 0
+	for _, w := range words {
+		total += len([]rune(w))
+	}
+	if total == 0 || duration <= 0 {
+		return nil
+	}
+
+	points := make([]timepoint, len(words))
+	elapsed := 0
+	for i, w := range words {
+		points[i] = timepoint{Word: w, TimeSeconds: duration.Seconds() * float64(elapsed) / float64(total)}
+		elapsed += len([]rune(w))
+	}
+	return points
+}
+
+// writeAudioJSON writes entry as a JSON envelope, with estimated word
+// timepoints for spokenText when it is not empty.
+func writeAudioJSON(w http.ResponseWriter, entry *cachedAudio, spokenText string) {
+	env := audioEnvelope{
+		ContentType: entry.contentType,
+		Audio:       base64.StdEncoding.EncodeToString(entry.data),
+		Provider:    entry.provider,
+	}
+	if d, ok := audioDuration(entry.data, entry.contentType); ok {
+		env.DurationMs = d.Milliseconds()
+		env.Timepoints = estimateTimepoints(spokenText, d)
+	}
+	writeJSON(w, http.StatusOK, env)
+}
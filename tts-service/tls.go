@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// listenAddr is TTS_LISTEN: a TCP address such as ":8081" or
+// "127.0.0.1:8081", or "unix:/run/tts.sock" for a Unix socket. Unset, the
+// service listens on all interfaces at TTS_PORT (default 8081).
+func listenAddr() string {
+	if addr := os.Getenv("TTS_LISTEN"); addr != "" {
+		return addr
+	}
+	return ":" + envString("TTS_PORT", "8081")
+}
+
+// newListener listens on addr. A Unix socket replaces a stale socket file
+// left by an unclean exit, is made accessible per TTS_LISTEN_MODE (octal,
+// default 0660) so a proxy in the same group can connect, and is removed
+// when the listener is closed at shutdown.
+func newListener(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := strconv.ParseUint(envString("TTS_LISTEN_MODE", "0660"), 8, 32)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("invalid TTS_LISTEN_MODE: %w", err)
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(true)
+	return l, nil
+}
+
+// listen serves server on listenAddr over TLS when configured, else plain
+// HTTP:
+//
+//   - TTS_TLS_AUTOCERT_DOMAINS (comma-separated) obtains certificates for
+//     those domains from Let's Encrypt, cached in TTS_TLS_AUTOCERT_CACHE
+//     (default ./autocert-cache);
+//   - TTS_TLS_CERT and TTS_TLS_KEY serve a certificate from files.
+//
+// With TLS on TCP, a second server on TTS_HTTP_REDIRECT_PORT (default 80,
+// "0" disables it) redirects plain HTTP to HTTPS and answers autocert's ACME
+// challenges. It is returned so main can shut it down; nil otherwise.
+func listen(server *http.Server, errCh chan<- error) *http.Server {
+	l, err := newListener(server.Addr)
+	if err != nil {
+		errCh <- err
+		return nil
+	}
+
+	cert, key := os.Getenv("TTS_TLS_CERT"), os.Getenv("TTS_TLS_KEY")
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("TTS_TLS_AUTOCERT_DOMAINS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+
+	if len(domains) == 0 && (cert == "" || key == "") {
+		if cert != "" || key != "" {
+			slog.Warn("TLS needs both TTS_TLS_CERT and TTS_TLS_KEY; serving plain HTTP")
+		}
+		go func() {
+			slog.Info("tts-service listening", "addr", server.Addr)
+			errCh <- server.Serve(l)
+		}()
+		return nil
+	}
+
+	redirect := httpsRedirect(server.Addr)
+	if len(domains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(envString("TTS_TLS_AUTOCERT_CACHE", "autocert-cache")),
+		}
+		server.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+		cert, key = "", ""
+	}
+	go func() {
+		slog.Info("tts-service listening with TLS", "addr", server.Addr, "autocert_domains", domains)
+		errCh <- server.ServeTLS(l, cert, key)
+	}()
+
+	port := envString("TTS_HTTP_REDIRECT_PORT", "80")
+	if port == "0" || strings.HasPrefix(server.Addr, "unix:") {
+		return nil
+	}
+	plain := &http.Server{
+		Addr:              ":" + port,
+		Handler:           redirect,
+		ReadHeaderTimeout: server.ReadHeaderTimeout,
+		ReadTimeout:       server.ReadTimeout,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       server.IdleTimeout,
+	}
+	go func() {
+		slog.Info("redirecting HTTP to HTTPS", "addr", plain.Addr)
+		if err := plain.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP redirect server error", "err", err)
+		}
+	}()
+	return plain
+}
+
+// httpsRedirect permanently redirects requests to the same URL over HTTPS
+// on the port of tlsAddr. 308 keeps POST bodies on the redirected request.
+func httpsRedirect(tlsAddr string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != "" && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
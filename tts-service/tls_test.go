@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		tlsAddr, host, want string
+	}{
+		{":443", "tts.example.org", "https://tts.example.org/api/tts?text=x"},
+		{":443", "tts.example.org:80", "https://tts.example.org/api/tts?text=x"},
+		{":8443", "tts.example.org", "https://tts.example.org:8443/api/tts?text=x"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tt.host+"/api/tts?text=x", nil)
+		rec := httptest.NewRecorder()
+		httpsRedirect(tt.tlsAddr).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s via %s: %d %q, want 308 %q", tt.host, tt.tlsAddr, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	if got := listenAddr(); got != ":8081" {
+		t.Errorf("default listen address %q", got)
+	}
+	t.Setenv("TTS_PORT", "9000")
+	if got := listenAddr(); got != ":9000" {
+		t.Errorf("TTS_PORT listen address %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "tts.sock")
+	t.Setenv("TTS_LISTEN", "unix:"+path)
+	// A socket left behind by an unclean exit is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := newListener(listenAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o660 {
+		t.Fatalf("socket mode: %v %v", fi, err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })}
+	go srv.Serve(l)
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}}}
+	resp, err := client.Get("http://tts/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("body %q", body)
+	}
+
+	srv.Shutdown(context.Background())
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed on shutdown: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// scratch is the service's private temp directory, created under
+// TTS_TMP_DIR (default the OS temp dir) at startup and removed at shutdown,
+// so temp files never collide with other processes and anything a killed
+// request left behind goes with it.
+var scratch struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// initScratchDir creates the service's temp directory.
+func initScratchDir() error {
+	base := envString("TTS_TMP_DIR", os.TempDir())
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(base, "tts-service-*")
+	if err != nil {
+		return err
+	}
+	scratch.mu.Lock()
+	scratch.dir = dir
+	scratch.mu.Unlock()
+	return nil
+}
+
+// removeScratchDir deletes the service's temp directory and everything in it.
+func removeScratchDir() {
+	scratch.mu.Lock()
+	dir := scratch.dir
+	scratch.dir = ""
+	scratch.mu.Unlock()
+	if dir == "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		slog.Warn("temp dir not removed", "dir", dir, "err", err)
+	}
+}
+
+// requestTempDir creates a directory for one synthesis's temp files. The
+// returned cleanup removes it and must be deferred, so it runs on errors and
+// timeouts too.
+func requestTempDir(ctx context.Context) (string, func(), error) {
+	scratch.mu.Lock()
+	base := scratch.dir
+	scratch.mu.Unlock()
+	if base == "" {
+		base = envString("TTS_TMP_DIR", os.TempDir())
+	}
+	dir, err := os.MkdirTemp(base, "req-*")
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logFrom(ctx).Warn("temp files not removed", "dir", dir, "err", err)
+		}
+	}, nil
+}
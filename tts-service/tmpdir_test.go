@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScratchDirLifecycle(t *testing.T) {
+	base := t.TempDir()
+	t.Setenv("TTS_TMP_DIR", filepath.Join(base, "tmp"))
+	if err := initScratchDir(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(removeScratchDir)
+
+	dir, cleanup, err := requestTempDir(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(filepath.Dir(dir)) != filepath.Join(base, "tmp") {
+		t.Errorf("request dir %s not under the service temp dir", dir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "say.wav"), []byte("RIFF"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("request dir still exists: %v", err)
+	}
+
+	// A request killed before its cleanup ran leaves files behind; shutdown
+	// removes them with the service's directory.
+	orphan, _, err := requestTempDir(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeScratchDir()
+	if _, err := os.Stat(filepath.Dir(orphan)); !os.IsNotExist(err) {
+		t.Errorf("service temp dir still exists: %v", err)
+	}
+}
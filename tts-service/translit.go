@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// IAST to Devanagari tables. Aspirated consonants and the ai/au diphthongs
+// are two-letter tokens and must be matched before their first letter.
+var (
+	iastVowels = map[string][2]string{ // independent form, vowel sign
+		"a": {"अ", ""}, "ā": {"आ", "ा"}, "i": {"इ", "ि"}, "ī": {"ई", "ी"},
+		"u": {"उ", "ु"}, "ū": {"ऊ", "ू"}, "ṛ": {"ऋ", "ृ"}, "ṝ": {"ॠ", "ॄ"},
+		"ḷ": {"ऌ", "ॢ"}, "ḹ": {"ॡ", "ॣ"}, "e": {"ए", "े"}, "ai": {"ऐ", "ै"},
+		"o": {"ओ", "ो"}, "au": {"औ", "ौ"},
+	}
+	iastConsonants = map[string]string{
+		"k": "क", "kh": "ख", "g": "ग", "gh": "घ", "ṅ": "ङ",
+		"c": "च", "ch": "छ", "j": "ज", "jh": "झ", "ñ": "ञ",
+		"ṭ": "ट", "ṭh": "ठ", "ḍ": "ड", "ḍh": "ढ", "ṇ": "ण",
+		"t": "त", "th": "थ", "d": "द", "dh": "ध", "n": "न",
+		"p": "प", "ph": "फ", "b": "ब", "bh": "भ", "m": "म",
+		"y": "य", "r": "र", "l": "ल", "v": "व",
+		"ś": "श", "ṣ": "ष", "s": "स", "h": "ह", "ḻ": "ळ",
+	}
+	iastMarks = map[string]string{
+		"ṃ": "ं", "ṁ": "ं", "m̐": "ँ", "ḥ": "ः", "'": "ऽ", "’": "ऽ",
+		"||": "॥", "|": "।",
+		"0": "०", "1": "१", "2": "२", "3": "३", "4": "४",
+		"5": "५", "6": "६", "7": "७", "8": "८", "9": "९",
+	}
+
+	// iastDecomposed folds combining-mark spellings to the precomposed
+	// letters the tables use.
+	iastDecomposed = strings.NewReplacer(
+		"a\u0304", "ā", "i\u0304", "ī", "u\u0304", "ū", "r\u0323\u0304", "ṝ",
+		"r\u0323", "ṛ", "l\u0323\u0304", "ḹ", "l\u0323", "ḷ", "n\u0307", "ṅ",
+		"n\u0303", "ñ", "t\u0323", "ṭ", "d\u0323", "ḍ", "n\u0323", "ṇ",
+		"s\u0301", "ś", "s\u0323", "ṣ", "m\u0323", "ṃ", "m\u0307", "ṁ",
+		"h\u0323", "ḥ", "l\u0331", "ḻ",
+	)
+)
+
+const virama = "्"
+
+// iastToDevanagari transliterates IAST text to Devanagari. Consonant clusters
+// become conjuncts, a word-final consonant takes a virama, and characters
+// outside IAST (spaces, punctuation, Devanagari) pass through unchanged.
+func iastToDevanagari(text string) string {
+	text = iastDecomposed.Replace(strings.ToLower(text))
+	var b strings.Builder
+	afterConsonant := false
+	for len(text) > 0 {
+		tok, n := nextIASTToken(text)
+		text = text[n:]
+
+		if v, ok := iastVowels[tok]; ok {
+			if afterConsonant {
+				b.WriteString(v[1])
+			} else {
+				b.WriteString(v[0])
+			}
+			afterConsonant = false
+			continue
+		}
+		if afterConsonant {
+			b.WriteString(virama)
+			afterConsonant = false
+		}
+		if c, ok := iastConsonants[tok]; ok {
+			b.WriteString(c)
+			afterConsonant = true
+		} else if m, ok := iastMarks[tok]; ok {
+			b.WriteString(m)
+		} else {
+			b.WriteString(tok)
+		}
+	}
+	if afterConsonant {
+		b.WriteString(virama)
+	}
+	return b.String()
+}
+
+// nextIASTToken returns the longest IAST token at the start of s (two runes at
+// most), or its first rune, along with its length in bytes.
+func nextIASTToken(s string) (string, int) {
+	_, n1 := utf8.DecodeRuneInString(s)
+	if n1 < len(s) {
+		_, n2 := utf8.DecodeRuneInString(s[n1:])
+		pair := s[:n1+n2]
+		if _, ok := iastVowels[pair]; ok {
+			return pair, n1 + n2
+		}
+		if _, ok := iastConsonants[pair]; ok {
+			return pair, n1 + n2
+		}
+		if _, ok := iastMarks[pair]; ok {
+			return pair, n1 + n2
+		}
+	}
+	return s[:n1], n1
+}
+
+// maxTransliteratedHeaderRunes bounds X-TTS-Transliterated so long inputs
+// don't produce headers proxies reject.
+const maxTransliteratedHeaderRunes = 200
+
+// transliteratedHeader percent-encodes converted text for the
+// X-TTS-Transliterated debugging header, truncated to a safe length.
+func transliteratedHeader(text string) string {
+	if r := []rune(text); len(r) > maxTransliteratedHeaderRunes {
+		text = string(r[:maxTransliteratedHeaderRunes]) + "…"
+	}
+	return url.PathEscape(text)
+}
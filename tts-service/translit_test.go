@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestIASTToDevanagari(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"namaḥ", "नमः"},
+		{"śrī rāmāya namaḥ", "श्री रामाय नमः"},
+		{"kṛṣṇa", "कृष्ण"},
+		{"saṃskṛtam", "संस्कृतम्"},
+		{"dharmakṣetre kurukṣetre", "धर्मक्षेत्रे कुरुक्षेत्रे"},
+		{"aiśvarya", "ऐश्वर्य"},
+		{"tejo'si", "तेजोऽसि"},
+		{"oṃ namaḥ śivāya ||1||", "ओं नमः शिवाय ॥१॥"},
+		{"Gaṇeśa", "गणेश"},
+		{"rāma śiva", "राम शिव"}, // combining marks
+	}
+	for _, tt := range tests {
+		if got := iastToDevanagari(tt.in); got != tt.want {
+			t.Errorf("iastToDevanagari(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// espeakVariants are the voice variants espeak-ng ships that a request may
+// name; they change the timbre of any language's voice.
+var espeakVariants = []string{
+	"m1", "m2", "m3", "m4", "m5", "m6", "m7",
+	"f1", "f2", "f3", "f4", "f5",
+	"croak", "klatt", "klatt2", "klatt3", "whisper", "whisperf",
+}
+
+// genderVariants are the variants used for Gender. espeak's voices are
+// male to begin with, so only female needs one.
+var genderVariants = map[string]string{"male": "", "female": "f3"}
+
+// normalizeVariant lowercases req's Gender and VoiceVariant, dropping a
+// leading "+" from the variant, and checks them against what espeak offers.
+func normalizeVariant(req *ttsRequest) *requestError {
+	req.Gender = strings.ToLower(strings.TrimSpace(req.Gender))
+	req.VoiceVariant = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(req.VoiceVariant)), "+")
+	if _, ok := genderVariants[req.Gender]; req.Gender != "" && !ok {
+		return fieldError("gender", "unsupported_gender", fmt.Sprintf("unsupported gender %q (supported: male, female)", req.Gender))
+	}
+	if req.VoiceVariant != "" && !slices.Contains(espeakVariants, req.VoiceVariant) {
+		return fieldError("voiceVariant", "unsupported_voice_variant",
+			fmt.Sprintf("unsupported voiceVariant %q (supported: %s)", req.VoiceVariant, strings.Join(espeakVariants, ", ")))
+	}
+	return nil
+}
+
+// espeakVariant returns the variant suffix espeak should use for req.
+func espeakVariant(req ttsRequest) string {
+	if req.VoiceVariant != "" {
+		return req.VoiceVariant
+	}
+	return genderVariants[req.Gender]
+}
+
+// withVariant appends variant to an espeak voice as "voice+variant". MBROLA
+// voices and voices already naming a variant are left alone.
+func withVariant(voice, variant string) string {
+	if variant == "" || voice == "" || strings.HasPrefix(voice, "mb-") || strings.Contains(voice, "+") {
+		return voice
+	}
+	return voice + "+" + variant
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultMP3Bitrates are the MP3 renditions offered when TTS_MP3_BITRATES
+// is unset, in kbps: low enough for a weak mobile connection up to
+// near-transparent for speech.
+var defaultMP3Bitrates = []int{48, 96, 128}
+
+// parseMP3Bitrates reads TTS_MP3_BITRATES, a list like "48k,96k,128k" (the
+// k is optional), sorted and without duplicates.
+func parseMP3Bitrates(s string) ([]int, error) {
+	var rates []int
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(f)), "k")
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 8 || n > 320 {
+			return nil, fmt.Errorf("invalid MP3 bitrate %q (8 to 320 kbps)", f)
+		}
+		if !slices.Contains(rates, n) {
+			rates = append(rates, n)
+		}
+	}
+	if len(rates) == 0 {
+		return defaultMP3Bitrates, nil
+	}
+	slices.Sort(rates)
+	return rates, nil
+}
+
+// mp3Bitrates are the configured bitrates, or the defaults when
+// TTS_MP3_BITRATES is invalid; main warns about that at startup.
+func mp3Bitrates() []int {
+	rates, err := parseMP3Bitrates(os.Getenv("TTS_MP3_BITRATES"))
+	if err != nil {
+		return defaultMP3Bitrates
+	}
+	return rates
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
+
+// withBitrate sets the audio bitrate, in kbps, on ffmpeg arguments built by
+// ffmpegArgs. Zero leaves the encoder's default.
+func withBitrate(args []string, kbps int) []string {
+	if kbps == 0 {
+		return args
+	}
+	return slices.Insert(args, len(args)-1, "-b:a", strconv.Itoa(kbps)+"k")
+}
+
+// encodeMP3 transcodes src to MP3 at kbps.
+func encodeMP3(ctx context.Context, dst io.Writer, src io.Reader, kbps int) error {
+	return runFFmpeg(ctx, dst, src, withBitrate(ffmpegArgs("mp3", "", 0, 0), kbps), "mp3")
+}
+
+// audioVariant is one rendition in a variants manifest.
+type audioVariant struct {
+	Bitrate     int    `json:"bitrate"` // kbps
+	URL         string `json:"url"`     // GET /api/tts for this rendition, answered from the cache
+	ContentType string `json:"contentType"`
+	Bytes       int    `json:"bytes"`
+}
+
+type variantsResponse struct {
+	Provider   string         `json:"provider"`
+	DurationMs int64          `json:"durationMs,omitempty"`
+	Variants   []audioVariant `json:"variants"`
+}
+
+// handleTTSVariants renders a clip once, encodes it as MP3 at each of
+// TTS_MP3_BITRATES and caches every rendition under the key GET /api/tts
+// gives it with that bitrate. It answers with a manifest linking to them,
+// so adaptive clients can pick one for their connection. It takes the same
+// fields as /api/tts except bitrate, and format must be mp3 if given.
+func handleTTSVariants(w http.ResponseWriter, r *http.Request) {
+	if reqID := r.Header.Get("X-Request-Id"); reqID != "" {
+		w.Header().Set("X-Request-Id", reqID)
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	req, status, code, msg := decodeTTSRequest(w, r)
+	if code != "" {
+		writeError(w, status, code, msg)
+		return
+	}
+	if req.Bitrate != 0 {
+		writeAPIError(w, http.StatusBadRequest, apiError{Code: "unsupported_bitrate", Message: "variants are rendered at every configured bitrate; leave bitrate unset", Field: "bitrate"})
+		return
+	}
+	if req.Format != "" && req.Format != "mp3" {
+		writeAPIError(w, http.StatusBadRequest, apiError{Code: "unsupported_format", Message: "variants are MP3; leave format unset or use mp3", Field: "format"})
+		return
+	}
+
+	provider := activeProvider()
+	rates := mp3Bitrates()
+	jobs := make([]*ttsJob, len(rates))
+	for i, kbps := range rates {
+		vreq := req
+		vreq.Bitrate = kbps
+		job, perr := prepareTTS(vreq, provider)
+		if perr != nil {
+			writeAPIError(w, perr.status, perr.apiError)
+			return
+		}
+		jobs[i] = job
+	}
+	// The clip is rendered once as WAV, so each rendition is encoded from
+	// lossless audio.
+	baseReq := req
+	baseReq.Format = "wav"
+	base, perr := prepareTTS(baseReq, provider)
+	if perr != nil {
+		writeAPIError(w, perr.status, perr.apiError)
+		return
+	}
+
+	ctx := r.Context()
+	recordRunes(ctx, len([]rune(base.req.Text)))
+	entry, err := synthesizeJob(ctx, base)
+	if serr := synthesisError(ctx, base.provider, err); serr != nil {
+		writeAPIError(w, serr.status, serr.apiError)
+		return
+	}
+
+	resp := variantsResponse{Provider: entry.provider, Variants: make([]audioVariant, len(jobs))}
+	if d, ok := audioDuration(entry.data, entry.contentType); ok {
+		resp.DurationMs = d.Milliseconds()
+	}
+	for i, job := range jobs {
+		v, err := encodeVariant(ctx, job, entry)
+		if err != nil {
+			logFrom(ctx).Error("variant encoding failed", "bitrate", job.req.Bitrate, "err", err)
+			writeAPIError(w, http.StatusInternalServerError, synthesisFailed(err))
+			return
+		}
+		q := requestQuery(req)
+		q.Set("bitrate", strconv.Itoa(job.req.Bitrate))
+		resp.Variants[i] = audioVariant{Bitrate: job.req.Bitrate, URL: "/api/tts?" + q.Encode(), ContentType: v.contentType, Bytes: len(v.data)}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// encodeVariant returns job's rendition from the cache, or encodes it from
+// base and caches it. As elsewhere, audio from a fallback provider isn't
+// cached.
+func encodeVariant(ctx context.Context, job *ttsJob, base *cachedAudio) (*cachedAudio, error) {
+	if entry, ok := ttsCache.get(job.key); ok {
+		return entry, nil
+	}
+	var out bytes.Buffer
+	if err := encodeMP3(ctx, &out, bytes.NewReader(base.data), job.req.Bitrate); err != nil {
+		return nil, err
+	}
+	data, contentType := out.Bytes(), audioContentTypes["mp3"]
+	entry := &cachedAudio{key: job.key, data: data, contentType: contentType, provider: base.provider, lang: base.lang,
+		loudness: base.loudness, bitrate: job.req.Bitrate, info: completeInfo(base.info, data, contentType)}
+	if base.provider == job.provider {
+		ttsCache.add(entry)
+	}
+	return entry, nil
+}
+
+// requestQuery is the inverse of queryRequest for the fields that shape the
+// audio, so a rendition's URL reaches the same cache key. Format is always
+// mp3.
+func requestQuery(req ttsRequest) url.Values {
+	q := url.Values{}
+	for name, v := range map[string]string{
+		"text": req.Text, "lang": req.Lang, "granularity": req.Granularity, "provider": req.Provider,
+		"gender": req.Gender, "voiceVariant": req.VoiceVariant, "transliterate": req.Transliterate, "name": req.Name,
+	} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	if len(req.Texts) > 0 {
+		q["texts"] = req.Texts
+	}
+	for name, v := range map[string]bool{"ssml": req.SSML, "download": req.Download} {
+		if v {
+			q.Set(name, "true")
+		}
+	}
+	for name, v := range map[string]int{
+		"sampleRateHertz": req.SampleRateHertz, "channels": req.Channels, "segmentPauseMs": req.SegmentPauseMs,
+		"leadSilenceMs": req.LeadSilenceMs, "trailSilenceMs": req.TrailSilenceMs, "bitrate": req.Bitrate,
+	} {
+		if v != 0 {
+			q.Set(name, strconv.Itoa(v))
+		}
+	}
+	for name, v := range map[string]float64{"rate": req.Rate, "pitch": req.Pitch, "volume": req.Volume} {
+		if v != 0 {
+			q.Set(name, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	q.Set("format", "mp3")
+	return q
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVariants(t *testing.T) {
+	t.Setenv("TTS_PROVIDER", "fake")
+	t.Setenv("TTS_MP3_BITRATES", "96k, 48k")
+	withCache(t, newAudioCache(16, 1<<20))
+	// A stand-in for ffmpeg that "encodes" by echoing its bitrate.
+	stubBinary(t, &ffmpegBin, `cat >/dev/null
+while [ "$1" != "-b:a" ]; do shift; done
+printf 'mp3 at %s' "$2"
+`)
+
+	rec := httptest.NewRecorder()
+	handleTTSVariants(rec, newTTSRequest(`{"text":"राम","lang":"deva","rate":1.5}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp variantsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Provider != "fake" || resp.DurationMs == 0 || len(resp.Variants) != 2 {
+		t.Fatalf("manifest %+v, want two fake renditions with a duration", resp)
+	}
+	for i, kbps := range []int{48, 96} {
+		v := resp.Variants[i]
+		want := "mp3 at " + strconv.Itoa(kbps) + "k"
+		if v.Bitrate != kbps || v.ContentType != "audio/mpeg" || v.Bytes != len(want) {
+			t.Errorf("variant %d = %+v, want %d kbps", i, v, kbps)
+		}
+		// The link is answered from the cache with the encoded rendition.
+		rec := httptest.NewRecorder()
+		handleTTS(rec, httptest.NewRequest(http.MethodGet, v.URL, nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("X-TTS-Cache") != "hit" || rec.Body.String() != want {
+			t.Errorf("GET %s: status %d, cache %q, body %q; want a hit with %q", v.URL, rec.Code, rec.Header().Get("X-TTS-Cache"), rec.Body, want)
+		}
+	}
+
+	for body, want := range map[string]string{
+		`{"text":"राम","lang":"deva","bitrate":48}`:   `"code":"unsupported_bitrate","message":"variants are rendered at every configured bitrate; leave bitrate unset","field":"bitrate"`,
+		`{"text":"राम","lang":"deva","format":"ogg"}`: `"code":"unsupported_format","message":"variants are MP3; leave format unset or use mp3","field":"format"`,
+	} {
+		rec := httptest.NewRecorder()
+		handleTTSVariants(rec, newTTSRequest(body))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: status %d %s, want 400 %s", body, rec.Code, rec.Body, want)
+		}
+	}
+
+	// Rendered directly, a bitrate outside the list is refused and one in
+	// it is encoded once the clip is rendered.
+	rec = httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"सीता","lang":"deva","bitrate":64}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bitrate 64: status %d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handleTTS(rec, newTTSRequest(`{"text":"सीता","lang":"deva","bitrate":96}`))
+	if rec.Code != http.StatusOK || rec.Body.String() != "mp3 at 96k" || rec.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("bitrate 96: status %d, %q of %q", rec.Code, rec.Body, rec.Header().Get("Content-Type"))
+	}
+}
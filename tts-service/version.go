@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"os/exec"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// commit and buildTime fall back to the VCS stamp Go embeds in the binary.
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
+
+type versionResponse struct {
+	Version   string          `json:"version"`
+	Commit    string          `json:"commit,omitempty"`
+	BuildTime string          `json:"buildTime,omitempty"`
+	GoVersion string          `json:"goVersion"`
+	Provider  string          `json:"provider"`
+	Providers map[string]bool `json:"providers"` // provider -> usable on this host
+	Tools     map[string]bool `json:"tools"`     // external binary -> on PATH
+}
+
+// handleVersion reports which build is running and what it can use on this
+// host. Like the probes it needs no token.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	resp := versionResponse{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		Provider:  activeProvider(),
+		Providers: map[string]bool{},
+		Tools:     map[string]bool{},
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		resp.GoVersion = info.GoVersion
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && resp.Commit == "":
+				resp.Commit = s.Value
+			case s.Key == "vcs.time" && resp.BuildTime == "":
+				resp.BuildTime = s.Value
+			}
+		}
+	}
+
+	for name := range providers {
+		resp.Providers[name] = len(providerMissing(name)) == 0
+	}
+	for _, tool := range []string{espeakBin, sayBin, ffmpegBin, piperBin} {
+		_, err := exec.LookPath(tool)
+		resp.Tools[tool] = err == nil
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
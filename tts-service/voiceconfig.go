@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// voiceMapping is the voice one provider uses for one language. Voice is
+// the provider's own voice name (a Sarvam speaker, a Polly voice ID, an
+// Azure voice, an espeak voice, a say voice or a piper model path);
+// LanguageCode, where the provider takes one, overrides the BCP-47 code
+// derived from our language code. Voices lists further candidates, tried
+// in order when the provider reports the one before unavailable.
+type voiceMapping struct {
+	Voice        string   `json:"voice"`
+	Voices       []string `json:"voices,omitempty"`
+	LanguageCode string   `json:"languageCode"`
+}
+
+// candidates returns Voice followed by Voices.
+func (m voiceMapping) candidates() []string {
+	if m.Voice == "" {
+		return m.Voices
+	}
+	return append([]string{m.Voice}, m.Voices...)
+}
+
+// voiceConfig holds per-language voice choices from TTS_VOICE_CONFIG, a JSON
+// file keyed by our language codes and then by provider:
+//
+//	{"deva": {"sarvam": {"voice": "anushka"}, "polly": {"voice": "Kajal", "languageCode": "hi-IN"}}}
+//
+// With "voices": ["Kajal", "Aditi"] a request falls back to later voices
+// when the provider says one doesn't exist. A voice is only dropped for
+// later requests once it has failed that way voiceRetireFailures times in
+// a row, and stays dropped until the config is reloaded.
+//
+// A mapping takes precedence over the provider-wide environment overrides
+// (TTS_VOICE, POLLY_VOICE_ID, AZURE_TTS_VOICE, PIPER_MODEL) and the
+// built-in tables; anything it leaves out keeps the existing behaviour.
+type voiceConfig struct {
+	version  string // content hash, part of the cache key
+	mappings map[string]map[string]voiceMapping
+
+	mu       sync.Mutex
+	active   map[string]int // lang+"/"+provider -> index of the candidate in use
+	failures map[string]int // lang+"/"+provider+"/"+voice -> consecutive voice-not-found errors
+}
+
+// voiceRetireFailures is how many voice-not-found errors in a row retire
+// a configured voice, so one misread error can't drop it for everyone.
+const voiceRetireFailures = 3
+
+var currentVoiceConfig atomic.Pointer[voiceConfig]
+
+// loadVoiceConfig reads path and installs it as the current voice config. An
+// empty path clears it.
+func loadVoiceConfig(path string) error {
+	if path == "" {
+		currentVoiceConfig.Store(nil)
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var mappings map[string]map[string]voiceMapping
+	if err := json.Unmarshal(b, &mappings); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for lang, byProvider := range mappings {
+		for provider := range byProvider {
+			if _, ok := providers[provider]; !ok {
+				slog.Warn("voice config names an unknown provider", "lang", lang, "provider", provider)
+			}
+		}
+	}
+	sum := sha256.Sum256(b)
+	cfg := &voiceConfig{
+		version:  hex.EncodeToString(sum[:8]),
+		mappings: mappings,
+		active:   make(map[string]int),
+		failures: make(map[string]int),
+	}
+	currentVoiceConfig.Store(cfg)
+	slog.Info("voice config loaded", "path", path, "languages", len(mappings), "version", cfg.version)
+	return nil
+}
+
+// configuredVoice returns the voice mapping for lang and provider, or the
+// zero mapping when there is none. Voice is the candidate in use.
+func configuredVoice(lang, provider string) voiceMapping {
+	cfg := currentVoiceConfig.Load()
+	if cfg == nil {
+		return voiceMapping{}
+	}
+	m := cfg.mappings[lang][provider]
+	if cands := m.candidates(); len(cands) > 0 {
+		cfg.mu.Lock()
+		m.Voice = cands[cfg.active[lang+"/"+provider]]
+		cfg.mu.Unlock()
+	}
+	return m
+}
+
+// voiceUnavailableError is a provider error saying voice doesn't exist or
+// can't be used.
+type voiceUnavailableError struct {
+	voice string
+	err   error
+}
+
+func (e *voiceUnavailableError) Error() string {
+	return fmt.Sprintf("voice %q unavailable: %v", e.voice, e.err)
+}
+
+func (e *voiceUnavailableError) Unwrap() error { return e.err }
+
+// voiceError wraps err as a voiceUnavailableError when provider's HTTP
+// error response is its specific "voice not found" error. Errors that
+// merely mention a voice, such as an out-of-range ElevenLabs voice
+// setting, are left alone.
+func voiceError(provider string, status int, body []byte, voice string, err error) error {
+	if voiceNotFound(provider, status, body) {
+		return &voiceUnavailableError{voice: voice, err: err}
+	}
+	return err
+}
+
+// voiceNotFound reports whether an error response is provider's error for
+// an unknown voice. Azure's 400 carries no body, so its caller checks the
+// voice list instead.
+func voiceNotFound(provider string, status int, body []byte) bool {
+	switch provider {
+	case "elevenlabs":
+		// {"detail": {"status": "voice_not_found", "message": "..."}}
+		var e struct {
+			Detail struct {
+				Status string `json:"status"`
+			} `json:"detail"`
+		}
+		return status == http.StatusNotFound && json.Unmarshal(body, &e) == nil && e.Detail.Status == "voice_not_found"
+	case "openai":
+		// {"error": {"message": "...", "type": "invalid_request_error", "param": "voice"}}
+		var e struct {
+			Error struct {
+				Param string `json:"param"`
+			} `json:"error"`
+		}
+		return status == http.StatusBadRequest && json.Unmarshal(body, &e) == nil && e.Error.Param == "voice"
+	case "sarvam":
+		// Validation errors list each bad field on its own line:
+		// {"error": {"code": "invalid_request_error", "message": "Validation Error(s):\n- speaker: Input should be ..."}}
+		var e struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		return status == http.StatusBadRequest && json.Unmarshal(body, &e) == nil &&
+			e.Error.Code == "invalid_request_error" && strings.Contains(e.Error.Message, "- speaker:")
+	}
+	return false
+}
+
+// nextConfiguredVoice returns the candidate after voice in the voice
+// config for lang and provider, reporting whether there is one. It only
+// picks the voice for the current request; see voiceFailed.
+func nextConfiguredVoice(lang, provider, voice string) (string, bool) {
+	cfg := currentVoiceConfig.Load()
+	if cfg == nil {
+		return "", false
+	}
+	cands := cfg.mappings[lang][provider].candidates()
+	i := slices.Index(cands, voice)
+	if i < 0 || i+1 >= len(cands) {
+		return "", false
+	}
+	return cands[i+1], true
+}
+
+// voiceFailed counts a voice-not-found error for voice, retiring the
+// candidate in use for lang and provider once it has failed
+// voiceRetireFailures times in a row.
+func voiceFailed(lang, provider, voice string) {
+	cfg := currentVoiceConfig.Load()
+	if cfg == nil {
+		return
+	}
+	cands := cfg.mappings[lang][provider].candidates()
+	key := lang + "/" + provider
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.failures[key+"/"+voice]++
+	for i := cfg.active[key]; i+1 < len(cands) && cfg.failures[key+"/"+cands[i]] >= voiceRetireFailures; i++ {
+		slog.Warn("retiring configured voice", "lang", lang, "provider", provider, "voice", cands[i], "next", cands[i+1])
+		cfg.active[key] = i + 1
+	}
+}
+
+// voiceSucceeded clears the failure count for voice.
+func voiceSucceeded(lang, provider, voice string) {
+	cfg := currentVoiceConfig.Load()
+	if cfg == nil {
+		return
+	}
+	cfg.mu.Lock()
+	delete(cfg.failures, lang+"/"+provider+"/"+voice)
+	cfg.mu.Unlock()
+}
+
+// voiceConfigVersion identifies the current voice config for cache keys.
+func voiceConfigVersion() string {
+	if cfg := currentVoiceConfig.Load(); cfg != nil {
+		return cfg.version
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestVoiceConfigOverridesBuiltins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "voices.json")
+	cfg := `{"deva": {"polly": {"voice": "Kajal", "languageCode": "hi-IN"}, "piper": {"voice": "/models/hi.onnx"}}}`
+	if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POLLY_VOICE_ID", "Aditi")
+	before := cacheKey("नमः", ttsRequest{Lang: "deva"}, "polly")
+	if err := loadVoiceConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { loadVoiceConfig("") })
+
+	if got := pollyVoiceID("deva", "standard"); got != "Kajal" {
+		t.Errorf("polly voice for deva = %q, want the configured Kajal", got)
+	}
+	if got := pollyVoiceID("tam", "standard"); got != "Aditi" {
+		t.Errorf("polly voice for unmapped tam = %q, want POLLY_VOICE_ID", got)
+	}
+	if got := piperModel("deva"); got != "/models/hi.onnx" {
+		t.Errorf("piper model = %q", got)
+	}
+	if cacheKey("नमः", ttsRequest{Lang: "deva"}, "polly") == before {
+		t.Error("cache key unchanged by the voice config")
+	}
+}
+
+func TestVoiceCandidatesFallBack(t *testing.T) {
+	var tried []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Voice string }
+		json.NewDecoder(r.Body).Decode(&body)
+		tried = append(tried, body.Voice)
+		if body.Voice != "nova" {
+			http.Error(w, `{"error":{"message":"Invalid voice","type":"invalid_request_error","param":"voice"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("ID3 audio"))
+	}))
+	defer api.Close()
+	t.Setenv("TTS_PROVIDER", "openai")
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_BASE_URL", api.URL)
+	t.Setenv("OPENAI_MAX_RETRIES", "0")
+	withCache(t, newAudioCache(0, 0))
+
+	path := filepath.Join(t.TempDir(), "voices.json")
+	cfg := `{"deva": {"openai": {"voice": "retired", "voices": ["gone", "nova", "alloy"]}}}`
+	if err := os.WriteFile(path, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadVoiceConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { loadVoiceConfig("") })
+
+	for i := 0; i <= voiceRetireFailures; i++ {
+		rec := httptest.NewRecorder()
+		handleTTS(rec, newTTSRequest(`{"text":"नमः","lang":"deva"}`))
+		if rec.Code != http.StatusOK || rec.Header().Get("X-TTS-Voice") != "nova" {
+			t.Fatalf("request %d: status %d, voice %q", i, rec.Code, rec.Header().Get("X-TTS-Voice"))
+		}
+	}
+	// Each request falls back on its own until the dead voices have failed
+	// voiceRetireFailures times; after that they are skipped.
+	var want []string
+	for i := 0; i < voiceRetireFailures; i++ {
+		want = append(want, "retired", "gone", "nova")
+	}
+	want = append(want, "nova")
+	if !slices.Equal(tried, want) {
+		t.Errorf("tried %v, want %v", tried, want)
+	}
+}
+
+func TestVoiceNotFound(t *testing.T) {
+	tests := []struct {
+		provider string
+		status   int
+		body     string
+		want     bool
+	}{
+		{"elevenlabs", 404, `{"detail":{"status":"voice_not_found","message":"A voice with the voice_id x was not found."}}`, true},
+		{"elevenlabs", 400, `{"detail":{"status":"invalid_voice_settings","message":"voice stability out of range"}}`, false},
+		{"openai", 400, `{"error":{"message":"Invalid voice","type":"invalid_request_error","param":"voice"}}`, true},
+		{"openai", 400, `{"error":{"message":"voice is fine, speed is not","param":"speed"}}`, false},
+		{"sarvam", 400, `{"error":{"code":"invalid_request_error","message":"Validation Error(s):\n- speaker: Input should be 'anushka'"}}`, true},
+		{"sarvam", 400, `{"error":{"code":"invalid_request_error","message":"Validation Error(s):\n- pitch: speaker pitch too high"}}`, false},
+		{"sarvam", 404, `speaker not found`, false},
+		{"azure", 400, `voice`, false},
+	}
+	for _, tt := range tests {
+		if got := voiceNotFound(tt.provider, tt.status, []byte(tt.body)); got != tt.want {
+			t.Errorf("voiceNotFound(%s, %d, %s) = %v, want %v", tt.provider, tt.status, tt.body, got, tt.want)
+		}
+	}
+}
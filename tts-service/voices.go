@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// voiceInfo describes one voice offered by a provider.
+type voiceInfo struct {
+	Name      string   `json:"name"`
+	Languages []string `json:"languages"`
+	Gender    string   `json:"gender,omitempty"`
+}
+
+type voicesResponse struct {
+	Provider string      `json:"provider"`
+	Voices   []voiceInfo `json:"voices"`
+}
+
+// handleVoices lists the voices of the active provider, optionally filtered
+// by one of our language codes via ?lang=.
+func handleVoices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	provider := activeProvider()
+	voices, err := listVoices(ctx, provider)
+	if err != nil {
+		logFrom(r.Context()).Error("voices error", "provider", provider, "err", err)
+		writeError(w, http.StatusInternalServerError, "voices_unavailable", "voices unavailable")
+		return
+	}
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		voices = filterVoices(voices, providerLanguage(provider, lang))
+	}
+
+	writeJSON(w, http.StatusOK, voicesResponse{Provider: provider, Voices: voices})
+}
+
+// listVoices returns every voice the provider offers.
+func listVoices(ctx context.Context, provider string) ([]voiceInfo, error) {
+	switch provider {
+	case "sarvam":
+		return sarvamVoices(), nil
+	case "polly":
+		return pollyVoices(ctx)
+	case "azure":
+		return azureVoiceList(ctx)
+	case "piper":
+		return piperVoices(), nil
+	case "openai":
+		return openAIVoices(), nil
+	case "elevenlabs":
+		return elevenLabsVoiceList(ctx)
+	case "fake":
+		return []voiceInfo{{Name: "fake", Languages: langCodes()}}, nil
+	case "mac":
+		out, err := exec.CommandContext(ctx, sayBin, "-v", "?").Output()
+		if err != nil {
+			return nil, err
+		}
+		return parseMacVoices(out), nil
+	default:
+		out, err := exec.CommandContext(ctx, espeakBin, "--voices").Output()
+		if err != nil {
+			return nil, err
+		}
+		return parseEspeakVoices(out), nil
+	}
+}
+
+// parseEspeakVoices parses `espeak-ng --voices`, whose columns are
+// Pty, Language, Age/Gender, VoiceName, File and Other Languages.
+func parseEspeakVoices(out []byte) []voiceInfo {
+	var voices []voiceInfo
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || fields[0] == "Pty" {
+			continue
+		}
+		v := voiceInfo{Name: fields[3], Languages: []string{fields[1]}}
+		if _, gender, ok := strings.Cut(fields[2], "/"); ok {
+			switch gender {
+			case "M":
+				v.Gender = "male"
+			case "F":
+				v.Gender = "female"
+			}
+		}
+		for _, other := range fields[5:] {
+			// Other languages are listed as "(lang priority)" pairs.
+			if other = strings.Trim(other, "()"); other != "" && !isDigits(other) {
+				v.Languages = append(v.Languages, other)
+			}
+		}
+		voices = append(voices, v)
+	}
+	return voices
+}
+
+// macVoiceLine matches `say -v ?` lines such as "Lekha  hi_IN  # नमस्ते…".
+var macVoiceLine = regexp.MustCompile(`^(.+?)\s+([a-z]{2,3}[_-][A-Za-z0-9]+)\s+#`)
+
+// parseMacVoices parses the output of `say -v ?`.
+func parseMacVoices(out []byte) []voiceInfo {
+	var voices []voiceInfo
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		m := macVoiceLine.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		voices = append(voices, voiceInfo{Name: strings.TrimSpace(m[1]), Languages: []string{m[2]}})
+	}
+	return voices
+}
+
+// sarvamVoices reports the configured Sarvam speaker for each language we map.
+// Sarvam has no voice listing endpoint; every speaker covers all languages.
+func sarvamVoices() []voiceInfo {
+	langs := []string{}
+	seen := map[string]bool{}
+	for _, l := range languages {
+		if code := l.bcp47; !seen[code] {
+			seen[code] = true
+			langs = append(langs, code)
+		}
+	}
+	return []voiceInfo{{Name: "amit", Languages: langs, Gender: "male"}}
+}
+
+// providerLanguage maps one of our language codes to the provider's own
+// language code, as used for filtering voices.
+func providerLanguage(provider, lang string) string {
+	if provider == "espeak" {
+		return espeakVoice(lang)
+	}
+	return sarvamLangCode(lang)
+}
+
+// filterVoices keeps voices that speak the primary language of code, so
+// "hi-IN" matches espeak's "hi" and mac's "hi_IN".
+func filterVoices(voices []voiceInfo, code string) []voiceInfo {
+	want := primaryLanguage(code)
+	filtered := []voiceInfo{}
+	for _, v := range voices {
+		for _, l := range v.Languages {
+			if primaryLanguage(l) == want {
+				filtered = append(filtered, v)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func primaryLanguage(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	return code
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxWarmupJobs bounds how many warmup jobs are remembered for polling; the
+// oldest finished job is forgotten first.
+const maxWarmupJobs = 32
+
+// warmupJob tracks a background warmup. Its fields are guarded by
+// warmupJobs.mu.
+type warmupJob struct {
+	ID       string     `json:"id"`
+	State    string     `json:"state"` // running or done
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Cached   int        `json:"cached"` // already cached before the warmup
+	Failed   int        `json:"failed"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+var warmupJobs = struct {
+	mu    sync.Mutex
+	jobs  map[string]*warmupJob
+	order []string
+}{jobs: map[string]*warmupJob{}}
+
+// handleWarmup starts synthesizing a list of items into the cache in the
+// background (POST /admin/warmup, same body as /api/tts/batch) and reports
+// progress (GET /admin/warmup/{id}). Items are synthesized one at a time so
+// warmup holds at most one local synthesis slot and live requests keep the
+// rest. Like the other admin endpoints it is only registered behind
+// requireAdmin.
+func handleWarmup(w http.ResponseWriter, r *http.Request) {
+	if id := strings.TrimPrefix(r.URL.Path, "/admin/warmup/"); id != r.URL.Path && id != "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+		warmupJobs.mu.Lock()
+		job, ok := warmupJobs.jobs[id]
+		var snapshot warmupJob
+		if ok {
+			snapshot = *job
+		}
+		warmupJobs.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "no such warmup job")
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+
+	maxItems := envInt("TTS_WARMUP_MAX", 1000)
+	limit := envInt64("TTS_MAX_BODY_BYTES", defaultMaxBodyBytes) * int64(max(1, maxItems))
+	var batch batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(&batch); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body exceeds %d bytes", limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_json", "invalid JSON")
+		return
+	}
+	if len(batch.Items) == 0 {
+		writeError(w, http.StatusBadRequest, "items_required", "items are required")
+		return
+	}
+	if len(batch.Items) > maxItems {
+		writeError(w, http.StatusBadRequest, "batch_too_large", fmt.Sprintf("at most %d items per warmup", maxItems))
+		return
+	}
+
+	job := startWarmup(batch.Items, activeProvider(), logFrom(r.Context()))
+	w.Header().Set("Location", "/admin/warmup/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// startWarmup registers a job for items and runs it in the background under
+// synthesisBase, so shutdown stops it. It returns a snapshot of the new job.
+func startWarmup(items []ttsRequest, provider string, logger *slog.Logger) warmupJob {
+	var b [8]byte
+	rand.Read(b[:])
+	job := &warmupJob{ID: hex.EncodeToString(b[:]), State: "running", Total: len(items), Started: time.Now().UTC()}
+
+	warmupJobs.mu.Lock()
+	warmupJobs.jobs[job.ID] = job
+	warmupJobs.order = append(warmupJobs.order, job.ID)
+	for i := 0; len(warmupJobs.jobs) > maxWarmupJobs && i < len(warmupJobs.order); i++ {
+		if old := warmupJobs.jobs[warmupJobs.order[i]]; old.State == "done" {
+			delete(warmupJobs.jobs, old.ID)
+			warmupJobs.order = append(warmupJobs.order[:i], warmupJobs.order[i+1:]...)
+			i--
+		}
+	}
+	snapshot := *job
+	warmupJobs.mu.Unlock()
+
+	logger = logger.With("warmup", job.ID)
+	logger.Info("warmup started", "items", len(items))
+	go func() {
+		ctx := withLogger(synthesisBase, logger)
+		for _, item := range items {
+			cached, err := warmItem(ctx, item, provider)
+			if err != nil {
+				logger.Warn("warmup item failed", "err", err)
+			}
+			warmupJobs.mu.Lock()
+			job.Done++
+			switch {
+			case err != nil:
+				job.Failed++
+			case cached:
+				job.Cached++
+			}
+			warmupJobs.mu.Unlock()
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		warmupJobs.mu.Lock()
+		finished := time.Now().UTC()
+		job.State, job.Finished = "done", &finished
+		done, failed := job.Done, job.Failed
+		warmupJobs.mu.Unlock()
+		logger.Info("warmup finished", "done", done, "failed", failed)
+	}()
+	return snapshot
+}
+
+// warmItem synthesizes one item into the cache, reporting whether it was
+// cached already.
+func warmItem(ctx context.Context, item ttsRequest, provider string) (bool, error) {
+	job, perr := prepareTTS(item, provider)
+	if perr != nil {
+		return false, errors.New(perr.Message)
+	}
+	if _, ok := ttsCache.get(job.key); ok {
+		return true, nil
+	}
+	_, err := synthesizeJob(ctx, job)
+	return false, err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// wavAudio is a parsed RIFF/WAVE file reduced to its format and sample data.
+type wavAudio struct {
+	format []byte // raw "fmt " chunk payload
+	data   []byte // raw "data" chunk payload
+}
+
+// parseWAV extracts the fmt and data chunks from a WAV file. Streamed WAVs
+// (such as espeak-ng --stdout) carry placeholder sizes, so chunk lengths are
+// clamped to the bytes actually present.
+func parseWAV(b []byte) (*wavAudio, error) {
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return nil, errors.New("not a RIFF/WAVE file")
+	}
+	var wav wavAudio
+	for off := 12; off+8 <= len(b); {
+		id := string(b[off : off+4])
+		size := int(binary.LittleEndian.Uint32(b[off+4 : off+8]))
+		body := off + 8
+		if size > len(b)-body {
+			size = len(b) - body
+		}
+		switch id {
+		case "fmt ":
+			wav.format = b[body : body+size]
+		case "data":
+			wav.data = b[body : body+size]
+		}
+		off = body + size + size%2
+	}
+	if wav.format == nil || wav.data == nil {
+		return nil, errors.New("wav missing fmt or data chunk")
+	}
+	return &wav, nil
+}
+
+// bytes serializes the audio as a canonical WAV file with correct chunk sizes.
+func (w *wavAudio) bytes() []byte {
+	pad := len(w.data) % 2
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+8+len(w.format)+8+len(w.data)+pad))
+	out.WriteString("WAVE")
+	out.WriteString("fmt ")
+	binary.Write(&out, binary.LittleEndian, uint32(len(w.format)))
+	out.Write(w.format)
+	out.WriteString("data")
+	binary.Write(&out, binary.LittleEndian, uint32(len(w.data)))
+	out.Write(w.data)
+	if pad == 1 {
+		out.WriteByte(0)
+	}
+	return out.Bytes()
+}
+
+// concatWAV joins WAV clips that share the same sample format into one file,
+// with gap of silence between consecutive clips.
+func concatWAV(clips [][]byte, gap time.Duration) ([]byte, error) {
+	var joined wavAudio
+	for i, clip := range clips {
+		wav, err := parseWAV(clip)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			joined.format = wav.format
+		} else if !bytes.Equal(joined.format, wav.format) {
+			return nil, errors.New("wav clips have mismatched formats")
+		} else if gap > 0 {
+			joined.data = append(joined.data, wav.silence(gap)...)
+		}
+		joined.data = append(joined.data, wav.data...)
+	}
+	return joined.bytes(), nil
+}
+
+// silence returns d of silent sample data in the clip's format, rounded to
+// whole frames so the channels stay aligned.
+func (w *wavAudio) silence(d time.Duration) []byte {
+	if len(w.format) < 16 {
+		return nil
+	}
+	rate := int64(binary.LittleEndian.Uint32(w.format[4:8]))
+	blockAlign := int64(binary.LittleEndian.Uint16(w.format[12:14]))
+	bits := binary.LittleEndian.Uint16(w.format[14:16])
+	out := make([]byte, rate*int64(d)/int64(time.Second)*blockAlign)
+	if bits == 8 {
+		// 8-bit PCM is unsigned, centred on 128.
+		for i := range out {
+			out[i] = 0x80
+		}
+	}
+	return out
+}
+
+// streamingWAVHeader returns a 16-bit PCM WAV header for audio of unknown
+// length, using the same 0xFFFFFFFF placeholder sizes as espeak-ng --stdout.
+func streamingWAVHeader(sampleRate, channels int) []byte {
+	format := pcmFormat(sampleRate, channels)
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(0xFFFFFFFF))
+	out.WriteString("WAVE")
+	out.WriteString("fmt ")
+	binary.Write(&out, binary.LittleEndian, uint32(len(format)))
+	out.Write(format)
+	out.WriteString("data")
+	binary.Write(&out, binary.LittleEndian, uint32(0xFFFFFFFF))
+	return out.Bytes()
+}
+
+// pcmToWAV wraps raw 16-bit little-endian PCM, as returned by APIs that send
+// headerless audio, in a WAV file.
+func pcmToWAV(pcm []byte, sampleRate, channels int) []byte {
+	w := wavAudio{format: pcmFormat(sampleRate, channels), data: pcm}
+	return w.bytes()
+}
+
+// pcmFormat is the fmt chunk payload for 16-bit PCM.
+func pcmFormat(sampleRate, channels int) []byte {
+	const bits = 16
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&out, binary.LittleEndian, uint16(channels))
+	binary.Write(&out, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&out, binary.LittleEndian, uint32(sampleRate*channels*bits/8))
+	binary.Write(&out, binary.LittleEndian, uint16(channels*bits/8))
+	binary.Write(&out, binary.LittleEndian, uint16(bits))
+	return out.Bytes()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPCMToWAV(t *testing.T) {
+	// Half a second of 16 kHz mono PCM, as Polly returns for OutputFormat pcm.
+	pcm := make([]byte, 16000)
+	out := pcmToWAV(pcm, 16000, 1)
+	if !bytes.HasPrefix(out, []byte("RIFF")) || string(out[8:12]) != "WAVE" {
+		t.Fatalf("output starts with %q, want a RIFF/WAVE header", out[:12])
+	}
+	if got := binary.LittleEndian.Uint32(out[4:8]); int(got) != len(out)-8 {
+		t.Errorf("RIFF size = %d, want %d", got, len(out)-8)
+	}
+	wav, err := parseWAV(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format := binary.LittleEndian.Uint16(wav.format[0:2]); format != 1 {
+		t.Errorf("audio format = %d, want 1 (PCM)", format)
+	}
+	if !bytes.Equal(wav.data, pcm) {
+		t.Error("PCM data altered")
+	}
+	if d, ok := audioDuration(out, "audio/wav"); !ok || d != 500*time.Millisecond {
+		t.Errorf("duration = %v, %v; want 500ms", d, ok)
+	}
+}
+
+func TestPadSilenceWAV(t *testing.T) {
+	clip := pcmToWAV([]byte{1, 2, 3, 4}, 8000, 1)
+	out, err := padSilence(context.Background(), clip, "audio/wav", 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wav, err := parseWAV(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10ms and 5ms at 8 kHz, 16-bit mono: 160 and 80 bytes.
+	want := append(append(make([]byte, 160), 1, 2, 3, 4), make([]byte, 80)...)
+	if !bytes.Equal(wav.data, want) {
+		t.Errorf("padded data = %d bytes, want %d", len(wav.data), len(want))
+	}
+}
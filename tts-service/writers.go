@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// captureWriter passes audio through to the client while keeping a full copy.
+// Once the client goes away it keeps capturing, so requests sharing this
+// synthesis still receive the complete clip.
+// The synthesis info is reported in headers before the first byte goes out,
+// and every write is flushed so the client hears audio as it is produced.
+type captureWriter struct {
+	http.ResponseWriter
+	info        *synthesisInfo
+	buf         bytes.Buffer
+	err         error
+	wroteHeader bool
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if c.info != nil {
+			setSynthesisHeaders(c.Header(), *c.info)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.buf.Write(p)
+	if c.err == nil {
+		if _, err := c.ResponseWriter.Write(p); err != nil {
+			c.err = err
+		} else if f, ok := c.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return len(p), nil
+}
+
+// responseBuffer is an http.ResponseWriter that buffers the whole response,
+// letting a synthesizer's output be post-processed before it reaches the client.
+type responseBuffer struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header { return b.header }
+
+func (b *responseBuffer) WriteHeader(status int) { b.status = status }
+
+func (b *responseBuffer) Write(p []byte) (int, error) { return b.buf.Write(p) }
+
+// pipeResponseWriter feeds a synthesizer's audio into a pipe for further
+// processing. Its headers are discarded.
+type pipeResponseWriter struct {
+	header http.Header
+	w      io.Writer
+}
+
+func (p *pipeResponseWriter) Header() http.Header { return p.header }
+
+func (p *pipeResponseWriter) WriteHeader(int) {}
+
+func (p *pipeResponseWriter) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+// statusWriter records the status code and body size written to the client.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes through so streamed audio isn't held back by the wrapper.
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// code returns the recorded status, defaulting to 200 when nothing was written.
+func (s *statusWriter) code() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}